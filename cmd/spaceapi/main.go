@@ -16,15 +16,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	_ "time/tzdata"
+	"unicode"
 
 	"github.com/gorilla/mux"
 	"github.com/q30-space/spaceapi-endpoint/internal/handlers"
+	"github.com/q30-space/spaceapi-endpoint/internal/metrics"
 	"github.com/q30-space/spaceapi-endpoint/internal/middleware"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
 	"github.com/q30-space/spaceapi-endpoint/internal/services"
 )
 
@@ -36,7 +49,9 @@ var (
 
 func main() {
 	var showVersion bool
+	var configPath string
 	flag.BoolVar(&showVersion, "version", false, "Show version information")
+	flag.StringVar(&configPath, "config", envOrDefault("SPACEAPI_CONFIG", services.DefaultConfigPath), "Path to the SpaceAPI JSON document")
 	flag.Parse()
 
 	if showVersion {
@@ -46,36 +61,375 @@ func main() {
 		os.Exit(0)
 	}
 	// Load initial SpaceAPI data
-	spaceAPI := services.LoadSpaceAPIData()
+	spaceAPI, err := services.LoadSpaceAPIData(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// API keys for the update endpoints
+	keys, err := middleware.LoadKeyStoreFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	if keys == nil {
+		log.Println("WARNING: No API keys configured, updates are disabled. Set SPACEAPI_KEYS_FILE or SPACEAPI_AUTH_KEY.")
+	} else {
+		middleware.SetKeyStore(keys)
+	}
+
+	// Proxies allowed to report the client address
+	proxies, err := middleware.ParseTrustedProxies(os.Getenv("SPACEAPI_TRUSTED_PROXIES"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	middleware.SetTrustedProxies(proxies)
+	forwardedHeader, err := middleware.ParseForwardedHeader(os.Getenv("SPACEAPI_FORWARDED_HEADER"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	middleware.SetForwardedHeader(forwardedHeader)
+
+	// Block clients after repeated authentication failures
+	limiter, err := newRateLimiter()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	defer limiter.Stop()
+	middleware.SetRateLimiter(limiter)
+
+	// Write runtime updates back to the configuration file
+	persister := services.NewPersister(configPath, services.DefaultSaveDelay)
+
+	// Live document shared by all handlers
+	store := services.NewStore(spaceAPI, persister)
+
+	// Change notifications for streaming clients
+	bus := services.NewBus(services.DefaultBusHistory)
 
 	// Create handlers
-	spaceAPIHandler := handlers.NewSpaceAPIHandler(spaceAPI)
+	spaceAPIHandler := handlers.NewSpaceAPIHandler(store, bus)
+	if cacheControl := os.Getenv("SPACEAPI_CACHE_CONTROL"); cacheControl != "" {
+		spaceAPIHandler.SetCacheControl(cacheControl)
+	}
+	pageTemplates, err := handlers.LoadPageTemplates(os.Getenv("SPACEAPI_TEMPLATES_DIR"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	statusPageHandler := handlers.NewStatusPageHandler(store, pageTemplates, spaceAPIHandler.GetSpaceAPI)
+	iconHandler := handlers.NewIconHandler(store, services.NewIconCache(services.DefaultIconTTL))
+	iconHandler.SetProxy(os.Getenv("SPACEAPI_ICON_PROXY") == "true")
+	badgeHandler := handlers.NewBadgeHandler(store)
+	if cacheControl := os.Getenv("SPACEAPI_BADGE_CACHE_CONTROL"); cacheControl != "" {
+		badgeHandler.SetCacheControl(cacheControl)
+	}
+
+	// Notify other services when the space opens or closes
+	dispatcher, err := newWebhookDispatcher(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	if dispatcher != nil {
+		dispatcher.Start()
+		dispatcher.Watch(bus, store)
+		defer dispatcher.Stop()
+	}
+
+	// Reload the static parts of the document on SIGHUP or when the file changes
+	reload := func(reason string) {
+		changed, err := services.ReloadSpaceAPIData(store, configPath, func(spaceAPI *models.SpaceAPI) {
+			bus.Publish(services.ChangeReload, spaceAPI, nil)
+		})
+		if err != nil {
+			log.Printf("ERROR: Not reloading %s after %s: %v", configPath, reason, err)
+			return
+		}
+		if changed {
+			log.Printf("Reloaded %s after %s", configPath, reason)
+		}
+	}
+	persister.SetOnEdit(func() {
+		reload("edit before save")
+	})
+	watcher := services.NewFileWatcher(configPath, services.DefaultWatchInterval, func() {
+		reload("file change")
+	})
+	watcher.IgnoreWritesOf(persister)
+	watcher.Start()
+	defer watcher.Stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload("SIGHUP")
+		}
+	}()
 
-	// Create router
+	// Prometheus metrics
+	registry := metrics.NewRegistry()
+	httpMetrics := metrics.NewHTTPMetrics(registry)
+	metrics.RegisterSpaceAPI(registry, store)
+	metrics.RegisterAuth(registry)
+	quit := make(chan struct{})
+	defer close(quit)
+	metrics.CountEvents(registry, bus, quit)
+
+	// Record every open/close transition
+	stateHistory, err := services.NewStateHistory(envOrDefault("SPACEAPI_STATE_HISTORY", filepath.Join(filepath.Dir(configPath), "state-history.jsonl")))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	stateHistory.Watch(bus, quit)
+
+	// Record every event, as the document only keeps the latest
+	eventHistory, err := services.NewEventHistory(envOrDefault("SPACEAPI_EVENT_HISTORY", filepath.Join(filepath.Dir(configPath), "event-history.jsonl")))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	eventHistory.Watch(bus, quit)
+
+	// Record sensor readings as time series
+	sensorHistory, err := newSensorHistory(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+	sensorHistory.Watch(bus, quit)
+	sensorHistory.Start()
+	defer sensorHistory.Stop()
+	historyHandler := handlers.NewHistoryHandler(stateHistory, sensorHistory)
+	statsHandler := handlers.NewStatsHandler(store, stateHistory)
+	feedHandler := handlers.NewFeedHandler(store, stateHistory, eventHistory)
+
+	// Cross-origin access, open for reading and closed for writing unless
+	// configured otherwise
+	readCORS, writeCORS, err := corsPolicies()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Create router. Routes list OPTIONS so their CORS policy answers
+	// preflight requests.
 	r := mux.NewRouter()
+	r.Use(httpMetrics.Middleware)
 
 	// Public API routes (no authentication required)
-	r.HandleFunc("/api/space", spaceAPIHandler.GetSpaceAPI).Methods("GET")
-	r.HandleFunc("/", spaceAPIHandler.GetSpaceAPI).Methods("GET")
+	readRouter := r.NewRoute().Subrouter()
+	readRouter.Use(readCORS.Middleware)
+	readRouter.HandleFunc("/api/space", spaceAPIHandler.GetSpaceAPI).Methods("GET", "OPTIONS").MatcherFunc(middleware.PreflightFor("GET"))
+	readRouter.HandleFunc("/api/space/stream", spaceAPIHandler.StreamSpaceAPI).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/api/space/icon", iconHandler.Icon).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/api/space/history/state", historyHandler.StateHistory).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/api/space/sensors/{type}/history", historyHandler.SensorHistory).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/api/space/stats/opening", statsHandler.OpeningStats).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/api/space/feed.atom", feedHandler.Atom).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/api/space/feed.rss", feedHandler.RSS).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/", statusPageHandler.StatusPage).Methods("GET", "OPTIONS")
 
-	// Protected API routes (authentication required)
-	updateRouter := r.PathPrefix("/api/space").Subrouter()
-	updateRouter.Use(middleware.AuthMiddleware)
-	updateRouter.HandleFunc("/state", spaceAPIHandler.UpdateState).Methods("POST")
-	updateRouter.HandleFunc("/people", spaceAPIHandler.UpdatePeopleCount).Methods("POST")
-	updateRouter.HandleFunc("/event", spaceAPIHandler.AddEvent).Methods("POST")
+	// Status badges for websites and READMEs
+	readRouter.HandleFunc("/badge.svg", badgeHandler.StatusBadge).Methods("GET", "OPTIONS")
+	readRouter.HandleFunc("/badge/{sensor}.svg", badgeHandler.SensorBadge).Methods("GET", "OPTIONS")
 
 	// Health check
-	r.HandleFunc("/health", spaceAPIHandler.HealthCheck).Methods("GET")
+	readRouter.HandleFunc("/health", spaceAPIHandler.HealthCheck).Methods("GET", "OPTIONS")
+
+	// Metrics
+	readRouter.Handle("/metrics", registry.Handler()).Methods("GET", "OPTIONS")
 
-	// CORS middleware
-	r.Use(middleware.CORSMiddleware)
+	// Protected API routes (authentication required). CORS comes first so
+	// preflights and authentication errors carry its headers.
+	updateRouter := r.PathPrefix("/api/space").Subrouter()
+	updateRouter.Use(writeCORS.Middleware)
+	updateRouter.Use(middleware.AuthMiddleware)
+	updateRouter.Handle("", scoped(middleware.ScopeDocumentWrite, spaceAPIHandler.PatchSpaceAPI)).Methods("PATCH", "OPTIONS").MatcherFunc(middleware.PreflightFor("PATCH"))
+	updateRouter.Handle("/state", scoped(middleware.ScopeStateWrite, spaceAPIHandler.UpdateState)).Methods("POST", "OPTIONS")
+	updateRouter.Handle("/people", scoped(middleware.ScopePeopleWrite, spaceAPIHandler.UpdatePeopleCount)).Methods("POST", "OPTIONS")
+	updateRouter.Handle("/event", scoped(middleware.ScopeEventsWrite, spaceAPIHandler.AddEvent)).Methods("POST", "OPTIONS")
+	updateRouter.Handle("/sensors/{type}", scoped(middleware.ScopeSensorsWrite+":{type}", spaceAPIHandler.UpdateSensor)).Methods("POST", "OPTIONS")
+	if dispatcher != nil {
+		webhookHandler := handlers.NewWebhookHandler(dispatcher)
+		updateRouter.Handle("/webhooks/deliveries", scoped(middleware.ScopeAdmin, webhookHandler.ListDeliveries)).Methods("GET", "OPTIONS")
+	}
+	rateLimitHandler := handlers.NewRateLimitHandler(limiter)
+	updateRouter.Handle("/auth/blocks", scoped(middleware.ScopeAdmin, rateLimitHandler.ListBlocks)).Methods("GET", "OPTIONS").MatcherFunc(middleware.PreflightFor("GET"))
+	updateRouter.Handle("/auth/blocks", scoped(middleware.ScopeAdmin, rateLimitHandler.ClearBlocks)).Methods("DELETE", "OPTIONS").MatcherFunc(middleware.PreflightFor("DELETE"))
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("SpaceAPI server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	// Cancelled on shutdown to end open event streams
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+	server.RegisterOnShutdown(cancelBase)
+
+	// Shut down gracefully so pending updates are written to disk
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		log.Println("SpaceAPI server shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
+		}
+	}()
+
+	log.Printf("SpaceAPI server starting on port %s using %s", port, configPath)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	err = persister.Flush()
+	if errors.Is(err, services.ErrEditedOnDisk) {
+		reload("edit before save")
+		err = persister.Flush()
+	}
+	if err != nil {
+		log.Printf("Error saving %s: %v", configPath, err)
+	}
+}
+
+// scoped wraps handler so it is only reachable with a key granting scope
+func scoped(scope string, handler http.HandlerFunc) http.Handler {
+	return middleware.RequireScope(scope)(handler)
+}
+
+// newWebhookDispatcher configures webhooks from the environment. It returns
+// nil if no webhook URLs are set.
+func newWebhookDispatcher(configPath string) (*services.WebhookDispatcher, error) {
+	urls := strings.FieldsFunc(os.Getenv("SPACEAPI_WEBHOOK_URLS"), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	return services.NewWebhookDispatcher(services.WebhookConfig{
+		URLs:      urls,
+		Secret:    os.Getenv("SPACEAPI_WEBHOOK_SECRET"),
+		QueuePath: envOrDefault("SPACEAPI_WEBHOOK_QUEUE", filepath.Join(filepath.Dir(configPath), "webhooks.json")),
+	})
+}
+
+// newRateLimiter configures the authentication rate limit from the
+// environment. Unset values keep the defaults.
+func newRateLimiter() (*middleware.RateLimiter, error) {
+	config := middleware.RateLimitConfig{
+		StatePath: os.Getenv("SPACEAPI_RATE_LIMIT_STATE"),
+	}
+	var err error
+	if config.MaxAttempts, err = envInt("SPACEAPI_RATE_LIMIT_ATTEMPTS"); err != nil {
+		return nil, err
+	}
+	if config.Window, err = envDuration("SPACEAPI_RATE_LIMIT_WINDOW"); err != nil {
+		return nil, err
+	}
+	if config.BlockDuration, err = envDuration("SPACEAPI_RATE_LIMIT_BLOCK"); err != nil {
+		return nil, err
+	}
+	if config.IPv6PrefixLength, err = envInt("SPACEAPI_RATE_LIMIT_IPV6_PREFIX"); err != nil {
+		return nil, err
+	}
+	return middleware.NewRateLimiter(config)
+}
+
+// newSensorHistory configures the sensor time series from the environment
+func newSensorHistory(configPath string) (*services.SensorHistory, error) {
+	config := services.SeriesConfig{
+		Path: envOrDefault("SPACEAPI_SENSOR_HISTORY", filepath.Join(filepath.Dir(configPath), "sensor-history.json")),
+	}
+	var err error
+	if config.RawRetention, err = envDuration("SPACEAPI_SENSOR_RETENTION_RAW"); err != nil {
+		return nil, err
+	}
+	if config.FiveMinuteRetention, err = envDuration("SPACEAPI_SENSOR_RETENTION_5M"); err != nil {
+		return nil, err
+	}
+	if config.HourlyRetention, err = envDuration("SPACEAPI_SENSOR_RETENTION_HOURLY"); err != nil {
+		return nil, err
+	}
+	return services.NewSensorHistory(config)
+}
+
+// corsPolicies configures the CORS policies of the read and write routes
+// from the environment
+func corsPolicies() (read, write middleware.CORSPolicy, err error) {
+	read = middleware.DefaultReadCORS()
+	write = middleware.DefaultWriteCORS()
+
+	if spec := os.Getenv("SPACEAPI_CORS_ORIGINS"); spec != "" {
+		if read.AllowedOrigins, err = middleware.ParseOrigins(spec); err != nil {
+			return read, write, err
+		}
+	}
+	if write.AllowedOrigins, err = middleware.ParseOrigins(os.Getenv("SPACEAPI_CORS_WRITE_ORIGINS")); err != nil {
+		return read, write, err
+	}
+	write.AllowCredentials = os.Getenv("SPACEAPI_CORS_WRITE_CREDENTIALS") == "true"
+
+	maxAge, err := envDuration("SPACEAPI_CORS_MAX_AGE")
+	if err != nil {
+		return read, write, err
+	}
+	if maxAge > 0 {
+		read.MaxAge = maxAge
+		write.MaxAge = maxAge
+	}
+	return read, write, nil
+}
+
+// envInt parses the environment variable key as a positive integer, or
+// returns 0 if unset
+func envInt(key string) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", key, value)
+	}
+	return n, nil
+}
+
+// envDuration parses the environment variable key as a positive duration
+// such as "15m", or returns 0 if unset
+func envDuration(key string) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 15m, got %q", key, value)
+	}
+	return d, nil
+}
+
+// envOrDefault returns the value of the environment variable key, or def if unset
+func envOrDefault(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
 }
@@ -0,0 +1,420 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+// Badge styles
+const (
+	BadgeFlat       = "flat"
+	BadgeFlatSquare = "flat-square"
+	BadgePlastic    = "plastic"
+)
+
+// badgeColors are the named colors accepted in the color parameters
+var badgeColors = map[string]string{
+	"brightgreen":   "#4c1",
+	"green":         "#97ca00",
+	"yellowgreen":   "#a4a61d",
+	"yellow":        "#dfb317",
+	"orange":        "#fe7d37",
+	"red":           "#e05d44",
+	"blue":          "#007ec6",
+	"lightgrey":     "#9f9f9f",
+	"lightgray":     "#9f9f9f",
+	"grey":          "#555",
+	"gray":          "#555",
+	"success":       "#4c1",
+	"critical":      "#e05d44",
+	"informational": "#007ec6",
+	"inactive":      "#9f9f9f",
+}
+
+var hexColor = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// defaultProperties is the property shown for structured sensors unless the
+// property parameter picks another
+var defaultProperties = map[string]string{
+	"wind":            "speed",
+	"network_traffic": "bits_per_second",
+}
+
+type BadgeHandler struct {
+	store        *services.Store
+	cacheControl string
+}
+
+// NewBadgeHandler creates a handler rendering badges from the document in
+// store
+func NewBadgeHandler(store *services.Store) *BadgeHandler {
+	return &BadgeHandler{
+		store:        store,
+		cacheControl: DefaultCacheControl,
+	}
+}
+
+// SetCacheControl sets the Cache-Control header of the badges. An empty
+// value omits the header.
+func (h *BadgeHandler) SetCacheControl(value string) {
+	h.cacheControl = value
+}
+
+// badge is the content of a badge
+type badge struct {
+	Style      string
+	Label      string
+	Message    string
+	LabelColor string
+	Color      string
+}
+
+// StatusBadge renders the open/closed state. open_text and closed_text
+// replace the status words, message=true shows the state message instead
+// and since=true adds how long the state has lasted.
+func (h *BadgeHandler) StatusBadge(w http.ResponseWriter, r *http.Request) {
+	spaceAPI := h.store.Snapshot()
+	query := r.URL.Query()
+
+	b := badge{Label: spaceAPI.Space, Message: "unknown", Color: badgeColors["lightgrey"]}
+	if b.Label == "" {
+		b.Label = "space"
+	}
+	colorParam := ""
+	if state := spaceAPI.State; state != nil && state.Open != nil {
+		if *state.Open {
+			b.Message = textOr(query.Get("open_text"), "open")
+			b.Color = badgeColors["brightgreen"]
+			colorParam = "open_color"
+		} else {
+			b.Message = textOr(query.Get("closed_text"), "closed")
+			b.Color = badgeColors["red"]
+			colorParam = "closed_color"
+		}
+		if isTrue(query.Get("message")) && state.Message != "" {
+			b.Message = state.Message
+		}
+		if isTrue(query.Get("since")) && state.Lastchange > 0 {
+			b.Message += " for " + formatSince(time.Since(time.Unix(state.Lastchange, 0)))
+		}
+	}
+	if colorParam != "" {
+		color, ok := parseColor(query.Get(colorParam))
+		if !ok {
+			http.Error(w, "Invalid "+colorParam, http.StatusBadRequest)
+			return
+		}
+		if color != "" {
+			b.Color = color
+		}
+	}
+
+	h.serveBadge(w, r, b)
+}
+
+// SensorBadge renders the value of a sensor reading. location and name pick
+// the reading, property the value of structured sensors such as wind.
+func (h *BadgeHandler) SensorBadge(w http.ResponseWriter, r *http.Request) {
+	sensorType := mux.Vars(r)["sensor"]
+	if !isSensorType(sensorType) {
+		http.Error(w, "Unknown sensor type, expected one of: "+strings.Join(services.SensorTypes(), ", "), http.StatusNotFound)
+		return
+	}
+	query := r.URL.Query()
+
+	b := badge{Label: strings.ReplaceAll(sensorType, "_", " "), Message: "no data", Color: badgeColors["lightgrey"]}
+	reading := findReading(h.store.Snapshot().Sensors, sensorType, query.Get("location"), query.Get("name"))
+	property := textOr(query.Get("property"), defaultProperties[sensorType])
+	if message, ok := readingMessage(sensorType, reading, property); ok {
+		b.Message = message
+		b.Color = badgeColors["blue"]
+	}
+
+	h.serveBadge(w, r, b)
+}
+
+// serveBadge applies the style, label and color parameters to b and writes
+// the SVG
+func (h *BadgeHandler) serveBadge(w http.ResponseWriter, r *http.Request, b badge) {
+	query := r.URL.Query()
+
+	b.Style = textOr(query.Get("style"), BadgeFlat)
+	if b.Style != BadgeFlat && b.Style != BadgeFlatSquare && b.Style != BadgePlastic {
+		http.Error(w, "Unknown style, expected one of: flat, flat-square, plastic", http.StatusBadRequest)
+		return
+	}
+	if label, ok := query["label"]; ok {
+		b.Label = label[0]
+	}
+	b.LabelColor = badgeColors["grey"]
+	for param, target := range map[string]*string{"color": &b.Color, "label_color": &b.LabelColor} {
+		color, ok := parseColor(query.Get(param))
+		if !ok {
+			http.Error(w, "Invalid "+param, http.StatusBadRequest)
+			return
+		}
+		if color != "" {
+			*target = color
+		}
+	}
+
+	svg, err := renderBadge(b)
+	if err != nil {
+		log.Printf("Error rendering badge: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if h.cacheControl != "" {
+		w.Header().Set("Cache-Control", h.cacheControl)
+	}
+	if checkContentETag(w, r, svg) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(svg)))
+	if _, err := w.Write(svg); err != nil {
+		log.Printf("Error writing badge: %v", err)
+	}
+}
+
+// findReading returns the first reading of sensorType matching location and
+// name, which match anything if empty, as decoded JSON
+func findReading(sensors *models.Sensors, sensorType, location, name string) map[string]interface{} {
+	for _, reading := range sensorReadings(sensors, sensorType) {
+		if (location == "" || reading["location"] == location) && (name == "" || reading["name"] == name) {
+			return reading
+		}
+	}
+	return nil
+}
+
+// sensorReadings returns the readings of sensorType as decoded JSON
+func sensorReadings(sensors *models.Sensors, sensorType string) []map[string]interface{} {
+	if sensors == nil {
+		return nil
+	}
+	data, err := json.Marshal(sensors)
+	if err != nil {
+		return nil
+	}
+	var node interface{}
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil
+	}
+	// Radiation readings are nested, e.g. radiation.gamma
+	for _, key := range strings.Split(sensorType, ".") {
+		object, _ := node.(map[string]interface{})
+		node = object[key]
+	}
+
+	nodes, _ := node.([]interface{})
+	readings := make([]map[string]interface{}, 0, len(nodes))
+	for _, reading := range nodes {
+		if reading, ok := reading.(map[string]interface{}); ok {
+			readings = append(readings, reading)
+		}
+	}
+	return readings
+}
+
+// readingMessage formats the value of reading, or of its property for
+// structured sensors, with its unit
+func readingMessage(sensorType string, reading map[string]interface{}, property string) (string, bool) {
+	if reading == nil {
+		return "", false
+	}
+	if property != "" {
+		properties, _ := reading["properties"].(map[string]interface{})
+		reading, _ = properties[property].(map[string]interface{})
+		if reading == nil {
+			return "", false
+		}
+	}
+
+	switch value := reading["value"].(type) {
+	case float64:
+		message := strconv.FormatFloat(value, 'f', -1, 64)
+		if unit, _ := reading["unit"].(string); unit != "" {
+			if !strings.HasPrefix(unit, "°") && unit != "%" {
+				message += " "
+			}
+			message += unit
+		}
+		return message, true
+	case bool:
+		if sensorType == "door_locked" {
+			return map[bool]string{true: "locked", false: "unlocked"}[value], true
+		}
+		return map[bool]string{true: "yes", false: "no"}[value], true
+	}
+	return "", false
+}
+
+// parseColor accepts a named color or a hex color with or without "#". An
+// empty value is valid and gives an empty color.
+func parseColor(value string) (string, bool) {
+	if value == "" {
+		return "", true
+	}
+	if color, ok := badgeColors[strings.ToLower(value)]; ok {
+		return color, true
+	}
+	if hexColor.MatchString(value) {
+		return "#" + strings.TrimPrefix(value, "#"), true
+	}
+	return "", false
+}
+
+// formatSince formats a duration in its two largest units, such as "2h 15m"
+func formatSince(d time.Duration) string {
+	minutes := int(d / time.Minute)
+	switch {
+	case minutes < 60:
+		return fmt.Sprintf("%dm", minutes)
+	case minutes < 24*60:
+		return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+	default:
+		return fmt.Sprintf("%dd %dh", minutes/(24*60), minutes%(24*60)/60)
+	}
+}
+
+// textOr returns value, or def if value is empty
+func textOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// isTrue reports whether a query parameter switches an option on
+func isTrue(value string) bool {
+	on, _ := strconv.ParseBool(value)
+	return on
+}
+
+// Approximate widths of Verdana 11px, which badges are set in
+var (
+	narrowChars = "ijlI.,:;'|!"
+	thinChars   = "frt()[] -"
+	wideChars   = "mwMW"
+)
+
+// textWidth estimates the width of text in pixels
+func textWidth(text string) float64 {
+	var width float64
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(narrowChars, r):
+			width += 3.5
+		case strings.ContainsRune(thinChars, r):
+			width += 4.5
+		case strings.ContainsRune(wideChars, r):
+			width += 10
+		case r >= 'A' && r <= 'Z':
+			width += 7.5
+		case r >= '0' && r <= '9':
+			width += 7
+		default:
+			width += 6.6
+		}
+	}
+	return width
+}
+
+// badgeLayout holds the computed geometry of a badge
+type badgeLayout struct {
+	badge
+	Height int
+	Radius int
+	// Gradient holds the stops of the shading, if any
+	Gradient     template.HTML
+	LabelWidth   int
+	MessageWidth int
+	Width        int
+	LabelX       float64
+	MessageX     float64
+	TextY        int
+	Title        string
+}
+
+// badgeTemplate draws a badge. html/template escapes the text for the SVG
+// elements and attributes it ends up in.
+var badgeTemplate = template.Must(template.New("badge").Parse(
+	`<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" role="img" aria-label="{{.Title}}">` +
+		`<title>{{.Title}}</title>` +
+		`{{if .Gradient}}<linearGradient id="s" x2="0" y2="100%">{{.Gradient}}</linearGradient>{{end}}` +
+		`<clipPath id="r"><rect width="{{.Width}}" height="{{.Height}}" rx="{{.Radius}}" fill="#fff"/></clipPath>` +
+		`<g clip-path="url(#r)"><rect width="{{.LabelWidth}}" height="{{.Height}}" fill="{{.LabelColor}}"/><rect x="{{.LabelWidth}}" width="{{.MessageWidth}}" height="{{.Height}}" fill="{{.Color}}"/>` +
+		`{{if .Gradient}}<rect width="{{.Width}}" height="{{.Height}}" fill="url(#s)"/>{{end}}</g>` +
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">` +
+		`{{if .Label}}<text x="{{.LabelX}}" y="{{.TextY}}" fill="#010101" fill-opacity=".3">{{.Label}}</text><text x="{{.LabelX}}" y="{{.TextYMinus1}}">{{.Label}}</text>{{end}}` +
+		`<text x="{{.MessageX}}" y="{{.TextY}}" fill="#010101" fill-opacity=".3">{{.Message}}</text><text x="{{.MessageX}}" y="{{.TextYMinus1}}">{{.Message}}</text>` +
+		`</g></svg>`))
+
+// TextYMinus1 is the baseline of the text above its shadow
+func (l badgeLayout) TextYMinus1() int {
+	return l.TextY - 1
+}
+
+// renderBadge draws b as SVG
+func renderBadge(b badge) ([]byte, error) {
+	layout := badgeLayout{
+		Height:   20,
+		Radius:   3,
+		Gradient: `<stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/>`,
+		TextY:    15,
+		Title:    strings.TrimPrefix(b.Label+": "+b.Message, ": "),
+	}
+	switch b.Style {
+	case BadgeFlatSquare:
+		layout.Radius, layout.Gradient = 0, ""
+	case BadgePlastic:
+		layout.Height, layout.Radius, layout.TextY = 18, 4, 14
+		layout.Gradient = `<stop offset="0" stop-color="#fff" stop-opacity=".7"/><stop offset=".1" stop-color="#aaa" stop-opacity=".1"/><stop offset=".9" stop-opacity=".3"/><stop offset="1" stop-opacity=".5"/>`
+	}
+
+	if b.Label != "" {
+		layout.LabelWidth = int(textWidth(b.Label) + 10.5)
+	}
+	layout.MessageWidth = int(textWidth(b.Message) + 10.5)
+	layout.Width = layout.LabelWidth + layout.MessageWidth
+	layout.LabelX = float64(layout.LabelWidth) / 2
+	layout.MessageX = float64(layout.LabelWidth) + float64(layout.MessageWidth)/2
+
+	layout.badge = b
+
+	var buf bytes.Buffer
+	if err := badgeTemplate.Execute(&buf, layout); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
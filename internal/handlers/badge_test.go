@@ -0,0 +1,242 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type BadgeHandlerTestSuite struct {
+	suite.Suite
+	store   *services.Store
+	handler *BadgeHandler
+}
+
+func (suite *BadgeHandlerTestSuite) SetupTest() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.State.Lastchange = time.Now().Add(-135 * time.Minute).Unix()
+	spaceAPI.Sensors.Temperature = []models.TemperatureSensor{
+		{Value: 21.5, Unit: "°C", SensorMeta: models.SensorMeta{Location: "Lab"}},
+		{Value: 4, Unit: "°C", SensorMeta: models.SensorMeta{Location: "Outside"}},
+	}
+	spaceAPI.Sensors.DoorLocked = []models.DoorLockedSensor{{Value: true, SensorMeta: models.SensorMeta{Location: "Front"}}}
+	spaceAPI.Sensors.Wind = []models.WindSensor{{
+		Properties: models.WindProperties{
+			Speed:     models.Measurement{Value: 4.2, Unit: "km/h"},
+			Gust:      models.Measurement{Value: 9.1, Unit: "km/h"},
+			Direction: models.Measurement{Value: 270, Unit: "°"},
+			Elevation: models.Measurement{Value: 42, Unit: "m"},
+		},
+		SensorMeta: models.SensorMeta{Location: "Roof"},
+	}}
+	suite.store = services.NewStore(spaceAPI, nil)
+	suite.handler = NewBadgeHandler(suite.store)
+}
+
+func TestBadgeHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(BadgeHandlerTestSuite))
+}
+
+// svgBadge is the part of a rendered badge the tests look at
+type svgBadge struct {
+	Width     int      `xml:"width,attr"`
+	AriaLabel string   `xml:"aria-label,attr"`
+	Title     string   `xml:"title"`
+	Texts     []string `xml:"g>text"`
+	Rects     []struct {
+		Fill string `xml:"fill,attr"`
+	} `xml:"g>rect"`
+}
+
+func (suite *BadgeHandlerTestSuite) status(query string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/badge.svg"+query, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	suite.handler.StatusBadge(w, req)
+	return w
+}
+
+func (suite *BadgeHandlerTestSuite) sensor(sensorType, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/badge/"+sensorType+".svg"+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"sensor": sensorType})
+	w := httptest.NewRecorder()
+	suite.handler.SensorBadge(w, req)
+	return w
+}
+
+// decode parses a successful badge response
+func (suite *BadgeHandlerTestSuite) decode(w *httptest.ResponseRecorder) svgBadge {
+	suite.Require().Equal(http.StatusOK, w.Code, w.Body.String())
+	suite.Assert().Equal("image/svg+xml; charset=utf-8", w.Header().Get("Content-Type"))
+	var badge svgBadge
+	suite.Require().NoError(xml.Unmarshal(w.Body.Bytes(), &badge))
+	return badge
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus() {
+	badge := suite.decode(suite.status("", nil))
+
+	suite.Assert().Equal("Test Space: open", badge.Title)
+	suite.Require().Len(badge.Rects, 3)
+	suite.Assert().Equal("#555", badge.Rects[0].Fill)
+	suite.Assert().Equal("#4c1", badge.Rects[1].Fill)
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_Closed() {
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Open = models.BoolPtr(false)
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	badge := suite.decode(suite.status("?closed_text=zu&closed_color=orange", nil))
+	suite.Assert().Equal("Test Space: zu", badge.Title)
+	suite.Assert().Equal("#fe7d37", badge.Rects[1].Fill)
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_Unknown() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.State = nil
+	suite.handler = NewBadgeHandler(services.NewStore(spaceAPI, nil))
+
+	badge := suite.decode(suite.status("", nil))
+	suite.Assert().Equal("Test Space: unknown", badge.Title)
+	suite.Assert().Equal("#9f9f9f", badge.Rects[1].Fill)
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_MessageAndSince() {
+	badge := suite.decode(suite.status("?message=true&since=true&label=q30", nil))
+
+	suite.Assert().Equal("q30: Space is open for testing for 2h 15m", badge.Title)
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_Options() {
+	badge := suite.decode(suite.status("?label=&color=ff69b4&label_color=blue&style=flat-square", nil))
+
+	suite.Assert().Equal("open", badge.Title)
+	suite.Assert().Equal("#007ec6", badge.Rects[0].Fill)
+	suite.Assert().Equal("#ff69b4", badge.Rects[1].Fill)
+	// Flat-square badges have no shading
+	suite.Assert().Len(badge.Rects, 2)
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_EscapesText() {
+	w := suite.status("?open_text=%3Cscript%3E&label=a%26b", nil)
+
+	suite.Assert().NotContains(w.Body.String(), "<script>")
+	suite.Assert().Equal("a&b: <script>", suite.decode(w).Title)
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_EscapesSpaceName() {
+	name := `<Hack & "Make" 'Space'>`
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.Space = name
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	// The name ends up in an attribute, the title and the text elements
+	badge := suite.decode(suite.status("", nil))
+	suite.Assert().Equal(name+": open", badge.Title)
+	suite.Assert().Equal(name+": open", badge.AriaLabel)
+	suite.Assert().Equal([]string{name, name, "open", "open"}, badge.Texts)
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_InvalidOptions() {
+	for _, query := range []string{"?style=round", "?color=url(#x)", "?label_color=%22", "?open_color=nope"} {
+		suite.Assert().Equal(http.StatusBadRequest, suite.status(query, nil).Code, query)
+	}
+}
+
+func (suite *BadgeHandlerTestSuite) TestStatus_Caching() {
+	w := suite.status("", nil)
+	etag := w.Header().Get("ETag")
+	suite.Assert().NotEmpty(etag)
+	suite.Assert().Equal(DefaultCacheControl, w.Header().Get("Cache-Control"))
+
+	w = suite.status("", map[string]string{"If-None-Match": etag})
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+	suite.Assert().Empty(w.Body.String())
+
+	// Other options give another badge
+	w = suite.status("?style=plastic", map[string]string{"If-None-Match": etag})
+	suite.Assert().Equal(http.StatusOK, w.Code)
+
+	suite.handler.SetCacheControl("max-age=60")
+	suite.Assert().Equal("max-age=60", suite.status("", nil).Header().Get("Cache-Control"))
+}
+
+func (suite *BadgeHandlerTestSuite) TestSensor() {
+	badge := suite.decode(suite.sensor("temperature", ""))
+	suite.Assert().Equal("temperature: 21.5°C", badge.Title)
+	suite.Assert().Equal("#007ec6", badge.Rects[1].Fill)
+
+	badge = suite.decode(suite.sensor("temperature", "?location=Outside&label=outside"))
+	suite.Assert().Equal("outside: 4°C", badge.Title)
+
+	badge = suite.decode(suite.sensor("people_now_present", ""))
+	suite.Assert().Equal("people now present: 3", badge.Title)
+
+	badge = suite.decode(suite.sensor("door_locked", ""))
+	suite.Assert().Equal("door locked: locked", badge.Title)
+}
+
+func (suite *BadgeHandlerTestSuite) TestSensor_Properties() {
+	badge := suite.decode(suite.sensor("wind", ""))
+	suite.Assert().Equal("wind: 4.2 km/h", badge.Title)
+
+	badge = suite.decode(suite.sensor("wind", "?property=direction"))
+	suite.Assert().Equal("wind: 270°", badge.Title)
+}
+
+func (suite *BadgeHandlerTestSuite) TestSensor_NoData() {
+	badge := suite.decode(suite.sensor("temperature", "?location=Attic"))
+	suite.Assert().Equal("temperature: no data", badge.Title)
+	suite.Assert().Equal("#9f9f9f", badge.Rects[1].Fill)
+
+	badge = suite.decode(suite.sensor("radiation.gamma", ""))
+	suite.Assert().Equal("radiation.gamma: no data", badge.Title)
+}
+
+func (suite *BadgeHandlerTestSuite) TestSensor_UnknownType() {
+	suite.Assert().Equal(http.StatusNotFound, suite.sensor("flux_capacitor", "").Code)
+}
+
+func (suite *BadgeHandlerTestSuite) TestFormatSince() {
+	suite.Assert().Equal("0m", formatSince(20*time.Second))
+	suite.Assert().Equal("59m", formatSince(59*time.Minute))
+	suite.Assert().Equal("1h 0m", formatSince(time.Hour))
+	suite.Assert().Equal("2d 3h", formatSince(51*time.Hour+10*time.Minute))
+}
+
+func (suite *BadgeHandlerTestSuite) TestWidthFollowsText() {
+	short := suite.decode(suite.status("?open_text=on", nil))
+	long := suite.decode(suite.status("?open_text=open+for+business", nil))
+
+	suite.Assert().Greater(long.Width, short.Width)
+}
@@ -0,0 +1,257 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+// DefaultCacheControl makes caches revalidate the document on every use,
+// which is cheap thanks to the ETag
+const DefaultCacheControl = "no-cache"
+
+// Content codings offered for the document, in order of preference
+const (
+	encodingBrotli   = "br"
+	encodingGzip     = "gzip"
+	encodingIdentity = "identity"
+)
+
+// representation is the encoded document of one revision. The encodings
+// are compressed on first use.
+type representation struct {
+	revision uint64
+	modified time.Time
+	// tag is the hex SHA-256 of the JSON, so the ETag survives restarts
+	// as long as the document is unchanged
+	tag string
+
+	mutex   sync.Mutex
+	encoded map[string][]byte
+}
+
+// documentCache keeps the representation of the latest revision
+type documentCache struct {
+	mutex   sync.Mutex
+	current *representation
+}
+
+// get returns the representation of the current document of store
+func (c *documentCache) get(store *services.Store) (*representation, error) {
+	spaceAPI, revision := store.Current()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.current != nil && c.current.revision == revision.Number {
+		return c.current, nil
+	}
+
+	data, tag, err := encodeDocument(spaceAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	c.current = &representation{
+		revision: revision.Number,
+		modified: revision.Modified,
+		tag:      tag,
+		encoded:  map[string][]byte{encodingIdentity: data},
+	}
+	return c.current, nil
+}
+
+// encodeDocument returns the JSON served for spaceAPI and its entity tag
+// without quotes
+func encodeDocument(spaceAPI *models.SpaceAPI) ([]byte, string, error) {
+	// Same layout as json.Encoder
+	data, err := json.Marshal(spaceAPI)
+	if err != nil {
+		return nil, "", err
+	}
+	data = append(data, '\n')
+	sum := sha256.Sum256(data)
+	return data, hex.EncodeToString(sum[:16]), nil
+}
+
+// body returns the document in the given content coding
+func (rep *representation) body(encoding string) []byte {
+	rep.mutex.Lock()
+	defer rep.mutex.Unlock()
+
+	if body, ok := rep.encoded[encoding]; ok {
+		return body
+	}
+
+	var buf bytes.Buffer
+	switch encoding {
+	case encodingBrotli:
+		writer := brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+		_, _ = writer.Write(rep.encoded[encodingIdentity])
+		_ = writer.Close()
+	case encodingGzip:
+		writer := gzip.NewWriter(&buf)
+		_, _ = writer.Write(rep.encoded[encodingIdentity])
+		_ = writer.Close()
+	default:
+		return rep.encoded[encodingIdentity]
+	}
+	rep.encoded[encoding] = buf.Bytes()
+	return rep.encoded[encoding]
+}
+
+// etag returns the entity tag of the document in the given content coding.
+// Every coding has its own tag, as they are different representations.
+func (rep *representation) etag(encoding string) string {
+	if encoding == encodingIdentity {
+		return `"` + rep.tag + `"`
+	}
+	return `"` + rep.tag + "-" + encoding + `"`
+}
+
+// matchesETag reports whether an If-None-Match or If-Match header lists the
+// document in any coding. Weak tags match if weak is set.
+func (rep *representation) matchesETag(header string, weak bool) bool {
+	return etagListMatches(header, rep.tag, weak)
+}
+
+// etagListMatches reports whether a list of entity tags contains tag,
+// ignoring the content coding suffix
+func etagListMatches(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		candidate = strings.Trim(candidate, `"`)
+		candidate = strings.TrimSuffix(strings.TrimSuffix(candidate, "-"+encodingBrotli), "-"+encodingGzip)
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContentETag sets an entity tag derived from body and answers with 304
+// if the client already has it. It reports whether the response is complete.
+func checkContentETag(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	sum := sha256.Sum256(body)
+	tag := hex.EncodeToString(sum[:16])
+	w.Header().Set("ETag", `"`+tag+`"`)
+	if header := r.Header.Get("If-None-Match"); header != "" && etagListMatches(header, tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// notModified reports whether the conditional headers of a GET show that
+// the client already has the document. If-None-Match takes precedence over
+// If-Modified-Since.
+func (rep *representation) notModified(r *http.Request) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return rep.matchesETag(header, true)
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		return err == nil && !rep.modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// negotiateEncoding picks the content coding for an Accept-Encoding header
+func negotiateEncoding(header string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(value, 64); err == nil {
+				quality = q
+			}
+		}
+		qualities[coding] = quality
+	}
+
+	best, bestQuality := encodingIdentity, 0.0
+	for _, coding := range []string{encodingBrotli, encodingGzip} {
+		quality, ok := qualities[coding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+	return best
+}
+
+// errPreconditionFailed rejects an update whose If-Match header does not
+// list the current document
+var errPreconditionFailed = errors.New("document was modified since it was read")
+
+// checkIfMatch verifies the If-Match header of an update against the
+// document about to be modified. It is called inside Store.Update, so no
+// other update can slip in between the check and the write.
+func checkIfMatch(r *http.Request, spaceAPI *models.SpaceAPI) error {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	_, tag, err := encodeDocument(spaceAPI)
+	if err != nil {
+		return err
+	}
+	if !etagListMatches(header, tag, false) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// setETag announces the entity tag of an updated document, so the client
+// can send it as If-Match with its next update
+func setETag(w http.ResponseWriter, spaceAPI *models.SpaceAPI) {
+	_, tag, err := encodeDocument(spaceAPI)
+	if err != nil {
+		log.Printf("Error encoding SpaceAPI document: %v", err)
+		return
+	}
+	w.Header().Set("ETag", `"`+tag+`"`)
+}
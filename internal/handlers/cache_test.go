@@ -0,0 +1,259 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/mux"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type CacheTestSuite struct {
+	suite.Suite
+	store   *services.Store
+	handler *SpaceAPIHandler
+}
+
+func (suite *CacheTestSuite) SetupTest() {
+	suite.store = services.NewStore(testutil.NewMockSpaceAPI(), nil)
+	suite.handler = NewSpaceAPIHandler(suite.store, nil)
+}
+
+func TestCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(CacheTestSuite))
+}
+
+func (suite *CacheTestSuite) get(headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/space", nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	suite.handler.GetSpaceAPI(w, req)
+	return w
+}
+
+func (suite *CacheTestSuite) update() {
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Message = "Changed"
+		return nil
+	})
+	suite.Require().NoError(err)
+}
+
+func (suite *CacheTestSuite) TestHeaders() {
+	w := suite.get(nil)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Regexp(`^"[0-9a-f]{32}"$`, w.Header().Get("ETag"))
+	suite.Assert().NotEmpty(w.Header().Get("Last-Modified"))
+	suite.Assert().Equal(DefaultCacheControl, w.Header().Get("Cache-Control"))
+	suite.Assert().Equal("Accept-Encoding", w.Header().Get("Vary"))
+	suite.Assert().Empty(w.Header().Get("Content-Encoding"))
+}
+
+func (suite *CacheTestSuite) TestETagFollowsContent() {
+	etag := suite.get(nil).Header().Get("ETag")
+
+	// Another store with the same document, as after a restart
+	other := NewSpaceAPIHandler(services.NewStore(testutil.NewMockSpaceAPI(), nil), nil)
+	w := httptest.NewRecorder()
+	other.GetSpaceAPI(w, httptest.NewRequest("GET", "/api/space", nil))
+	suite.Assert().Equal(etag, w.Header().Get("ETag"))
+
+	suite.update()
+	suite.Assert().NotEqual(etag, suite.get(nil).Header().Get("ETag"))
+}
+
+func (suite *CacheTestSuite) TestIfNoneMatch() {
+	etag := suite.get(nil).Header().Get("ETag")
+
+	w := suite.get(map[string]string{"If-None-Match": etag})
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+	suite.Assert().Empty(w.Body.String())
+	suite.Assert().Equal(etag, w.Header().Get("ETag"))
+
+	w = suite.get(map[string]string{"If-None-Match": `"other", W/` + etag})
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+
+	w = suite.get(map[string]string{"If-None-Match": "*"})
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+
+	suite.update()
+	w = suite.get(map[string]string{"If-None-Match": etag})
+	suite.Assert().Equal(http.StatusOK, w.Code)
+}
+
+func (suite *CacheTestSuite) TestIfNoneMatch_OtherEncoding() {
+	etag := suite.get(map[string]string{"Accept-Encoding": "gzip"}).Header().Get("ETag")
+	suite.Assert().Regexp(`-gzip"$`, etag)
+
+	w := suite.get(map[string]string{"If-None-Match": etag})
+
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+}
+
+func (suite *CacheTestSuite) TestIfModifiedSince() {
+	lastModified := suite.get(nil).Header().Get("Last-Modified")
+
+	w := suite.get(map[string]string{"If-Modified-Since": lastModified})
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+
+	earlier := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	w = suite.get(map[string]string{"If-Modified-Since": earlier})
+	suite.Assert().Equal(http.StatusOK, w.Code)
+
+	// If-None-Match takes precedence
+	w = suite.get(map[string]string{"If-Modified-Since": lastModified, "If-None-Match": `"other"`})
+	suite.Assert().Equal(http.StatusOK, w.Code)
+}
+
+func (suite *CacheTestSuite) TestCacheControl() {
+	suite.handler.SetCacheControl("public, max-age=60")
+	suite.Assert().Equal("public, max-age=60", suite.get(nil).Header().Get("Cache-Control"))
+
+	suite.handler.SetCacheControl("")
+	suite.Assert().Empty(suite.get(nil).Header().Values("Cache-Control"))
+}
+
+func (suite *CacheTestSuite) TestGzip() {
+	w := suite.get(map[string]string{"Accept-Encoding": "gzip, deflate"})
+
+	suite.Assert().Equal("gzip", w.Header().Get("Content-Encoding"))
+	reader, err := gzip.NewReader(w.Body)
+	suite.Require().NoError(err)
+	suite.assertDocument(reader)
+}
+
+func (suite *CacheTestSuite) TestBrotli() {
+	w := suite.get(map[string]string{"Accept-Encoding": "gzip, deflate, br"})
+
+	suite.Assert().Equal("br", w.Header().Get("Content-Encoding"))
+	suite.assertDocument(brotli.NewReader(w.Body))
+}
+
+func (suite *CacheTestSuite) assertDocument(reader io.Reader) {
+	var response models.SpaceAPI
+	suite.Require().NoError(json.NewDecoder(reader).Decode(&response))
+	suite.Assert().Equal(suite.store.Snapshot().Space, response.Space)
+}
+
+func (suite *CacheTestSuite) TestNegotiateEncoding() {
+	cases := map[string]string{
+		"":                     encodingIdentity,
+		"identity":             encodingIdentity,
+		"gzip":                 encodingGzip,
+		"br, gzip":             encodingBrotli,
+		"gzip;q=1.0, br;q=0.5": encodingGzip,
+		"br;q=0, gzip;q=0.1":   encodingGzip,
+		"*":                    encodingBrotli,
+		"*;q=0.5, br;q=0":      encodingGzip,
+		"GZIP":                 encodingGzip,
+		"deflate, gzip;q=0":    encodingIdentity,
+	}
+	for header, expected := range cases {
+		suite.Assert().Equal(expected, negotiateEncoding(header), header)
+	}
+}
+
+// post sends an update with the given If-Match header
+func (suite *CacheTestSuite) post(handler http.HandlerFunc, path, body, ifMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	// Sensor updates take the type from the last path segment
+	req = mux.SetURLVars(req, map[string]string{"type": path[strings.LastIndex(path, "/")+1:]})
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func (suite *CacheTestSuite) TestIfMatch_Current() {
+	etag := suite.get(nil).Header().Get("ETag")
+
+	w := suite.post(suite.handler.UpdateState, "/api/space/state", `{"message": "Mine"}`, etag)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	// The response carries the tag of the new document
+	newETag := w.Header().Get("ETag")
+	suite.Assert().NotEqual(etag, newETag)
+	suite.Assert().Equal(newETag, suite.get(nil).Header().Get("ETag"))
+}
+
+func (suite *CacheTestSuite) TestIfMatch_Stale() {
+	etag := suite.get(nil).Header().Get("ETag")
+	suite.update()
+
+	w := suite.post(suite.handler.UpdateState, "/api/space/state", `{"message": "Mine"}`, etag)
+
+	suite.Assert().Equal(http.StatusPreconditionFailed, w.Code)
+	suite.Assert().Equal("Changed", suite.store.Snapshot().State.Message)
+}
+
+func (suite *CacheTestSuite) TestIfMatch_CompressedTag() {
+	etag := suite.get(map[string]string{"Accept-Encoding": "br"}).Header().Get("ETag")
+
+	w := suite.post(suite.handler.UpdateState, "/api/space/state", `{"message": "Mine"}`, etag)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+}
+
+func (suite *CacheTestSuite) TestIfMatch_WeakTagNeverMatches() {
+	etag := suite.get(nil).Header().Get("ETag")
+
+	w := suite.post(suite.handler.UpdateState, "/api/space/state", `{"message": "Mine"}`, "W/"+etag)
+
+	suite.Assert().Equal(http.StatusPreconditionFailed, w.Code)
+}
+
+func (suite *CacheTestSuite) TestIfMatch_AllEndpoints() {
+	stale := suite.get(nil).Header().Get("ETag")
+	suite.update()
+
+	updates := []struct {
+		handler http.HandlerFunc
+		path    string
+		body    string
+	}{
+		{suite.handler.UpdateState, "/api/space/state", `{"open": false}`},
+		{suite.handler.UpdatePeopleCount, "/api/space/people", `{"value": 3}`},
+		{suite.handler.AddEvent, "/api/space/event", `{"name": "Alice", "type": "check-in"}`},
+		{suite.handler.UpdateSensor, "/api/space/sensors/temperature", `{"value": 21, "unit": "°C", "location": "Hall"}`},
+	}
+	for _, update := range updates {
+		w := suite.post(update.handler, update.path, update.body, stale)
+		suite.Assert().Equal(http.StatusPreconditionFailed, w.Code, update.path)
+
+		w = suite.post(update.handler, update.path, update.body, "")
+		suite.Assert().Equal(http.StatusOK, w.Code, update.path)
+		suite.Assert().NotEmpty(w.Header().Get("ETag"), update.path)
+	}
+}
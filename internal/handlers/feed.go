@@ -0,0 +1,293 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+// FeedEntries is the number of entries in the feeds
+const FeedEntries = 50
+
+type FeedHandler struct {
+	store  *services.Store
+	states *services.StateHistory
+	events *services.EventHistory
+}
+
+// NewFeedHandler creates a handler publishing the state transitions and
+// events recorded in states and events as feeds
+func NewFeedHandler(store *services.Store, states *services.StateHistory, events *services.EventHistory) *FeedHandler {
+	return &FeedHandler{
+		store:  store,
+		states: states,
+		events: events,
+	}
+}
+
+// feedEntry is a state transition or an event in a feed
+type feedEntry struct {
+	ID       string
+	Title    string
+	Content  string
+	Category string
+	Author   string
+	Time     time.Time
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Updated  string      `xml:"updated"`
+	Author   atomPerson  `xml:"author"`
+	Links    []atomLink  `xml:"link"`
+	Logo     string      `xml:"logo,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string        `xml:"id"`
+	Title     string        `xml:"title"`
+	Published string        `xml:"published"`
+	Updated   string        `xml:"updated"`
+	Author    *atomPerson   `xml:"author,omitempty"`
+	Category  *atomCategory `xml:"category,omitempty"`
+	Content   *atomContent  `xml:"content,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Description string  `xml:"description,omitempty"`
+	Category    string  `xml:"category,omitempty"`
+	PubDate     string  `xml:"pubDate"`
+	GUID        rssGUID `xml:"guid"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	ID          string `xml:",chardata"`
+}
+
+// Atom serves the latest state transitions and events as an Atom feed
+func (h *FeedHandler) Atom(w http.ResponseWriter, r *http.Request) {
+	spaceAPI := h.store.Snapshot()
+	entries := h.entries(spaceAPI)
+
+	feed := atomFeed{
+		ID:       feedID(spaceAPI, "feed"),
+		Title:    spaceName(spaceAPI),
+		Subtitle: "Opening and closing of " + spaceName(spaceAPI) + " and its events",
+		Updated:  feedUpdated(entries).UTC().Format(time.RFC3339),
+		Author:   atomPerson{Name: spaceName(spaceAPI)},
+		Links:    []atomLink{{Rel: "self", Type: "application/atom+xml", Href: r.URL.Path}},
+		Logo:     spaceAPI.Logo,
+	}
+	if spaceAPI.URL != "" {
+		feed.Links = append(feed.Links, atomLink{Rel: "alternate", Type: "text/html", Href: spaceAPI.URL})
+	}
+	for _, entry := range entries {
+		published := entry.Time.UTC().Format(time.RFC3339)
+		atom := atomEntry{
+			ID:        entry.ID,
+			Title:     entry.Title,
+			Published: published,
+			Updated:   published,
+			Category:  &atomCategory{Term: entry.Category},
+		}
+		if entry.Author != "" {
+			atom.Author = &atomPerson{Name: entry.Author}
+		}
+		if entry.Content != "" {
+			atom.Content = &atomContent{Type: "text", Text: entry.Content}
+		}
+		feed.Entries = append(feed.Entries, atom)
+	}
+
+	writeFeed(w, r, "application/atom+xml; charset=utf-8", feed)
+}
+
+// RSS serves the latest state transitions and events as an RSS 2.0 feed
+func (h *FeedHandler) RSS(w http.ResponseWriter, r *http.Request) {
+	spaceAPI := h.store.Snapshot()
+	entries := h.entries(spaceAPI)
+
+	channel := rssChannel{
+		Title:       spaceName(spaceAPI),
+		Link:        spaceAPI.URL,
+		Description: "Opening and closing of " + spaceName(spaceAPI) + " and its events",
+	}
+	if len(entries) > 0 {
+		channel.LastBuildDate = feedUpdated(entries).UTC().Format(time.RFC1123Z)
+	}
+	for _, entry := range entries {
+		channel.Items = append(channel.Items, rssItem{
+			Title:       entry.Title,
+			Description: entry.Content,
+			Category:    entry.Category,
+			PubDate:     entry.Time.UTC().Format(time.RFC1123Z),
+			GUID:        rssGUID{ID: entry.ID},
+		})
+	}
+
+	writeFeed(w, r, "application/rss+xml; charset=utf-8", rssFeed{Version: "2.0", Channel: channel})
+}
+
+// entries returns the latest transitions and events, newest first. Events
+// still in the document are included even if they were never recorded.
+func (h *FeedHandler) entries(spaceAPI *models.SpaceAPI) []feedEntry {
+	var entries []feedEntry
+	for _, transition := range h.states.Latest(FeedEntries) {
+		entry := feedEntry{
+			ID:       feedID(spaceAPI, "state", strconv.FormatInt(transition.Timestamp, 10), strconv.FormatBool(transition.Open)),
+			Title:    spaceName(spaceAPI) + " is closed",
+			Content:  transition.Message,
+			Category: "closed",
+			Author:   transition.TriggerPerson,
+			Time:     time.Unix(transition.Timestamp, 0),
+		}
+		if transition.Open {
+			entry.Title, entry.Category = spaceName(spaceAPI)+" is open", "open"
+		}
+		entries = append(entries, entry)
+	}
+
+	seen := make(map[string]bool)
+	addEvent := func(event models.Event) {
+		id := feedID(spaceAPI, "event", strconv.FormatInt(event.Timestamp, 10), event.Name, event.Type)
+		if seen[id] {
+			return
+		}
+		seen[id] = true
+		title := event.Name
+		if event.Type != "" {
+			title += " (" + event.Type + ")"
+		}
+		entries = append(entries, feedEntry{
+			ID:       id,
+			Title:    title,
+			Content:  event.Extra,
+			Category: event.Type,
+			Time:     time.Unix(event.Timestamp, 0),
+		})
+	}
+	for _, event := range h.events.Latest(FeedEntries) {
+		addEvent(event.Event)
+	}
+	for _, event := range spaceAPI.Events {
+		addEvent(event)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.After(entries[j].Time)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries[:min(len(entries), FeedEntries)]
+}
+
+// feedID returns a stable URN for the feed or an entry of it, derived from
+// the URL of the space, or its name if it has none, and parts identifying
+// the entry
+func feedID(spaceAPI *models.SpaceAPI, parts ...string) string {
+	// Formatted like a name-based (version 5) UUID
+	sum := sha1.Sum([]byte(strings.Join(append([]string{textOr(spaceAPI.URL, spaceAPI.Space)}, parts...), "\x00")))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// feedUpdated returns the time of the newest entry
+func feedUpdated(entries []feedEntry) time.Time {
+	if len(entries) == 0 {
+		return time.Unix(0, 0)
+	}
+	return entries[0].Time
+}
+
+// spaceName returns the name of the space for titles
+func spaceName(spaceAPI *models.SpaceAPI) string {
+	return textOr(spaceAPI.Space, "The space")
+}
+
+// writeFeed encodes feed as XML with an entity tag, answering revalidations
+// with 304
+func writeFeed(w http.ResponseWriter, r *http.Request, contentType string, feed interface{}) {
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding feed: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	body := append([]byte(xml.Header), append(data, '\n')...)
+
+	w.Header().Set("Cache-Control", DefaultCacheControl)
+	if checkContentETag(w, r, body) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Error writing feed: %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type FeedHandlerTestSuite struct {
+	suite.Suite
+	spaceAPI *models.SpaceAPI
+	states   *services.StateHistory
+	events   *services.EventHistory
+	handler  *FeedHandler
+}
+
+func (suite *FeedHandlerTestSuite) SetupTest() {
+	var err error
+	suite.states, err = services.NewStateHistory("")
+	suite.Require().NoError(err)
+	suite.events, err = services.NewEventHistory("")
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(suite.states.Record(services.StateTransition{Timestamp: 1000, Open: true, Message: "Hack night", TriggerPerson: "Ada"}))
+	suite.Require().NoError(suite.states.Record(services.StateTransition{Timestamp: 3000, Open: false}))
+	suite.Require().NoError(suite.events.Record(services.RecordedEvent{Event: models.Event{Name: "Bob", Type: "check-in", Timestamp: 2000, Extra: "Brought pizza"}}))
+
+	suite.spaceAPI = testutil.NewMockSpaceAPI()
+	suite.spaceAPI.Events = []models.Event{
+		{Name: "Bob", Type: "check-in", Timestamp: 2000, Extra: "Brought pizza"},
+		{Name: "Eve", Type: "check-in", Timestamp: 500},
+	}
+	suite.handler = NewFeedHandler(services.NewStore(suite.spaceAPI, nil), suite.states, suite.events)
+}
+
+func TestFeedHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(FeedHandlerTestSuite))
+}
+
+func (suite *FeedHandlerTestSuite) get(handler http.HandlerFunc, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func (suite *FeedHandlerTestSuite) TestAtom() {
+	w := suite.get(suite.handler.Atom, "/api/space/feed.atom")
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("application/atom+xml; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var feed atomFeed
+	suite.Require().NoError(xml.Unmarshal(w.Body.Bytes(), &feed))
+	suite.Assert().Equal("Test Space", feed.Title)
+	suite.Assert().Equal(time.Unix(3000, 0).UTC().Format(time.RFC3339), feed.Updated)
+	suite.Assert().Contains(feed.Links, atomLink{Rel: "self", Type: "application/atom+xml", Href: "/api/space/feed.atom"})
+	suite.Assert().Contains(feed.Links, atomLink{Rel: "alternate", Type: "text/html", Href: "https://example.com"})
+
+	// Newest first, and the event both recorded and in the document once
+	suite.Require().Len(feed.Entries, 4)
+	titles := []string{}
+	for _, entry := range feed.Entries {
+		titles = append(titles, entry.Title)
+		suite.Assert().Regexp(`^urn:uuid:[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, entry.ID)
+	}
+	suite.Assert().Equal([]string{"Test Space is closed", "Bob (check-in)", "Test Space is open", "Eve (check-in)"}, titles)
+
+	opened := feed.Entries[2]
+	suite.Assert().Equal("Ada", opened.Author.Name)
+	suite.Assert().Equal("Hack night", opened.Content.Text)
+	suite.Assert().Equal("open", opened.Category.Term)
+	suite.Assert().Equal("Brought pizza", feed.Entries[1].Content.Text)
+}
+
+func (suite *FeedHandlerTestSuite) TestRSS() {
+	w := suite.get(suite.handler.RSS, "/api/space/feed.rss")
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("application/rss+xml; charset=utf-8", w.Header().Get("Content-Type"))
+
+	var feed rssFeed
+	suite.Require().NoError(xml.Unmarshal(w.Body.Bytes(), &feed))
+	suite.Assert().Equal("2.0", feed.Version)
+	suite.Assert().Equal("https://example.com", feed.Channel.Link)
+	suite.Require().Len(feed.Channel.Items, 4)
+	suite.Assert().Equal("Test Space is closed", feed.Channel.Items[0].Title)
+	suite.Assert().Equal(time.Unix(3000, 0).UTC().Format(time.RFC1123Z), feed.Channel.Items[0].PubDate)
+	suite.Assert().False(feed.Channel.Items[0].GUID.IsPermaLink)
+}
+
+func (suite *FeedHandlerTestSuite) TestStableIDs() {
+	var atom atomFeed
+	suite.Require().NoError(xml.Unmarshal(suite.get(suite.handler.Atom, "/api/space/feed.atom").Body.Bytes(), &atom))
+
+	// A newer entry does not change the IDs of the older ones
+	suite.Require().NoError(suite.states.Record(services.StateTransition{Timestamp: 4000, Open: true}))
+	var rss rssFeed
+	suite.Require().NoError(xml.Unmarshal(suite.get(suite.handler.RSS, "/api/space/feed.rss").Body.Bytes(), &rss))
+
+	suite.Require().Len(rss.Channel.Items, 5)
+	for i, entry := range atom.Entries {
+		suite.Assert().Equal(entry.ID, rss.Channel.Items[i+1].GUID.ID)
+	}
+}
+
+func (suite *FeedHandlerTestSuite) TestLimit() {
+	for i := int64(0); i < FeedEntries+10; i++ {
+		suite.Require().NoError(suite.events.Record(services.RecordedEvent{Event: models.Event{Name: "Ada", Type: "check-in", Timestamp: 5000 + i}}))
+	}
+
+	var feed atomFeed
+	suite.Require().NoError(xml.Unmarshal(suite.get(suite.handler.Atom, "/api/space/feed.atom").Body.Bytes(), &feed))
+
+	suite.Assert().Len(feed.Entries, FeedEntries)
+}
+
+func (suite *FeedHandlerTestSuite) TestNotModified() {
+	w := suite.get(suite.handler.Atom, "/api/space/feed.atom")
+
+	req := httptest.NewRequest("GET", "/api/space/feed.atom", nil)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	suite.handler.Atom(w, req)
+
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+}
+
+func (suite *FeedHandlerTestSuite) TestEmpty() {
+	states, err := services.NewStateHistory("")
+	suite.Require().NoError(err)
+	events, err := services.NewEventHistory("")
+	suite.Require().NoError(err)
+	suite.spaceAPI.Events = nil
+	handler := NewFeedHandler(services.NewStore(suite.spaceAPI, nil), states, events)
+
+	var feed atomFeed
+	suite.Require().NoError(xml.Unmarshal(suite.get(handler.Atom, "/api/space/feed.atom").Body.Bytes(), &feed))
+	suite.Assert().Empty(feed.Entries)
+	suite.Assert().Equal("1970-01-01T00:00:00Z", feed.Updated)
+}
@@ -0,0 +1,250 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+// Page sizes of the history endpoints
+const (
+	DefaultHistoryLimit = 100
+	MaxHistoryLimit     = 1000
+)
+
+// Ranges of the sensor history. Queries cover the last DefaultSeriesRange
+// unless from is given, in steps of 5 minutes, or an hour for ranges longer
+// than LongSeriesRange.
+const (
+	DefaultSeriesRange = 24 * time.Hour
+	LongSeriesRange    = 48 * time.Hour
+	MaxSeriesBuckets   = 10000
+)
+
+type HistoryHandler struct {
+	states  *services.StateHistory
+	sensors *services.SensorHistory
+}
+
+// NewHistoryHandler creates a handler serving the recorded history
+func NewHistoryHandler(states *services.StateHistory, sensors *services.SensorHistory) *HistoryHandler {
+	return &HistoryHandler{
+		states:  states,
+		sensors: sensors,
+	}
+}
+
+// historyPage is one page of a history listing
+type historyPage struct {
+	Total  int         `json:"total"`
+	Offset int         `json:"offset"`
+	Limit  int         `json:"limit"`
+	Next   string      `json:"next,omitempty"`
+	Items  interface{} `json:"items"`
+}
+
+// StateHistory lists the open/close transitions between the from and to
+// query parameters, oldest first. Pages are selected with offset and limit.
+func (h *HistoryHandler) StateHistory(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, limit, err := parsePage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	transitions := h.states.Between(from, to)
+	page := historyPage{Total: len(transitions), Offset: offset, Limit: limit}
+	// Clamping first keeps offset+limit from overflowing
+	start := min(offset, len(transitions))
+	end := min(start+limit, len(transitions))
+	if start < end {
+		page.Items = transitions[start:end]
+	} else {
+		page.Items = []services.StateTransition{}
+	}
+	if end < len(transitions) {
+		page.Next = nextPageURL(r, end)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("Error encoding state history: %v", err)
+	}
+}
+
+// seriesResponse is the answer of the sensor history endpoint
+type seriesResponse struct {
+	Type   string                  `json:"type"`
+	From   int64                   `json:"from"`
+	To     int64                   `json:"to"`
+	Step   int64                   `json:"step"`
+	Series []services.SeriesResult `json:"series"`
+}
+
+// SensorHistory aggregates the readings of a sensor type between from and to
+// into buckets of step with their minimum, maximum and average. location
+// and property select single series.
+func (h *HistoryHandler) SensorHistory(w http.ResponseWriter, r *http.Request) {
+	sensorType := mux.Vars(r)["type"]
+	if !isSensorType(sensorType) {
+		http.Error(w, "Unknown sensor type, expected one of: "+strings.Join(services.SensorTypes(), ", "), http.StatusNotFound)
+		return
+	}
+
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-DefaultSeriesRange)
+	}
+	if !from.Before(to) {
+		http.Error(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	step := 5 * time.Minute
+	if to.Sub(from) > LongSeriesRange {
+		step = time.Hour
+	}
+	if value := r.URL.Query().Get("step"); value != "" {
+		if step, err = parseStep(value); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if to.Sub(from)/step > MaxSeriesBuckets {
+		http.Error(w, fmt.Sprintf("Too many steps, at most %d are returned", MaxSeriesBuckets), http.StatusBadRequest)
+		return
+	}
+
+	response := seriesResponse{
+		Type: sensorType,
+		From: from.Unix(),
+		To:   to.Unix(),
+		Step: int64(step / time.Second),
+		Series: h.sensors.Query(services.SeriesQuery{
+			Type:     sensorType,
+			Location: r.URL.Query().Get("location"),
+			Property: r.URL.Query().Get("property"),
+			From:     from,
+			To:       to,
+			Step:     step,
+		}),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding %s history: %v", sensorType, err)
+	}
+}
+
+// isSensorType reports whether sensors of sensorType can be recorded
+func isSensorType(sensorType string) bool {
+	for _, known := range services.SensorTypes() {
+		if known == sensorType {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStep accepts a duration such as "5m" or a number of seconds. Steps
+// are whole seconds.
+func parseStep(value string) (time.Duration, error) {
+	step, err := time.ParseDuration(value)
+	if seconds, convErr := strconv.Atoi(value); convErr == nil {
+		step, err = time.Duration(seconds)*time.Second, nil
+	}
+	if err != nil || step < time.Second || step%time.Second != 0 {
+		return 0, fmt.Errorf("step must be a whole number of seconds such as 300 or 5m, got %q", value)
+	}
+	return step, nil
+}
+
+// parseTimeRange reads the from and to query parameters. Either may be
+// missing, which leaves that end of the range open.
+func parseTimeRange(r *http.Request) (from, to time.Time, err error) {
+	if from, err = parseTime(r.URL.Query().Get("from")); err != nil {
+		return from, to, fmt.Errorf("invalid from: %w", err)
+	}
+	if to, err = parseTime(r.URL.Query().Get("to")); err != nil {
+		return from, to, fmt.Errorf("invalid to: %w", err)
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// parseTime accepts RFC 3339 times, dates and Unix timestamps. An empty value
+// gives the zero time.
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("expected an RFC 3339 time, a date or a Unix timestamp, got %q", value)
+}
+
+// parsePage reads the offset and limit query parameters
+func parsePage(r *http.Request) (offset, limit int, err error) {
+	limit = DefaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > MaxHistoryLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", MaxHistoryLimit)
+		}
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		if offset, err = strconv.Atoi(value); err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+	}
+	return offset, limit, nil
+}
+
+// nextPageURL returns the URL of r with offset replaced
+func nextPageURL(r *http.Request, offset int) string {
+	query := r.URL.Query()
+	query.Set("offset", strconv.Itoa(offset))
+	return r.URL.Path + "?" + query.Encode()
+}
@@ -0,0 +1,208 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/stretchr/testify/suite"
+)
+
+type HistoryHandlerTestSuite struct {
+	suite.Suite
+	history *services.StateHistory
+	sensors *services.SensorHistory
+	hour    time.Time
+	handler *HistoryHandler
+}
+
+func (suite *HistoryHandlerTestSuite) SetupTest() {
+	var err error
+	suite.history, err = services.NewStateHistory("")
+	suite.Require().NoError(err)
+	for i := int64(0); i < 5; i++ {
+		suite.Require().NoError(suite.history.Record(services.StateTransition{
+			Timestamp: 1735689600 + i*3600,
+			Open:      i%2 == 0,
+		}))
+	}
+
+	suite.sensors, err = services.NewSensorHistory(services.SeriesConfig{})
+	suite.Require().NoError(err)
+	suite.hour = time.Now().Add(-time.Hour).Truncate(time.Hour)
+	for minute, value := range []float64{20, 21, 25} {
+		reading := models.TemperatureSensor{Value: value, Unit: "°C", SensorMeta: models.SensorMeta{Location: "Lab"}}
+		suite.sensors.Record("temperature", reading, suite.hour.Add(time.Duration(minute*3)*time.Minute))
+	}
+
+	suite.handler = NewHistoryHandler(suite.history, suite.sensors)
+}
+
+func TestHistoryHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(HistoryHandlerTestSuite))
+}
+
+// stateHistoryResponse is a decoded page of the state history
+type stateHistoryResponse struct {
+	Total  int                        `json:"total"`
+	Offset int                        `json:"offset"`
+	Limit  int                        `json:"limit"`
+	Next   string                     `json:"next"`
+	Items  []services.StateTransition `json:"items"`
+}
+
+// stateHistory requests the state history with query
+func (suite *HistoryHandlerTestSuite) stateHistory(query string) (*httptest.ResponseRecorder, stateHistoryResponse) {
+	req := httptest.NewRequest("GET", "/api/space/history/state"+query, nil)
+	w := httptest.NewRecorder()
+	suite.handler.StateHistory(w, req)
+
+	var response stateHistoryResponse
+	if w.Code == http.StatusOK {
+		suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w, response
+}
+
+func (suite *HistoryHandlerTestSuite) TestAll() {
+	w, response := suite.stateHistory("")
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("application/json", w.Header().Get("Content-Type"))
+	suite.Assert().Equal(5, response.Total)
+	suite.Assert().Equal(DefaultHistoryLimit, response.Limit)
+	suite.Assert().Empty(response.Next)
+	suite.Require().Len(response.Items, 5)
+	suite.Assert().True(response.Items[0].Open)
+}
+
+func (suite *HistoryHandlerTestSuite) TestRange() {
+	_, response := suite.stateHistory("?from=2025-01-01T01:00:00Z&to=1735700400")
+
+	suite.Assert().Equal(2, response.Total)
+	suite.Require().Len(response.Items, 2)
+	suite.Assert().Equal(int64(1735693200), response.Items[0].Timestamp)
+}
+
+func (suite *HistoryHandlerTestSuite) TestPagination() {
+	_, response := suite.stateHistory("?from=2025-01-01&limit=2")
+	suite.Assert().Equal(5, response.Total)
+	suite.Require().Len(response.Items, 2)
+	suite.Assert().Equal("/api/space/history/state?from=2025-01-01&limit=2&offset=2", response.Next)
+
+	_, response = suite.stateHistory("?from=2025-01-01&limit=2&offset=4")
+	suite.Require().Len(response.Items, 1)
+	suite.Assert().Empty(response.Next)
+
+	_, response = suite.stateHistory("?offset=10")
+	suite.Assert().NotNil(response.Items)
+	suite.Assert().Empty(response.Items)
+
+	// offset+limit would overflow
+	w, response := suite.stateHistory("?offset=9223372036854775807")
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Empty(response.Items)
+	suite.Assert().Empty(response.Next)
+}
+
+func (suite *HistoryHandlerTestSuite) TestInvalidQuery() {
+	for _, query := range []string{"?from=yesterday", "?to=1.5", "?from=2025-01-02&to=2025-01-01", "?limit=0", "?limit=100000", "?offset=-1"} {
+		w, _ := suite.stateHistory(query)
+		suite.Assert().Equal(http.StatusBadRequest, w.Code, query)
+	}
+}
+
+// sensorHistoryResponse is a decoded sensor history
+type sensorHistoryResponse struct {
+	Type   string                  `json:"type"`
+	From   int64                   `json:"from"`
+	To     int64                   `json:"to"`
+	Step   int64                   `json:"step"`
+	Series []services.SeriesResult `json:"series"`
+}
+
+// sensorHistory requests the history of sensorType with query
+func (suite *HistoryHandlerTestSuite) sensorHistory(sensorType, query string) (*httptest.ResponseRecorder, sensorHistoryResponse) {
+	req := httptest.NewRequest("GET", "/api/space/sensors/"+sensorType+"/history"+query, nil)
+	req = mux.SetURLVars(req, map[string]string{"type": sensorType})
+	w := httptest.NewRecorder()
+	suite.handler.SensorHistory(w, req)
+
+	var response sensorHistoryResponse
+	if w.Code == http.StatusOK {
+		suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	}
+	return w, response
+}
+
+func (suite *HistoryHandlerTestSuite) TestSensorHistory_Defaults() {
+	w, response := suite.sensorHistory("temperature", "")
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("application/json", w.Header().Get("Content-Type"))
+	suite.Assert().Equal("temperature", response.Type)
+	suite.Assert().Equal(int64(300), response.Step)
+	suite.Assert().Equal(int64(DefaultSeriesRange/time.Second), response.To-response.From)
+	suite.Require().Len(response.Series, 1)
+	suite.Assert().Equal("Lab", response.Series[0].Location)
+	suite.Assert().Equal([]services.SeriesBucket{
+		{Time: suite.hour.Unix(), Min: 20, Max: 21, Avg: 20.5, Count: 2},
+		{Time: suite.hour.Add(5 * time.Minute).Unix(), Min: 25, Max: 25, Avg: 25, Count: 1},
+	}, response.Series[0].Buckets)
+}
+
+func (suite *HistoryHandlerTestSuite) TestSensorHistory_Step() {
+	from := strconv.FormatInt(suite.hour.Unix(), 10)
+	_, response := suite.sensorHistory("temperature", "?from="+from+"&step=1h&location=Lab")
+
+	suite.Assert().Equal(int64(3600), response.Step)
+	suite.Require().Len(response.Series, 1)
+	suite.Assert().Equal([]services.SeriesBucket{{Time: suite.hour.Unix(), Min: 20, Max: 25, Avg: 22, Count: 3}}, response.Series[0].Buckets)
+
+	_, response = suite.sensorHistory("temperature", "?from="+from+"&step=60")
+	suite.Assert().Len(response.Series[0].Buckets, 3)
+
+	_, response = suite.sensorHistory("temperature", "?location=Roof")
+	suite.Assert().NotNil(response.Series)
+	suite.Assert().Empty(response.Series)
+}
+
+func (suite *HistoryHandlerTestSuite) TestSensorHistory_LongRange() {
+	_, response := suite.sensorHistory("temperature", "?from="+strconv.FormatInt(suite.hour.Add(-7*24*time.Hour).Unix(), 10))
+
+	suite.Assert().Equal(int64(3600), response.Step)
+}
+
+func (suite *HistoryHandlerTestSuite) TestSensorHistory_UnknownType() {
+	w, _ := suite.sensorHistory("flux_capacitor", "")
+
+	suite.Assert().Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *HistoryHandlerTestSuite) TestSensorHistory_InvalidQuery() {
+	for _, query := range []string{"?step=0", "?step=1.5s", "?step=fast", "?step=1s&from=2020-01-01", "?from=2999-01-01", "?to=yesterday"} {
+		w, _ := suite.sensorHistory("temperature", query)
+		suite.Assert().Equal(http.StatusBadRequest, w.Code, query)
+	}
+}
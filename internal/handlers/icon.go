@@ -0,0 +1,124 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"embed"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+// builtinIcons are served when the document has no icon for the state
+//
+//go:embed icons/*.svg
+var builtinIcons embed.FS
+
+type IconHandler struct {
+	store *services.Store
+	icons *services.IconCache
+	proxy bool
+}
+
+// NewIconHandler creates a handler pointing to the icon of the current
+// state. icons, if not nil, allows serving copies of the icons instead of
+// redirecting.
+func NewIconHandler(store *services.Store, icons *services.IconCache) *IconHandler {
+	return &IconHandler{
+		store: store,
+		icons: icons,
+	}
+}
+
+// SetProxy makes the handler serve cached copies of the icons by default
+// rather than redirecting to them
+func (h *IconHandler) SetProxy(proxy bool) {
+	h.proxy = proxy
+}
+
+// Icon redirects to the icon of the current state, or serves a cached copy
+// of it if proxying is enabled or asked for with proxy=true. A built-in icon
+// is served if the document has none or it cannot be fetched.
+func (h *IconHandler) Icon(w http.ResponseWriter, r *http.Request) {
+	spaceAPI := h.store.Snapshot()
+
+	name, iconURL := "unknown", ""
+	if state := spaceAPI.State; state != nil && state.Open != nil {
+		name = "closed"
+		if *state.Open {
+			name = "open"
+		}
+		if state.Icon != nil {
+			iconURL = state.Icon.Closed
+			if *state.Open {
+				iconURL = state.Icon.Open
+			}
+		}
+	}
+
+	// The answer changes with the state, so caches must always ask again
+	w.Header().Set("Cache-Control", "no-cache")
+	if iconURL == "" {
+		h.serveBuiltin(w, r, name)
+		return
+	}
+
+	proxy := h.proxy
+	if value := r.URL.Query().Get("proxy"); value != "" {
+		proxy = isTrue(value)
+	}
+	if !proxy || h.icons == nil {
+		http.Redirect(w, r, iconURL, http.StatusFound)
+		return
+	}
+
+	icon, err := h.icons.Get(r.Context(), iconURL)
+	if err != nil {
+		log.Printf("Error fetching %s icon, serving the built-in one: %v", name, err)
+		h.serveBuiltin(w, r, name)
+		return
+	}
+	serveIcon(w, r, icon.Data, icon.ContentType)
+}
+
+// serveBuiltin serves the built-in icon called name
+func (h *IconHandler) serveBuiltin(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := builtinIcons.ReadFile("icons/" + name + ".svg")
+	if err != nil {
+		log.Printf("Error reading built-in %s icon: %v", name, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	serveIcon(w, r, data, "image/svg+xml")
+}
+
+// serveIcon writes an icon with an entity tag, answering revalidations with
+// 304. Icons come from elsewhere, so scripts in SVG icons are not run.
+func serveIcon(w http.ResponseWriter, r *http.Request, data []byte, contentType string) {
+	if checkContentETag(w, r, data) {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(data); err != nil {
+		log.Printf("Error writing icon: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type IconHandlerTestSuite struct {
+	suite.Suite
+	origin   *httptest.Server
+	spaceAPI *models.SpaceAPI
+}
+
+func (suite *IconHandlerTestSuite) SetupTest() {
+	suite.origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/open.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("open icon"))
+	}))
+
+	suite.spaceAPI = testutil.NewMockSpaceAPI()
+	suite.spaceAPI.State.Icon = &models.Icon{
+		Open:   suite.origin.URL + "/open.png",
+		Closed: suite.origin.URL + "/closed.png",
+	}
+}
+
+func (suite *IconHandlerTestSuite) TearDownTest() {
+	suite.origin.Close()
+}
+
+func TestIconHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(IconHandlerTestSuite))
+}
+
+func (suite *IconHandlerTestSuite) get(handler *IconHandler, query string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/space/icon"+query, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	handler.Icon(w, req)
+	return w
+}
+
+func (suite *IconHandlerTestSuite) newHandler() *IconHandler {
+	return NewIconHandler(services.NewStore(suite.spaceAPI, nil), services.NewIconCache(services.DefaultIconTTL))
+}
+
+func (suite *IconHandlerTestSuite) TestRedirect() {
+	w := suite.get(suite.newHandler(), "", nil)
+
+	suite.Assert().Equal(http.StatusFound, w.Code)
+	suite.Assert().Equal(suite.origin.URL+"/open.png", w.Header().Get("Location"))
+	suite.Assert().Equal("no-cache", w.Header().Get("Cache-Control"))
+}
+
+func (suite *IconHandlerTestSuite) TestRedirect_Closed() {
+	suite.spaceAPI.State.Open = models.BoolPtr(false)
+
+	w := suite.get(suite.newHandler(), "", nil)
+
+	suite.Assert().Equal(suite.origin.URL+"/closed.png", w.Header().Get("Location"))
+}
+
+func (suite *IconHandlerTestSuite) TestProxy() {
+	w := suite.get(suite.newHandler(), "?proxy=true", nil)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("image/png", w.Header().Get("Content-Type"))
+	suite.Assert().Equal("open icon", w.Body.String())
+	suite.Assert().Equal("nosniff", w.Header().Get("X-Content-Type-Options"))
+
+	w = suite.get(suite.newHandler(), "?proxy=true", map[string]string{"If-None-Match": w.Header().Get("ETag")})
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+}
+
+func (suite *IconHandlerTestSuite) TestProxy_ByDefault() {
+	handler := suite.newHandler()
+	handler.SetProxy(true)
+
+	suite.Assert().Equal(http.StatusOK, suite.get(handler, "", nil).Code)
+	suite.Assert().Equal(http.StatusFound, suite.get(handler, "?proxy=false", nil).Code)
+}
+
+func (suite *IconHandlerTestSuite) TestProxy_WithoutCache() {
+	handler := NewIconHandler(services.NewStore(suite.spaceAPI, nil), nil)
+
+	suite.Assert().Equal(http.StatusFound, suite.get(handler, "?proxy=true", nil).Code)
+}
+
+func (suite *IconHandlerTestSuite) TestProxy_FallsBackToBuiltin() {
+	suite.spaceAPI.State.Open = models.BoolPtr(false)
+
+	w := suite.get(suite.newHandler(), "?proxy=true", nil)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("image/svg+xml", w.Header().Get("Content-Type"))
+	suite.Assert().Contains(w.Body.String(), "CLOSED")
+}
+
+func (suite *IconHandlerTestSuite) TestBuiltin() {
+	suite.spaceAPI.State.Icon = nil
+
+	w := suite.get(suite.newHandler(), "", nil)
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("image/svg+xml", w.Header().Get("Content-Type"))
+	suite.Assert().Contains(w.Body.String(), "OPEN")
+	suite.Assert().NotEmpty(w.Header().Get("Content-Security-Policy"))
+
+	suite.spaceAPI.State = nil
+	w = suite.get(suite.newHandler(), "", nil)
+	suite.Assert().Contains(w.Body.String(), `aria-label="unknown"`)
+}
@@ -0,0 +1,369 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+// pageTemplates are the built-in templates of the status page
+//
+//go:embed templates/*.html
+var pageTemplates embed.FS
+
+// pageTemplate is the template rendering the status page
+const pageTemplate = "page.html"
+
+// pageEvents is how many events the status page shows
+const pageEvents = 5
+
+// pageURLSchemes are the schemes allowed in links taken from the document
+var pageURLSchemes = map[string]bool{
+	"http": true, "https": true, "mailto": true, "tel": true, "sip": true,
+	"xmpp": true, "irc": true, "ircs": true, "matrix": true,
+}
+
+// LoadPageTemplates parses the built-in status page templates and then the
+// *.html files in dir, if not empty. A file in dir replaces the built-in
+// file of the same name, so a theme may override only style.html.
+func LoadPageTemplates(dir string) (*template.Template, error) {
+	templates, err := template.New("").Funcs(template.FuncMap{"join": strings.Join}).ParseFS(pageTemplates, "templates/*.html")
+	if err != nil {
+		return nil, fmt.Errorf("could not parse built-in page templates: %w", err)
+	}
+	if dir == "" {
+		return templates, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list page templates: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.html page templates in %s", dir)
+	}
+	if templates, err = templates.ParseFiles(files...); err != nil {
+		return nil, fmt.Errorf("could not parse page templates: %w", err)
+	}
+	return templates, nil
+}
+
+type StatusPageHandler struct {
+	store     *services.Store
+	templates *template.Template
+	document  http.HandlerFunc
+	now       func() time.Time
+}
+
+// NewStatusPageHandler creates a handler rendering the document in store
+// with templates for browsers. Other clients are passed on to document.
+func NewStatusPageHandler(store *services.Store, templates *template.Template, document http.HandlerFunc) *StatusPageHandler {
+	return &StatusPageHandler{
+		store:     store,
+		templates: templates,
+		document:  document,
+		now:       time.Now,
+	}
+}
+
+// statusPage is the data the page templates are executed with
+type statusPage struct {
+	// Document is the whole document for templates needing more
+	Document *models.SpaceAPI
+	Space    string
+	Logo     string
+	URL      string
+	// Status is open, closed or unknown
+	Status   string
+	Message  string
+	Since    *time.Time
+	Duration string
+	People   *pagePeople
+	Sensors  []pageSensor
+	// Events are the upcoming events, soonest first, if there are any, and
+	// the latest events, newest first, otherwise. Upcoming tells which.
+	Events   []pageEvent
+	Upcoming bool
+	Contacts []pageContact
+	Location *pageLocation
+}
+
+type pagePeople struct {
+	Count int
+	Names []string
+}
+
+type pageSensor struct {
+	Type     string
+	Location string
+	Name     string
+	Value    string
+}
+
+type pageEvent struct {
+	Name  string
+	Type  string
+	Time  time.Time
+	Extra string
+}
+
+type pageContact struct {
+	Label string
+	Value string
+	URL   template.URL
+}
+
+type pageLocation struct {
+	Address string
+	Hint    string
+	MapURL  string
+}
+
+// StatusPage renders the status page for clients preferring HTML, as
+// browsers do, and serves the JSON document to all others. format=html or
+// format=json picks the representation explicitly.
+func (h *StatusPageHandler) StatusPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	switch format := r.URL.Query().Get("format"); {
+	case format == "json", format == "" && !prefersHTML(r.Header.Get("Accept")):
+		h.document(w, r)
+		return
+	case format != "html" && format != "":
+		http.Error(w, "Unknown format, expected html or json", http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.templates.ExecuteTemplate(&buf, pageTemplate, h.page()); err != nil {
+		log.Printf("Error rendering status page: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	body := buf.Bytes()
+
+	w.Header().Set("Cache-Control", DefaultCacheControl)
+	if checkContentETag(w, r, body) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Error writing status page: %v", err)
+	}
+}
+
+// page collects the data shown on the status page
+func (h *StatusPageHandler) page() statusPage {
+	spaceAPI := h.store.Snapshot()
+	location := spaceLocation(spaceAPI)
+	now := h.now()
+
+	page := statusPage{
+		Document: spaceAPI,
+		Space:    spaceAPI.Space,
+		Logo:     spaceAPI.Logo,
+		URL:      spaceAPI.URL,
+		Status:   "unknown",
+	}
+	if state := spaceAPI.State; state != nil {
+		if state.Open != nil {
+			page.Status = map[bool]string{true: "open", false: "closed"}[*state.Open]
+		}
+		page.Message = state.Message
+		if state.Lastchange > 0 {
+			since := time.Unix(state.Lastchange, 0).In(location)
+			page.Since = &since
+			page.Duration = formatSince(now.Sub(since))
+		}
+	}
+
+	if sensors := spaceAPI.Sensors; sensors != nil {
+		if len(sensors.PeopleNowPresent) > 0 {
+			page.People = &pagePeople{}
+			for _, reading := range sensors.PeopleNowPresent {
+				page.People.Count += reading.Value
+				page.People.Names = append(page.People.Names, reading.Names...)
+			}
+		}
+		for _, sensorType := range services.SensorTypes() {
+			if sensorType == "people_now_present" {
+				continue
+			}
+			for _, reading := range sensorReadings(sensors, sensorType) {
+				value, ok := readingMessage(sensorType, reading, defaultProperties[sensorType])
+				if !ok {
+					continue
+				}
+				location, _ := reading["location"].(string)
+				name, _ := reading["name"].(string)
+				page.Sensors = append(page.Sensors, pageSensor{
+					Type:     strings.ReplaceAll(sensorType, "_", " "),
+					Location: location,
+					Name:     name,
+					Value:    value,
+				})
+			}
+		}
+	}
+
+	// Events added through the API are stamped when they are added, so
+	// future events only come from the configuration file. Walking backwards
+	// keeps past events of the same second newest first.
+	var upcoming, recent []pageEvent
+	for i := len(spaceAPI.Events) - 1; i >= 0; i-- {
+		event := spaceAPI.Events[i]
+		shown := pageEvent{
+			Name:  event.Name,
+			Type:  event.Type,
+			Time:  time.Unix(event.Timestamp, 0).In(location),
+			Extra: event.Extra,
+		}
+		if event.Timestamp > now.Unix() {
+			upcoming = append(upcoming, shown)
+		} else {
+			recent = append(recent, shown)
+		}
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool {
+		return upcoming[i].Time.Before(upcoming[j].Time)
+	})
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].Time.After(recent[j].Time)
+	})
+	page.Events = recent
+	if len(upcoming) > 0 {
+		page.Events, page.Upcoming = upcoming, true
+	}
+	if len(page.Events) > pageEvents {
+		page.Events = page.Events[:pageEvents]
+	}
+
+	page.Contacts = pageContacts(spaceAPI.Contact)
+
+	if spaceAPI.Location != nil {
+		page.Location = &pageLocation{
+			Address: spaceAPI.Location.Address,
+			Hint:    spaceAPI.Location.Hint,
+		}
+		// 0,0 is what an unconfigured location looks like
+		if spaceAPI.Location.Lat != 0 || spaceAPI.Location.Lon != 0 {
+			lat := strconv.FormatFloat(spaceAPI.Location.Lat, 'f', -1, 64)
+			lon := strconv.FormatFloat(spaceAPI.Location.Lon, 'f', -1, 64)
+			page.Location.MapURL = "https://www.openstreetmap.org/?mlat=" + lat + "&mlon=" + lon + "#map=18/" + lat + "/" + lon
+		}
+	}
+
+	return page
+}
+
+// pageContacts lists the ways to contact the space, linked where possible
+func pageContacts(contact models.Contact) []pageContact {
+	var contacts []pageContact
+	add := func(label, value, link string) {
+		if value != "" {
+			contacts = append(contacts, pageContact{Label: label, Value: value, URL: safeURL(link)})
+		}
+	}
+
+	add("Email", contact.Email, "mailto:"+contact.Email)
+	add("Mailing list", contact.ML, "mailto:"+contact.ML)
+	add("Phone", contact.Phone, "tel:"+strings.ReplaceAll(contact.Phone, " ", ""))
+	add("SIP", contact.Sip, contact.Sip)
+	add("IRC", contact.IRC, contact.IRC)
+	add("XMPP", contact.XMPP, "xmpp:"+contact.XMPP)
+	add("Mastodon", contact.Mastodon, mastodonURL(contact.Mastodon))
+	add("Twitter", contact.Twitter, "https://twitter.com/"+strings.TrimPrefix(contact.Twitter, "@"))
+	add("Facebook", contact.Facebook, contact.Facebook)
+	add("Issues", contact.IssueMail, "mailto:"+contact.IssueMail)
+	return contacts
+}
+
+// mastodonURL returns the profile URL of a Mastodon handle such as
+// @user@example.social, or handle itself if it is not one
+func mastodonURL(handle string) string {
+	user, host, ok := strings.Cut(strings.TrimPrefix(handle, "@"), "@")
+	if !ok || user == "" || host == "" || strings.ContainsAny(host, "/?#") {
+		return handle
+	}
+	return "https://" + host + "/@" + url.PathEscape(user)
+}
+
+// safeURL returns link as a URL for the templates if its scheme is allowed,
+// otherwise an empty URL so the value is shown without a link
+func safeURL(link string) template.URL {
+	parsed, err := url.Parse(link)
+	if err != nil || !pageURLSchemes[strings.ToLower(parsed.Scheme)] {
+		return ""
+	}
+	return template.URL(parsed.String())
+}
+
+// prefersHTML reports whether an Accept header ranks HTML above JSON
+func prefersHTML(header string) bool {
+	html := acceptQuality(header, "text/html")
+	return html > 0 && html > acceptQuality(header, "application/json")
+}
+
+// acceptQuality returns the quality an Accept header gives mediaType, taken
+// from the most specific matching range
+func acceptQuality(header, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, field := range strings.Split(header, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(field))
+		if err != nil {
+			continue
+		}
+		var match int
+		switch accepted {
+		case mediaType:
+			match = 2
+		case mainType + "/*":
+			match = 1
+		case "*/*":
+			match = 0
+		default:
+			continue
+		}
+		if match <= specificity {
+			continue
+		}
+		specificity, quality = match, 1
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				quality = 0
+			}
+		}
+	}
+	return quality
+}
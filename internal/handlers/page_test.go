@@ -0,0 +1,213 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+const browserAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+type StatusPageHandlerTestSuite struct {
+	suite.Suite
+	spaceAPI *models.SpaceAPI
+	handler  *StatusPageHandler
+}
+
+func (suite *StatusPageHandlerTestSuite) SetupTest() {
+	suite.spaceAPI = testutil.NewMockSpaceAPI()
+	suite.spaceAPI.State.Lastchange = time.Now().Add(-135 * time.Minute).Unix()
+	suite.spaceAPI.Sensors.Temperature = []models.TemperatureSensor{
+		{Value: 21.5, Unit: "°C", SensorMeta: models.SensorMeta{Location: "Lab"}},
+	}
+	suite.handler = suite.newHandler("")
+}
+
+func TestStatusPageHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(StatusPageHandlerTestSuite))
+}
+
+func (suite *StatusPageHandlerTestSuite) newHandler(dir string) *StatusPageHandler {
+	templates, err := LoadPageTemplates(dir)
+	suite.Require().NoError(err)
+	store := services.NewStore(suite.spaceAPI, nil)
+	return NewStatusPageHandler(store, templates, NewSpaceAPIHandler(store, nil).GetSpaceAPI)
+}
+
+func (suite *StatusPageHandlerTestSuite) get(query, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/"+query, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	suite.handler.StatusPage(w, req)
+	return w
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_HTML() {
+	w := suite.get("", browserAccept)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	suite.Assert().Contains(w.Header().Values("Vary"), "Accept")
+	suite.Assert().NotEmpty(w.Header().Get("ETag"))
+
+	body := w.Body.String()
+	suite.Assert().Contains(body, "<title>Test Space is open</title>")
+	suite.Assert().Contains(body, "Space is open for testing")
+	suite.Assert().Contains(body, "(2h 15m)")
+	suite.Assert().Contains(body, "3 people present")
+	suite.Assert().Contains(body, "21.5°C")
+	suite.Assert().Contains(body, "Test Event (check-in) – Test event description")
+	suite.Assert().Contains(body, `href="mailto:test@example.com"`)
+	suite.Assert().Contains(body, `href="https://twitter.com/testspace"`)
+	suite.Assert().Contains(body, "https://www.openstreetmap.org/?mlat=40.7128&amp;mlon=-74.006")
+	// Times are shown in the timezone of the space
+	suite.Assert().Regexp(`E[SD]T</time>`, body)
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_JSON() {
+	for _, accept := range []string{"", "*/*", "application/json", "application/json, text/html;q=0.5"} {
+		w := suite.get("", accept)
+		suite.Assert().Equal("application/json", w.Header().Get("Content-Type"), accept)
+		suite.Assert().Contains(w.Header().Values("Vary"), "Accept")
+	}
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_Format() {
+	suite.Assert().Equal("application/json", suite.get("?format=json", browserAccept).Header().Get("Content-Type"))
+	suite.Assert().Equal("text/html; charset=utf-8", suite.get("?format=html", "").Header().Get("Content-Type"))
+	suite.Assert().Equal(http.StatusBadRequest, suite.get("?format=xml", "").Code)
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_NotModified() {
+	w := suite.get("", browserAccept)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept", browserAccept)
+	req.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	suite.handler.StatusPage(w, req)
+
+	suite.Assert().Equal(http.StatusNotModified, w.Code)
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_Escaping() {
+	suite.spaceAPI.State.Message = "<script>alert(1)</script>"
+	suite.spaceAPI.URL = "javascript:alert(1)"
+	suite.spaceAPI.Contact.IRC = "javascript:alert(1)"
+	suite.handler = suite.newHandler("")
+
+	body := suite.get("", browserAccept).Body.String()
+
+	suite.Assert().NotContains(body, "<script>")
+	suite.Assert().NotContains(body, `href="javascript:`)
+	suite.Assert().Contains(body, "&lt;script&gt;")
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_Unknown() {
+	suite.spaceAPI.State = nil
+	suite.spaceAPI.Sensors = nil
+	suite.spaceAPI.Location.Lat, suite.spaceAPI.Location.Lon = 0, 0
+	suite.handler = suite.newHandler("")
+
+	body := suite.get("", browserAccept).Body.String()
+
+	suite.Assert().Contains(body, `<body class="unknown">`)
+	suite.Assert().NotContains(body, "present")
+	suite.Assert().NotContains(body, "Since")
+	suite.Assert().NotContains(body, "Show on map")
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_RecentEvents() {
+	templates, err := LoadPageTemplates("")
+	suite.Require().NoError(err)
+	store := services.NewStore(suite.spaceAPI, nil)
+	spaceAPIHandler := NewSpaceAPIHandler(store, nil)
+	suite.handler = NewStatusPageHandler(store, templates, spaceAPIHandler.GetSpaceAPI)
+
+	for _, name := range []string{"Ada", "Bo", "Cy", "Di", "Ed", "Flo"} {
+		req := httptest.NewRequest("POST", "/api/space/event", strings.NewReader(`{"name": "`+name+`", "type": "check-in"}`))
+		w := httptest.NewRecorder()
+		spaceAPIHandler.AddEvent(w, req)
+		suite.Require().Equal(http.StatusOK, w.Code)
+	}
+
+	body := suite.get("", browserAccept).Body.String()
+
+	suite.Assert().Contains(body, "Recent events", "without upcoming events the latest are shown")
+	suite.Assert().Regexp(`(?s)Flo \(check-in\).*Ed .*Di .*Cy .*Bo `, body, "newest first")
+	suite.Assert().NotContains(body, "Ada", "only the latest events are shown")
+	suite.Assert().NotContains(body, "Test Event")
+}
+
+func (suite *StatusPageHandlerTestSuite) TestStatusPage_UpcomingEvents() {
+	for i, name := range []string{"Repair Café", "Soldering Workshop"} {
+		suite.spaceAPI.Events = append(suite.spaceAPI.Events, models.Event{
+			Name:      name,
+			Type:      "workshop",
+			Timestamp: time.Now().Add(time.Duration(48-24*i) * time.Hour).Unix(),
+		})
+	}
+	suite.handler = suite.newHandler("")
+
+	body := suite.get("", browserAccept).Body.String()
+
+	suite.Assert().Contains(body, "Upcoming events")
+	suite.Assert().NotContains(body, "Recent events")
+	suite.Assert().Regexp(`(?s)Soldering Workshop.*Repair Café`, body, "soonest first")
+	suite.Assert().NotContains(body, "Test Event", "past events are left out")
+}
+
+func (suite *StatusPageHandlerTestSuite) TestLoadPageTemplates_Override() {
+	dir := suite.T().TempDir()
+	suite.Require().NoError(os.WriteFile(filepath.Join(dir, "style.html"), []byte(`{{define "style"}}body { color: hotpink; }{{end}}`), 0o644))
+	suite.handler = suite.newHandler(dir)
+
+	body := suite.get("", browserAccept).Body.String()
+
+	suite.Assert().Contains(body, "hotpink")
+	suite.Assert().Contains(body, "Test Space")
+}
+
+func (suite *StatusPageHandlerTestSuite) TestLoadPageTemplates_Errors() {
+	_, err := LoadPageTemplates(suite.T().TempDir())
+	suite.Assert().Error(err, "a directory without templates is likely a mistake")
+
+	dir := suite.T().TempDir()
+	suite.Require().NoError(os.WriteFile(filepath.Join(dir, "page.html"), []byte(`{{if}}`), 0o644))
+	_, err = LoadPageTemplates(dir)
+	suite.Assert().Error(err)
+}
+
+func (suite *StatusPageHandlerTestSuite) TestAcceptQuality() {
+	suite.Assert().Equal(1.0, acceptQuality("text/html", "text/html"))
+	suite.Assert().Equal(0.8, acceptQuality(browserAccept, "application/json"))
+	suite.Assert().Equal(0.5, acceptQuality("text/*;q=0.5, */*", "text/html"))
+	suite.Assert().Equal(0.0, acceptQuality("text/html;q=0, */*", "text/html"))
+	suite.Assert().Equal(0.0, acceptQuality("image/png", "text/html"))
+}
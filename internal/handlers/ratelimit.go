@@ -0,0 +1,61 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/middleware"
+)
+
+type RateLimitHandler struct {
+	limiter *middleware.RateLimiter
+}
+
+// NewRateLimitHandler creates a handler managing the blocks of limiter
+func NewRateLimitHandler(limiter *middleware.RateLimiter) *RateLimitHandler {
+	return &RateLimitHandler{
+		limiter: limiter,
+	}
+}
+
+// ListBlocks returns the addresses blocked after failed authentication
+func (h *RateLimitHandler) ListBlocks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.limiter.Blocked()); err != nil {
+		log.Printf("Error encoding blocked addresses: %v", err)
+	}
+}
+
+// ClearBlocks unblocks the address given in the address query parameter, or
+// every address if none is given
+func (h *RateLimitHandler) ClearBlocks(w http.ResponseWriter, r *http.Request) {
+	if address := r.URL.Query().Get("address"); address != "" {
+		if !h.limiter.Unblock(address) {
+			http.Error(w, "Address is not blocked", http.StatusNotFound)
+			return
+		}
+		log.Printf("Unblocked %s by %s", address, requester(r))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	count := h.limiter.UnblockAll()
+	log.Printf("Unblocked %d addresses by %s", count, requester(r))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/middleware"
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimitHandlerTestSuite struct {
+	suite.Suite
+	limiter *middleware.RateLimiter
+	handler *RateLimitHandler
+}
+
+func (suite *RateLimitHandlerTestSuite) SetupTest() {
+	var err error
+	suite.limiter, err = middleware.NewRateLimiter(middleware.RateLimitConfig{MaxAttempts: 1})
+	suite.Require().NoError(err)
+	suite.handler = NewRateLimitHandler(suite.limiter)
+
+	keys, err := middleware.NewKeyStore([]middleware.APIKey{
+		{Name: "admin", Hash: middleware.HashKey("admin-secret"), Scopes: []string{middleware.ScopeAdmin}},
+	})
+	suite.Require().NoError(err)
+	middleware.SetKeyStore(keys)
+	middleware.SetRateLimiter(suite.limiter)
+}
+
+func (suite *RateLimitHandlerTestSuite) TearDownTest() {
+	middleware.SetKeyStore(nil)
+	middleware.SetRateLimiter(nil)
+}
+
+func TestRateLimitHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimitHandlerTestSuite))
+}
+
+// block fails authentication once from each address
+func (suite *RateLimitHandlerTestSuite) block(addresses ...string) {
+	handler := middleware.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, address := range addresses {
+		req := httptest.NewRequest("POST", "/api/space/state", nil)
+		req.RemoteAddr = address
+		req.Header.Set("X-API-Key", "wrong")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
+func (suite *RateLimitHandlerTestSuite) TestListBlocks() {
+	suite.block("192.0.2.1:1234", "[2001:db8::1]:1234")
+
+	w := httptest.NewRecorder()
+	suite.handler.ListBlocks(w, httptest.NewRequest("GET", "/api/space/auth/blocks", nil))
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	var blocks []middleware.BlockedAddress
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &blocks))
+	suite.Require().Len(blocks, 2)
+	suite.Assert().Equal("192.0.2.1", blocks[0].Address)
+	suite.Assert().Equal("2001:db8::/64", blocks[1].Address)
+}
+
+func (suite *RateLimitHandlerTestSuite) TestListBlocks_Empty() {
+	w := httptest.NewRecorder()
+	suite.handler.ListBlocks(w, httptest.NewRequest("GET", "/api/space/auth/blocks", nil))
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().JSONEq(`[]`, w.Body.String())
+}
+
+func (suite *RateLimitHandlerTestSuite) TestClearBlocks_Address() {
+	suite.block("192.0.2.1:1234", "192.0.2.2:1234")
+
+	w := httptest.NewRecorder()
+	suite.handler.ClearBlocks(w, httptest.NewRequest("DELETE", "/api/space/auth/blocks?address=192.0.2.1", nil))
+
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	blocks := suite.limiter.Blocked()
+	suite.Require().Len(blocks, 1)
+	suite.Assert().Equal("192.0.2.2", blocks[0].Address)
+}
+
+func (suite *RateLimitHandlerTestSuite) TestClearBlocks_UnknownAddress() {
+	w := httptest.NewRecorder()
+	suite.handler.ClearBlocks(w, httptest.NewRequest("DELETE", "/api/space/auth/blocks?address=192.0.2.9", nil))
+
+	suite.Assert().Equal(http.StatusNotFound, w.Code)
+}
+
+func (suite *RateLimitHandlerTestSuite) TestClearBlocks_All() {
+	suite.block("192.0.2.1:1234", "[2001:db8::1]:1234")
+
+	w := httptest.NewRecorder()
+	suite.handler.ClearBlocks(w, httptest.NewRequest("DELETE", "/api/space/auth/blocks", nil))
+
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	suite.Assert().Empty(suite.limiter.Blocked())
+}
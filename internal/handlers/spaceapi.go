@@ -17,27 +17,86 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/q30-space/spaceapi-endpoint/internal/middleware"
 	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
 )
 
 type SpaceAPIHandler struct {
-	spaceAPI *models.SpaceAPI
+	store        *services.Store
+	bus          *services.Bus
+	heartbeat    time.Duration
+	cacheControl string
+	document     documentCache
 }
 
-func NewSpaceAPIHandler(spaceAPI *models.SpaceAPI) *SpaceAPIHandler {
+// NewSpaceAPIHandler creates a handler serving and updating the document in
+// store. Changes are published on bus, which may be nil.
+func NewSpaceAPIHandler(store *services.Store, bus *services.Bus) *SpaceAPIHandler {
 	return &SpaceAPIHandler{
-		spaceAPI: spaceAPI,
+		store:        store,
+		bus:          bus,
+		heartbeat:    DefaultHeartbeatInterval,
+		cacheControl: DefaultCacheControl,
 	}
 }
 
+// SetCacheControl sets the Cache-Control header of the document. An empty
+// value omits the header.
+func (h *SpaceAPIHandler) SetCacheControl(value string) {
+	h.cacheControl = value
+}
+
+// publish announces a change committed by r to subscribers of the bus. It
+// is called from the store while the change is committed, so changes are
+// published in order.
+func (h *SpaceAPIHandler) publish(r *http.Request, kind string, data, previous interface{}) {
+	if h.bus != nil {
+		h.bus.PublishFrom(middleware.KeyName(r.Context()), kind, data, previous)
+	}
+}
+
+// GetSpaceAPI serves the document. Clients revalidate with If-None-Match or
+// If-Modified-Since and get 304 while the document is unchanged.
 func (h *SpaceAPIHandler) GetSpaceAPI(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(h.spaceAPI); err != nil {
+	rep, err := h.document.get(h.store)
+	if err != nil {
 		log.Printf("Error encoding SpaceAPI response: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", rep.etag(encoding))
+	w.Header().Set("Last-Modified", rep.modified.UTC().Format(http.TimeFormat))
+	if h.cacheControl != "" {
+		w.Header().Set("Cache-Control", h.cacheControl)
+	}
+
+	if rep.notModified(r) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body := rep.body(encoding)
+	w.Header().Set("Content-Type", "application/json")
+	if encoding != encodingIdentity {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		log.Printf("Error writing SpaceAPI response: %v", err)
 	}
 }
 
@@ -48,29 +107,43 @@ func (h *SpaceAPIHandler) UpdateState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.spaceAPI.State == nil {
-		h.spaceAPI.State = &models.State{}
-	}
+	var previous models.State
+	spaceAPI, err := h.store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+		if err := checkIfMatch(r, spaceAPI); err != nil {
+			return err
+		}
+		if spaceAPI.State == nil {
+			spaceAPI.State = &models.State{}
+		}
+		previous = *spaceAPI.State
 
-	if newState.Open != nil {
-		h.spaceAPI.State.Open = newState.Open
-	}
-	if newState.Message != "" {
-		h.spaceAPI.State.Message = newState.Message
-	}
-	if newState.TriggerPerson != "" {
-		h.spaceAPI.State.TriggerPerson = newState.TriggerPerson
-	}
+		if newState.Open != nil {
+			spaceAPI.State.Open = newState.Open
+		}
+		if newState.Message != "" {
+			spaceAPI.State.Message = newState.Message
+		}
+		if newState.TriggerPerson != "" {
+			spaceAPI.State.TriggerPerson = newState.TriggerPerson
+		}
 
-	h.spaceAPI.State.Lastchange = time.Now().Unix()
+		spaceAPI.State.Lastchange = time.Now().Unix()
+		return nil
+	}, func(spaceAPI *models.SpaceAPI) {
+		h.publish(r, services.ChangeState, *spaceAPI.State, previous)
+	})
+	if err != nil {
+		writeUpdateError(w, "state", err)
+		return
+	}
+	setETag(w, spaceAPI)
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(h.spaceAPI.State); err != nil {
+	if err := json.NewEncoder(w).Encode(spaceAPI.State); err != nil {
 		log.Printf("Error encoding State response: %v", err)
 	}
 
-	var ip_address string = r.RemoteAddr
-	log.Printf("%s State updated: %+v from %s", time.Unix(h.spaceAPI.State.Lastchange, 0).Format(time.RFC3339), h.spaceAPI.State, ip_address)
+	log.Printf("%s State updated: %+v from %s", time.Unix(spaceAPI.State.Lastchange, 0).Format(time.RFC3339), spaceAPI.State, requester(r))
 }
 
 func (h *SpaceAPIHandler) UpdatePeopleCount(w http.ResponseWriter, r *http.Request) {
@@ -84,36 +157,56 @@ func (h *SpaceAPIHandler) UpdatePeopleCount(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	if h.spaceAPI.Sensors == nil {
-		h.spaceAPI.Sensors = &models.Sensors{}
-	}
+	var updated models.PeopleNowPresentSensor
+	var previous []models.PeopleNowPresentSensor
+	spaceAPI, err := h.store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+		if err := checkIfMatch(r, spaceAPI); err != nil {
+			return err
+		}
+		if spaceAPI.Sensors == nil {
+			spaceAPI.Sensors = &models.Sensors{}
+		}
+		previous = append([]models.PeopleNowPresentSensor(nil), spaceAPI.Sensors.PeopleNowPresent...)
 
-	// Update or add people count sensor
-	found := false
-	for i, sensor := range h.spaceAPI.Sensors.PeopleNowPresent {
-		if sensor.Location == request.Location || (request.Location == "" && sensor.Location == "Main Space") {
-			h.spaceAPI.Sensors.PeopleNowPresent[i].Value = request.Value
-			h.spaceAPI.Sensors.PeopleNowPresent[i].Lastchange = time.Now().Unix()
-			found = true
-			break
+		// Update or add people count sensor
+		found := false
+		for i, sensor := range spaceAPI.Sensors.PeopleNowPresent {
+			if sensor.Location == request.Location || (request.Location == "" && sensor.Location == "Main Space") {
+				spaceAPI.Sensors.PeopleNowPresent[i].Value = request.Value
+				spaceAPI.Sensors.PeopleNowPresent[i].Lastchange = time.Now().Unix()
+				updated = spaceAPI.Sensors.PeopleNowPresent[i]
+				found = true
+				break
+			}
 		}
-	}
 
-	if !found {
-		h.spaceAPI.Sensors.PeopleNowPresent = append(h.spaceAPI.Sensors.PeopleNowPresent, models.SensorValue{
-			Value:      request.Value,
-			Location:   request.Location,
-			Name:       "People Counter",
-			Lastchange: time.Now().Unix(),
-		})
+		if !found {
+			updated = models.PeopleNowPresentSensor{
+				Value: request.Value,
+				SensorMeta: models.SensorMeta{
+					Location:   request.Location,
+					Name:       "People Counter",
+					Lastchange: time.Now().Unix(),
+				},
+			}
+			spaceAPI.Sensors.PeopleNowPresent = append(spaceAPI.Sensors.PeopleNowPresent, updated)
+		}
+		return nil
+	}, func(spaceAPI *models.SpaceAPI) {
+		h.publish(r, services.ChangePeople, spaceAPI.Sensors.PeopleNowPresent, previous)
+	})
+	if err != nil {
+		writeUpdateError(w, "people count", err)
+		return
 	}
+	setETag(w, spaceAPI)
 
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(h.spaceAPI.Sensors.PeopleNowPresent); err != nil {
+	if err := json.NewEncoder(w).Encode(spaceAPI.Sensors.PeopleNowPresent); err != nil {
 		log.Printf("Error encoding PeopleNowPresent response: %v", err)
 	}
 
-	log.Printf("%s People count updated: %+v from %s", time.Unix(h.spaceAPI.Sensors.PeopleNowPresent[0].Lastchange, 0).Format(time.RFC3339), h.spaceAPI.Sensors.PeopleNowPresent[0], r.RemoteAddr)
+	log.Printf("%s People count updated: %+v from %s", time.Unix(updated.Lastchange, 0).Format(time.RFC3339), updated, requester(r))
 }
 
 func (h *SpaceAPIHandler) AddEvent(w http.ResponseWriter, r *http.Request) {
@@ -124,19 +217,172 @@ func (h *SpaceAPIHandler) AddEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	event.Timestamp = time.Now().Unix()
-	h.spaceAPI.Events = append(h.spaceAPI.Events, event)
+	spaceAPI, err := h.store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+		if err := checkIfMatch(r, spaceAPI); err != nil {
+			return err
+		}
+		spaceAPI.Events = append(spaceAPI.Events, event)
 
-	// Keep only last 10 events
-	if len(h.spaceAPI.Events) > 10 {
-		h.spaceAPI.Events = h.spaceAPI.Events[len(h.spaceAPI.Events)-10:]
+		// Keep only last 10 events
+		if len(spaceAPI.Events) > 10 {
+			spaceAPI.Events = spaceAPI.Events[len(spaceAPI.Events)-10:]
+		}
+		return nil
+	}, func(*models.SpaceAPI) {
+		h.publish(r, services.ChangeEvent, event, nil)
+	})
+	if err != nil {
+		writeUpdateError(w, "event", err)
+		return
 	}
+	setETag(w, spaceAPI)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(event); err != nil {
 		log.Printf("Error encoding Event response: %v", err)
 	}
 
-	log.Printf("%s Event added: %+v from %s", time.Unix(event.Timestamp, 0).Format(time.RFC3339), event, r.RemoteAddr)
+	log.Printf("%s Event added: %+v from %s", time.Unix(event.Timestamp, 0).Format(time.RFC3339), event, requester(r))
+}
+
+func (h *SpaceAPIHandler) UpdateSensor(w http.ResponseWriter, r *http.Request) {
+	sensorType := mux.Vars(r)["type"]
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil || !json.Valid(data) {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	var updated, readings interface{}
+	spaceAPI, err := h.store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+		if err := checkIfMatch(r, spaceAPI); err != nil {
+			return err
+		}
+		var err error
+		updated, readings, err = services.UpdateSensor(spaceAPI, sensorType, data)
+		return err
+	}, func(*models.SpaceAPI) {
+		h.publish(r, services.ChangeSensor, services.SensorChange{Type: sensorType, Reading: updated, Readings: readings}, nil)
+	})
+	var sensorErr *services.SensorError
+	switch {
+	case errors.Is(err, services.ErrUnknownSensorType):
+		http.Error(w, "Unknown sensor type, expected one of: "+strings.Join(services.SensorTypes(), ", "), http.StatusNotFound)
+		return
+	case errors.As(err, &sensorErr):
+		http.Error(w, sensorErr.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		writeUpdateError(w, sensorType+" sensor", err)
+		return
+	}
+	setETag(w, spaceAPI)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(readings); err != nil {
+		log.Printf("Error encoding %s response: %v", sensorType, err)
+	}
+
+	log.Printf("%s Sensor %s updated: %+v from %s", time.Now().Format(time.RFC3339), sensorType, updated, requester(r))
+}
+
+// maxPatchSize limits the body of a document patch
+const maxPatchSize = 1 << 20
+
+// PatchSpaceAPI applies a JSON Merge Patch or JSON Patch to the document.
+// The parts owned by the other update endpoints cannot be patched.
+func (h *SpaceAPIHandler) PatchSpaceAPI(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != services.MergePatchType && mediaType != services.JSONPatchType {
+		w.Header().Set("Accept-Patch", services.MergePatchType+", "+services.JSONPatchType)
+		http.Error(w, "Unsupported patch format, expected "+services.MergePatchType+" or "+services.JSONPatchType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchSize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Patch too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Could not read patch", http.StatusBadRequest)
+		return
+	}
+
+	spaceAPI, err := h.store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+		if err := checkIfMatch(r, spaceAPI); err != nil {
+			return err
+		}
+		return services.PatchSpaceAPI(spaceAPI, mediaType, data)
+	}, func(spaceAPI *models.SpaceAPI) {
+		h.publish(r, services.ChangePatch, spaceAPI, nil)
+	})
+	var patchErr *services.PatchError
+	var protectedErr *services.ProtectedFieldError
+	switch {
+	case errors.As(err, &patchErr):
+		http.Error(w, patchErr.Error(), http.StatusBadRequest)
+		return
+	case errors.As(err, &protectedErr):
+		http.Error(w, protectedErr.Error(), http.StatusUnprocessableEntity)
+		return
+	case errors.Is(err, services.ErrPatchTestFailed):
+		http.Error(w, "Patch test operation failed", http.StatusConflict)
+		return
+	case err != nil:
+		writeUpdateError(w, "document", err)
+		return
+	}
+	setETag(w, spaceAPI)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(spaceAPI); err != nil {
+		log.Printf("Error encoding SpaceAPI response: %v", err)
+	}
+
+	log.Printf("%s Document patched (%s) from %s", time.Now().Format(time.RFC3339), mediaType, requester(r))
+}
+
+// requester describes who made a request for the logs
+func requester(r *http.Request) string {
+	clientIP := middleware.ClientIP(r)
+	if name := middleware.KeyName(r.Context()); name != "" {
+		return clientIP + " (key " + name + ")"
+	}
+	return clientIP
+}
+
+// writeUpdateError reports a failed store update. Updates that would produce
+// an invalid document are rejected with 422 and the list of violations,
+// updates based on a stale document with 412.
+func writeUpdateError(w http.ResponseWriter, what string, err error) {
+	if errors.Is(err, errPreconditionFailed) {
+		http.Error(w, "The document was modified since it was read, fetch it again and retry", http.StatusPreconditionFailed)
+		return
+	}
+
+	var validationErr *services.ValidationError
+	if !errors.As(err, &validationErr) {
+		log.Printf("Error updating %s: %v", what, err)
+		http.Error(w, "Could not update "+what, http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Rejected %s update: %v", what, err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	response := struct {
+		Error      string               `json:"error"`
+		Violations []services.Violation `json:"violations"`
+	}{
+		Error:      "Update would produce an invalid SpaceAPI document",
+		Violations: validationErr.Violations,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Error encoding validation response: %v", err)
+	}
 }
 
 func (h *SpaceAPIHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
@@ -18,12 +18,17 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
 	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
 	"github.com/stretchr/testify/suite"
 )
@@ -35,7 +40,7 @@ type SpaceAPIHandlerTestSuite struct {
 
 func (suite *SpaceAPIHandlerTestSuite) SetupTest() {
 	mockSpaceAPI := testutil.NewMockSpaceAPI()
-	suite.handler = NewSpaceAPIHandler(mockSpaceAPI)
+	suite.handler = NewSpaceAPIHandler(services.NewStore(mockSpaceAPI, nil), nil)
 }
 
 func TestSpaceAPIHandlerTestSuite(t *testing.T) {
@@ -130,13 +135,13 @@ func (suite *SpaceAPIHandlerTestSuite) TestUpdatePeopleCount_NewLocation() {
 	suite.Assert().Equal(http.StatusOK, w.Code)
 	suite.Assert().Equal("application/json", w.Header().Get("Content-Type"))
 
-	var response []models.SensorValue
+	var response []models.PeopleNowPresentSensor
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	suite.Assert().NoError(err)
 	suite.Assert().Len(response, 2) // Original + new
 
 	// Find the new sensor
-	var newSensor *models.SensorValue
+	var newSensor *models.PeopleNowPresentSensor
 	for _, sensor := range response {
 		if sensor.Location == "Test Location" {
 			newSensor = &sensor
@@ -144,7 +149,7 @@ func (suite *SpaceAPIHandlerTestSuite) TestUpdatePeopleCount_NewLocation() {
 		}
 	}
 	suite.Assert().NotNil(newSensor)
-	suite.Assert().Equal(float64(5), newSensor.Value)
+	suite.Assert().Equal(5, newSensor.Value)
 	suite.Assert().Equal("People Counter", newSensor.Name)
 	suite.Assert().NotZero(newSensor.Lastchange)
 }
@@ -179,11 +184,11 @@ func (suite *SpaceAPIHandlerTestSuite) TestUpdatePeopleCount_UpdateExisting() {
 
 	suite.Assert().Equal(http.StatusOK, w.Code)
 
-	var response []models.SensorValue
+	var response []models.PeopleNowPresentSensor
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	suite.Assert().NoError(err)
 	suite.Assert().Len(response, 1) // Still only one sensor
-	suite.Assert().Equal(float64(7), response[0].Value)
+	suite.Assert().Equal(7, response[0].Value)
 	suite.Assert().Equal("Main Space", response[0].Location)
 }
 
@@ -201,13 +206,13 @@ func (suite *SpaceAPIHandlerTestSuite) TestUpdatePeopleCount_DefaultLocation() {
 
 	suite.Assert().Equal(http.StatusOK, w.Code)
 
-	var response []models.SensorValue
+	var response []models.PeopleNowPresentSensor
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	suite.Assert().NoError(err)
 
 	// Should update the existing "Main Space" sensor
 	suite.Assert().Len(response, 1)
-	suite.Assert().Equal(float64(2), response[0].Value)
+	suite.Assert().Equal(2, response[0].Value)
 	suite.Assert().Equal("Main Space", response[0].Location)
 }
 
@@ -264,7 +269,7 @@ func (suite *SpaceAPIHandlerTestSuite) TestAddEvent_EventListLimit() {
 	}
 
 	// Verify only 10 events remain
-	suite.Assert().Len(suite.handler.spaceAPI.Events, 10)
+	suite.Assert().Len(suite.handler.store.Snapshot().Events, 10)
 }
 
 func (suite *SpaceAPIHandlerTestSuite) TestAddEvent_InvalidJSON() {
@@ -287,3 +292,393 @@ func (suite *SpaceAPIHandlerTestSuite) TestHealthCheck() {
 	suite.Assert().Equal(http.StatusOK, w.Code)
 	suite.Assert().Equal("OK", w.Body.String())
 }
+
+func (suite *SpaceAPIHandlerTestSuite) TestConcurrentRequests() {
+	const workers = 20
+
+	post := func(handler http.HandlerFunc, path string, body interface{}) {
+		jsonData, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		suite.Assert().Equal(http.StatusOK, w.Code)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(5)
+		go func(i int) {
+			defer wg.Done()
+			post(suite.handler.UpdateState, "/api/space/state", map[string]interface{}{
+				"open":    i%2 == 0,
+				"message": fmt.Sprintf("Update %d", i),
+			})
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			post(suite.handler.UpdatePeopleCount, "/api/space/people", map[string]interface{}{
+				"value":    i,
+				"location": fmt.Sprintf("Room %d", i%3),
+			})
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			post(suite.handler.AddEvent, "/api/space/event", models.Event{
+				Name: fmt.Sprintf("Member %d", i),
+				Type: "check-in",
+			})
+		}(i)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/api/space", nil)
+			w := httptest.NewRecorder()
+			suite.handler.GetSpaceAPI(w, req)
+			suite.Assert().Equal(http.StatusOK, w.Code)
+
+			var response models.SpaceAPI
+			suite.Assert().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+		}()
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/health", nil)
+			w := httptest.NewRecorder()
+			suite.handler.HealthCheck(w, req)
+			suite.Assert().Equal(http.StatusOK, w.Code)
+		}()
+	}
+	wg.Wait()
+
+	snapshot := suite.handler.store.Snapshot()
+	suite.Assert().Len(snapshot.Events, 10)
+	// Main Space from the mock plus one sensor per room
+	suite.Assert().Len(snapshot.Sensors.PeopleNowPresent, 4)
+	suite.Assert().NotNil(snapshot.State.Open)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdatePeopleCount_InvalidDocument() {
+	peopleData := map[string]interface{}{
+		"value":    -1,
+		"location": "Main Space",
+	}
+	jsonData, _ := json.Marshal(peopleData)
+
+	req := httptest.NewRequest("POST", "/api/space/people", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	suite.handler.UpdatePeopleCount(w, req)
+
+	suite.Assert().Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Assert().Equal("application/json", w.Header().Get("Content-Type"))
+
+	var response struct {
+		Error      string               `json:"error"`
+		Violations []services.Violation `json:"violations"`
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Require().Len(response.Violations, 1)
+	suite.Assert().Equal("sensors.people_now_present[0].value", response.Violations[0].Path)
+	suite.Assert().Equal("15", response.Violations[0].Version)
+
+	// The rejected update is not applied
+	suite.Assert().EqualValues(3, suite.handler.store.Snapshot().Sensors.PeopleNowPresent[0].Value)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateState_InvalidDocument() {
+	// A document without a state must not gain one lacking "open"
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.State = nil
+	suite.handler = NewSpaceAPIHandler(services.NewStore(spaceAPI, nil), nil)
+
+	jsonData, _ := json.Marshal(map[string]interface{}{"message": "No open flag"})
+	req := httptest.NewRequest("POST", "/api/space/state", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	suite.handler.UpdateState(w, req)
+
+	suite.Assert().Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Assert().Contains(w.Body.String(), `"path":"state"`)
+	suite.Assert().Nil(suite.handler.store.Snapshot().State)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestAddEvent_InvalidDocument() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.APICompatibility = []string{"14", "15"}
+	suite.handler = NewSpaceAPIHandler(services.NewStore(spaceAPI, nil), nil)
+
+	jsonData, _ := json.Marshal(testutil.NewMockEvent())
+	req := httptest.NewRequest("POST", "/api/space/event", bytes.NewReader(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	suite.handler.AddEvent(w, req)
+
+	// The mock document lacks the fields required by v14
+	suite.Assert().Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Assert().Contains(w.Body.String(), `"version":"14"`)
+	suite.Assert().Len(suite.handler.store.Snapshot().Events, 1)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestGetSpaceAPI_PreservesUnknownFields() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.Extra = models.Extra{"issue_report_channels": json.RawMessage(`["email"]`)}
+	spaceAPI.State.Extra = models.Extra{"ext_door": json.RawMessage(`"side entrance"`)}
+	suite.handler = NewSpaceAPIHandler(services.NewStore(spaceAPI, nil), nil)
+
+	// Unknown fields survive updates
+	jsonData, _ := json.Marshal(testutil.NewMockState())
+	req := httptest.NewRequest("POST", "/api/space/state", bytes.NewReader(jsonData))
+	w := httptest.NewRecorder()
+	suite.handler.UpdateState(w, req)
+	suite.Require().Equal(http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("GET", "/api/space", nil)
+	w = httptest.NewRecorder()
+	suite.handler.GetSpaceAPI(w, req)
+
+	var response map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Assert().Equal([]interface{}{"email"}, response["issue_report_channels"])
+	state := response["state"].(map[string]interface{})
+	suite.Assert().Equal("side entrance", state["ext_door"])
+	suite.Assert().Equal(false, state["open"])
+}
+
+// postSensor sends a sensor reading to UpdateSensor as routed by mux
+func (suite *SpaceAPIHandlerTestSuite) postSensor(sensorType string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/space/sensors/"+sensorType, bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req = mux.SetURLVars(req, map[string]string{"type": sensorType})
+	w := httptest.NewRecorder()
+	suite.handler.UpdateSensor(w, req)
+	return w
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_Temperature() {
+	w := suite.postSensor("temperature", `{"value": 21.5, "unit": "°C", "location": "Lab", "name": "Ceiling"}`)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("application/json", w.Header().Get("Content-Type"))
+
+	var response []models.TemperatureSensor
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Require().Len(response, 1)
+	suite.Assert().Equal(21.5, response[0].Value)
+	suite.Assert().Equal("°C", response[0].Unit)
+	suite.Assert().NotZero(response[0].Lastchange)
+
+	// Same location and name updates in place
+	w = suite.postSensor("temperature", `{"value": 19, "unit": "°C", "location": "Lab", "name": "Ceiling"}`)
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Require().Len(response, 1)
+	suite.Assert().Equal(float64(19), response[0].Value)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_DoorLocked() {
+	w := suite.postSensor("door_locked", `{"value": true, "location": "Front door"}`)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal(true, suite.handler.store.Snapshot().Sensors.DoorLocked[0].Value)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_InvalidValue() {
+	w := suite.postSensor("door_locked", `{"value": 1, "location": "Front door"}`)
+
+	suite.Assert().Equal(http.StatusBadRequest, w.Code)
+	suite.Assert().Equal("door_locked value must be a boolean\n", w.Body.String())
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_MissingUnit() {
+	w := suite.postSensor("power_consumption", `{"value": 350, "location": "Workshop"}`)
+
+	suite.Assert().Equal(http.StatusBadRequest, w.Code)
+	suite.Assert().Contains(w.Body.String(), "power_consumption unit must be one of")
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_SchemaViolation() {
+	// The schema requires a location for barometers
+	w := suite.postSensor("barometer", `{"value": 1013, "unit": "hPa"}`)
+
+	suite.Assert().Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Assert().Contains(w.Body.String(), "sensors.barometer[0]")
+	suite.Assert().Empty(suite.handler.store.Snapshot().Sensors.Barometer)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_UnknownType() {
+	w := suite.postSensor("flux_capacitor", `{"value": 1.21}`)
+
+	suite.Assert().Equal(http.StatusNotFound, w.Code)
+	suite.Assert().Contains(w.Body.String(), "Unknown sensor type")
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_InvalidJSON() {
+	w := suite.postSensor("temperature", "invalid json")
+
+	suite.Assert().Equal(http.StatusBadRequest, w.Code)
+	suite.Assert().Equal("Invalid JSON\n", w.Body.String())
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestUpdateSensor_Wind() {
+	w := suite.postSensor("wind", `{
+		"properties": {
+			"speed": {"value": 4.2, "unit": "km/h"},
+			"gust": {"value": 9.1, "unit": "km/h"},
+			"direction": {"value": 270, "unit": "°"},
+			"elevation": {"value": 42, "unit": "m"}
+		},
+		"location": "Roof"
+	}`)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+
+	var response []models.WindSensor
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Require().Len(response, 1)
+	suite.Assert().Equal(models.Measurement{Value: 4.2, Unit: "km/h"}, response[0].Properties.Speed)
+}
+
+// patch sends a patch of contentType to PatchSpaceAPI
+func (suite *SpaceAPIHandlerTestSuite) patch(contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PATCH", "/api/space", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	suite.handler.PatchSpaceAPI(w, req)
+	return w
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_MergePatch() {
+	w := suite.patch("application/merge-patch+json; charset=utf-8", `{"contact": {"phone": "+49 30 1234"}}`)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().NotEmpty(w.Header().Get("ETag"))
+
+	var response models.SpaceAPI
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &response))
+	suite.Assert().Equal("+49 30 1234", response.Contact.Phone)
+	suite.Assert().Equal("Test Space", response.Space)
+	suite.Assert().Equal("+49 30 1234", suite.handler.store.Snapshot().Contact.Phone)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_JSONPatch() {
+	w := suite.patch(services.JSONPatchType, `[{"op": "replace", "path": "/space", "value": "Renamed Space"}]`)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("Renamed Space", suite.handler.store.Snapshot().Space)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_UnsupportedType() {
+	w := suite.patch("application/json", `{"space": "Renamed Space"}`)
+
+	suite.Assert().Equal(http.StatusUnsupportedMediaType, w.Code)
+	suite.Assert().Equal("application/merge-patch+json, application/json-patch+json", w.Header().Get("Accept-Patch"))
+	suite.Assert().Equal("Test Space", suite.handler.store.Snapshot().Space)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_InvalidPatch() {
+	w := suite.patch(services.JSONPatchType, `[{"op": "remove", "path": "/contact/phone"}]`)
+
+	suite.Assert().Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_TestFailed() {
+	w := suite.patch(services.JSONPatchType, `[
+		{"op": "test", "path": "/space", "value": "Other Space"},
+		{"op": "replace", "path": "/space", "value": "Renamed Space"}
+	]`)
+
+	suite.Assert().Equal(http.StatusConflict, w.Code)
+	suite.Assert().Equal("Test Space", suite.handler.store.Snapshot().Space)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_ProtectedField() {
+	w := suite.patch(services.MergePatchType, `{"state": {"open": false}}`)
+
+	suite.Assert().Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Assert().Contains(w.Body.String(), "/state/open")
+	suite.Assert().True(*suite.handler.store.Snapshot().State.Open)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_SchemaViolation() {
+	w := suite.patch(services.MergePatchType, `{"location": {"lat": 123}}`)
+
+	suite.Assert().Equal(http.StatusUnprocessableEntity, w.Code)
+	suite.Assert().Equal("application/json", w.Header().Get("Content-Type"))
+	suite.Assert().Contains(w.Body.String(), "location.lat")
+	suite.Assert().Equal(40.7128, suite.handler.store.Snapshot().Location.Lat)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_StaleIfMatch() {
+	req := httptest.NewRequest("PATCH", "/api/space", bytes.NewReader([]byte(`{"space": "Renamed Space"}`)))
+	req.Header.Set("Content-Type", services.MergePatchType)
+	req.Header.Set("If-Match", `"0123456789abcdef"`)
+	w := httptest.NewRecorder()
+
+	suite.handler.PatchSpaceAPI(w, req)
+
+	suite.Assert().Equal(http.StatusPreconditionFailed, w.Code)
+	suite.Assert().Equal("Test Space", suite.handler.store.Snapshot().Space)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_NullAndWrongTypes() {
+	for _, body := range []string{
+		`{"space": null}`,
+		`{"contact": {"email": 42}}`,
+		`{"location": {"lat": "52.5"}}`,
+	} {
+		w := suite.patch(services.MergePatchType, body)
+
+		suite.Assert().Equal(http.StatusUnprocessableEntity, w.Code, body)
+		suite.Assert().Contains(w.Body.String(), "violations", body)
+	}
+	snapshot := suite.handler.store.Snapshot()
+	suite.Assert().Equal("Test Space", snapshot.Space)
+	suite.Assert().Equal(40.7128, snapshot.Location.Lat)
+}
+
+func (suite *SpaceAPIHandlerTestSuite) TestPatchSpaceAPI_PersistedAndKeptByWatcher() {
+	path := filepath.Join(suite.T().TempDir(), "spaceapi.json")
+	suite.Require().NoError(services.SaveSpaceAPIData(path, testutil.NewMockSpaceAPI()))
+	persister := services.NewPersister(path, 10*time.Millisecond)
+	store := services.NewStore(testutil.NewMockSpaceAPI(), persister)
+	suite.handler = NewSpaceAPIHandler(store, nil)
+
+	// Wired as in main
+	watcher := services.NewFileWatcher(path, 5*time.Millisecond, func() {
+		if _, err := services.ReloadSpaceAPIData(store, path, nil); err != nil {
+			suite.T().Errorf("reload: %v", err)
+		}
+	})
+	watcher.IgnoreWritesOf(persister)
+	watcher.Start()
+	defer watcher.Stop()
+
+	saved := func() *models.SpaceAPI {
+		spaceAPI, err := services.LoadSpaceAPIData(path)
+		if err != nil {
+			return &models.SpaceAPI{}
+		}
+		return spaceAPI
+	}
+
+	w := suite.patch(services.MergePatchType, `{"contact": {"twitter": "@first"}}`)
+	suite.Require().Equal(http.StatusOK, w.Code)
+	suite.Require().Eventually(func() bool {
+		return saved().Contact.Twitter == "@first"
+	}, time.Second, 5*time.Millisecond)
+
+	// The second patch lands while the watcher may still see the first write
+	w = suite.patch(services.MergePatchType, `{"contact": {"twitter": "@second"}}`)
+	suite.Require().Equal(http.StatusOK, w.Code)
+	suite.Require().Eventually(func() bool {
+		return saved().Contact.Twitter == "@second"
+	}, time.Second, 5*time.Millisecond)
+
+	// Give the watcher a few polls to observe the write
+	time.Sleep(50 * time.Millisecond)
+	suite.Assert().Equal("@second", store.Snapshot().Contact.Twitter)
+	suite.Require().NoError(persister.Flush())
+	suite.Assert().Equal("@second", saved().Contact.Twitter)
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+type StatsHandler struct {
+	store  *services.Store
+	states *services.StateHistory
+}
+
+// NewStatsHandler creates a handler computing statistics from the state
+// history, in the timezone of the document in store
+func NewStatsHandler(store *services.Store, states *services.StateHistory) *StatsHandler {
+	return &StatsHandler{
+		store:  store,
+		states: states,
+	}
+}
+
+// OpeningStats reports when the space is usually open between from and to.
+// JSON is returned unless format=csv is given or text/csv accepted; the CSV
+// holds the table selected by table: heatmap, weeks, months or summary.
+func (h *StatsHandler) OpeningStats(w http.ResponseWriter, r *http.Request) {
+	from, to, err := parseTimeRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if now := time.Now(); to.IsZero() || to.After(now) {
+		to = now
+	}
+
+	asCSV := r.URL.Query().Get("format") == "csv" ||
+		(r.URL.Query().Get("format") == "" && strings.Contains(r.Header.Get("Accept"), "text/csv"))
+	table := r.URL.Query().Get("table")
+	if table == "" {
+		table = "heatmap"
+	}
+	if asCSV && table != "heatmap" && table != "weeks" && table != "months" && table != "summary" {
+		http.Error(w, "Unknown table, expected one of: heatmap, weeks, months, summary", http.StatusBadRequest)
+		return
+	}
+
+	stats := services.ComputeOpeningStats(h.states.Between(time.Time{}, to), from, to, h.location())
+
+	w.Header().Add("Vary", "Accept")
+	if !asCSV {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			log.Printf("Error encoding opening stats: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="opening-`+table+`.csv"`)
+	if err := csv.NewWriter(w).WriteAll(statsTable(stats, table)); err != nil {
+		log.Printf("Error writing opening stats: %v", err)
+	}
+}
+
+// location returns the timezone of the space
+func (h *StatsHandler) location() *time.Location {
+	return spaceLocation(h.store.Snapshot())
+}
+
+// spaceLocation returns the timezone of the space, or UTC if it is not set
+// or unknown
+func spaceLocation(spaceAPI *models.SpaceAPI) *time.Location {
+	if spaceAPI.Location == nil || spaceAPI.Location.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(spaceAPI.Location.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// statsTable returns the rows of one table of stats, with a header row
+func statsTable(stats services.OpeningStats, table string) [][]string {
+	hours := func(h float64) string {
+		return strconv.FormatFloat(h, 'f', -1, 64)
+	}
+
+	var rows [][]string
+	switch table {
+	case "heatmap":
+		header := []string{"weekday"}
+		for hour := 0; hour < 24; hour++ {
+			header = append(header, strconv.Itoa(hour))
+		}
+		rows = append(rows, header)
+		for _, day := range stats.Heatmap {
+			row := []string{day.Weekday}
+			for _, h := range day.Hours {
+				row = append(row, hours(h))
+			}
+			rows = append(rows, row)
+		}
+	case "weeks", "months":
+		periods := stats.Weeks
+		if table == "months" {
+			periods = stats.Months
+		}
+		rows = append(rows, []string{strings.TrimSuffix(table, "s"), "hours"})
+		for _, period := range periods {
+			rows = append(rows, []string{period.Period, hours(period.Hours)})
+		}
+	case "summary":
+		rows = [][]string{
+			{"metric", "value"},
+			{"timezone", stats.Timezone},
+			{"from", time.Unix(stats.From, 0).UTC().Format(time.RFC3339)},
+			{"to", time.Unix(stats.To, 0).UTC().Format(time.RFC3339)},
+			{"open_hours", hours(stats.OpenHours)},
+			{"sessions", strconv.Itoa(stats.Sessions)},
+			{"average_session_hours", hours(stats.AverageSessionHours)},
+			{"longest_streak_days", strconv.Itoa(stats.LongestStreak.Days)},
+			{"longest_streak_from", stats.LongestStreak.From},
+			{"longest_streak_to", stats.LongestStreak.To},
+		}
+	}
+	return rows
+}
@@ -0,0 +1,136 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type StatsHandlerTestSuite struct {
+	suite.Suite
+	handler *StatsHandler
+}
+
+func (suite *StatsHandlerTestSuite) SetupTest() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.Location.Timezone = "Europe/Berlin"
+
+	history, err := services.NewStateHistory("")
+	suite.Require().NoError(err)
+	// Monday 2025-01-06 from 18:00 to 20:00 in Berlin
+	suite.Require().NoError(history.Record(services.StateTransition{Timestamp: 1736182800, Open: true}))
+	suite.Require().NoError(history.Record(services.StateTransition{Timestamp: 1736190000, Open: false}))
+
+	suite.handler = NewStatsHandler(services.NewStore(spaceAPI, nil), history)
+}
+
+func TestStatsHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(StatsHandlerTestSuite))
+}
+
+func (suite *StatsHandlerTestSuite) get(query string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/api/space/stats/opening"+query, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	suite.handler.OpeningStats(w, req)
+	return w
+}
+
+func (suite *StatsHandlerTestSuite) TestJSON() {
+	w := suite.get("?from=2025-01-01&to=2025-02-01", nil)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("application/json", w.Header().Get("Content-Type"))
+
+	var stats services.OpeningStats
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &stats))
+	suite.Assert().Equal("Europe/Berlin", stats.Timezone)
+	suite.Assert().Equal(float64(2), stats.OpenHours)
+	suite.Assert().Equal(float64(1), stats.Heatmap[0].Hours[18])
+	suite.Assert().Equal(float64(1), stats.Heatmap[0].Hours[19])
+}
+
+func (suite *StatsHandlerTestSuite) TestDefaultsToWholeHistory() {
+	var stats services.OpeningStats
+	suite.Require().NoError(json.Unmarshal(suite.get("", nil).Body.Bytes(), &stats))
+
+	suite.Assert().Equal(int64(1736182800), stats.From)
+	suite.Assert().InDelta(time.Now().Unix(), stats.To, 5)
+	suite.Assert().Equal(float64(2), stats.OpenHours)
+}
+
+func (suite *StatsHandlerTestSuite) csv(w *httptest.ResponseRecorder) [][]string {
+	suite.Require().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	suite.Require().NoError(err)
+	return rows
+}
+
+func (suite *StatsHandlerTestSuite) TestCSVHeatmap() {
+	rows := suite.csv(suite.get("?from=2025-01-01&to=2025-02-01&format=csv", nil))
+
+	suite.Require().Len(rows, 8)
+	suite.Assert().Len(rows[0], 25)
+	suite.Assert().Equal([]string{"weekday", "0", "1"}, rows[0][:3])
+	suite.Assert().Equal("Monday", rows[1][0])
+	suite.Assert().Equal("1", rows[1][19])
+	suite.Assert().Equal("0", rows[2][19])
+}
+
+func (suite *StatsHandlerTestSuite) TestCSVTables() {
+	rows := suite.csv(suite.get("?from=2025-01-01&to=2025-02-01&table=weeks", map[string]string{"Accept": "text/csv"}))
+	suite.Assert().Equal([]string{"week", "hours"}, rows[0])
+	suite.Assert().Equal([]string{"2025-01-06", "2"}, rows[2])
+
+	rows = suite.csv(suite.get("?from=2025-01-01&to=2025-01-31&table=months&format=csv", nil))
+	suite.Assert().Equal([][]string{{"month", "hours"}, {"2025-01", "2"}}, rows)
+
+	rows = suite.csv(suite.get("?from=2025-01-01&to=2025-02-01&table=summary&format=csv", nil))
+	suite.Assert().Contains(rows, []string{"average_session_hours", "2"})
+	suite.Assert().Contains(rows, []string{"longest_streak_from", "2025-01-06"})
+}
+
+func (suite *StatsHandlerTestSuite) TestInvalidQuery() {
+	suite.Assert().Equal(http.StatusBadRequest, suite.get("?format=csv&table=days", nil).Code)
+	suite.Assert().Equal(http.StatusBadRequest, suite.get("?from=someday", nil).Code)
+}
+
+func (suite *StatsHandlerTestSuite) TestUnknownTimezone() {
+	_, err := suite.handler.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.Location.Timezone = "Your timezone here"
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	var stats services.OpeningStats
+	suite.Require().NoError(json.Unmarshal(suite.get("", nil).Body.Bytes(), &stats))
+	suite.Assert().Equal("UTC", stats.Timezone)
+	suite.Assert().Equal(float64(1), stats.Heatmap[0].Hours[17])
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how often an idle stream sends a comment to
+// keep proxies from closing the connection
+const DefaultHeartbeatInterval = 15 * time.Second
+
+// snapshotEvent is the SSE event carrying the whole document. It is sent
+// when a client connects and when it resumes after changes it can no longer
+// be given.
+const snapshotEvent = "snapshot"
+
+// StreamSpaceAPI streams changes of the document as Server-Sent Events. Each
+// change is sent as an event named after its kind with the bus ID as event
+// ID, so reconnecting clients resume via Last-Event-ID.
+func (h *SpaceAPIHandler) StreamSpaceAPI(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || h.bus == nil {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastID, resume := lastEventID(r)
+	sub := h.bus.Subscribe(lastID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if resume && sub.Complete {
+		for _, change := range sub.Missed {
+			if err := writeSSE(w, change.ID, change.Kind, change.Data); err != nil {
+				return
+			}
+		}
+	} else if err := writeSSE(w, sub.LastID, snapshotEvent, h.store.Snapshot()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case change, ok := <-sub.Changes:
+			if !ok {
+				// Dropped for falling behind, the client reconnects and
+				// resumes from the last event it received
+				return
+			}
+			if err := writeSSE(w, change.ID, change.Kind, change.Data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// lastEventID returns the ID sent by a reconnecting client, if any
+func lastEventID(r *http.Request) (uint64, bool) {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return id, true
+}
+
+// writeSSE writes one event. The JSON encoding of data never contains
+// newlines, so it fits in a single data field. Events that cannot be encoded
+// are skipped, as a resuming client would otherwise fail on them again.
+func writeSSE(w io.Writer, id uint64, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", event, err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, payload)
+	return err
+}
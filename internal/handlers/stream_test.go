@@ -0,0 +1,203 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type StreamTestSuite struct {
+	suite.Suite
+	bus     *services.Bus
+	handler *SpaceAPIHandler
+	server  *httptest.Server
+}
+
+func (suite *StreamTestSuite) SetupTest() {
+	suite.bus = services.NewBus(services.DefaultBusHistory)
+	suite.handler = NewSpaceAPIHandler(services.NewStore(testutil.NewMockSpaceAPI(), nil), suite.bus)
+	suite.server = httptest.NewServer(http.HandlerFunc(suite.handler.StreamSpaceAPI))
+}
+
+func (suite *StreamTestSuite) TearDownTest() {
+	suite.server.CloseClientConnections()
+	suite.server.Close()
+}
+
+func TestStreamTestSuite(t *testing.T) {
+	suite.Run(t, new(StreamTestSuite))
+}
+
+// sseEvent is one parsed Server-Sent Event
+type sseEvent struct {
+	id      string
+	event   string
+	data    string
+	comment string
+}
+
+// connect opens the stream and returns a function reading the next event
+func (suite *StreamTestSuite) connect(lastEventID string) func() sseEvent {
+	ctx, cancel := context.WithCancel(context.Background())
+	suite.T().Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", suite.server.URL, nil)
+	suite.Require().NoError(err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { resp.Body.Close() })
+
+	suite.Require().Equal(http.StatusOK, resp.StatusCode)
+	suite.Require().Equal("text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	return func() sseEvent {
+		var event sseEvent
+		for {
+			line, err := reader.ReadString('\n')
+			suite.Require().NoError(err)
+			line = strings.TrimSuffix(line, "\n")
+			if line == "" {
+				return event
+			}
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "id":
+				event.id = value
+			case "event":
+				event.event = value
+			case "data":
+				event.data = value
+			case "":
+				event.comment = value
+			}
+		}
+	}
+}
+
+func (suite *StreamTestSuite) postState(body string) {
+	req := httptest.NewRequest("POST", "/api/space/state", bytes.NewBufferString(body))
+	w := httptest.NewRecorder()
+	suite.handler.UpdateState(w, req)
+	suite.Require().Equal(http.StatusOK, w.Code)
+}
+
+func (suite *StreamTestSuite) TestStream_SnapshotThenChanges() {
+	next := suite.connect("")
+
+	snapshot := next()
+	suite.Assert().Equal("snapshot", snapshot.event)
+	suite.Assert().Equal("0", snapshot.id)
+	var spaceAPI models.SpaceAPI
+	suite.Require().NoError(json.Unmarshal([]byte(snapshot.data), &spaceAPI))
+	suite.Assert().Equal("Test Space", spaceAPI.Space)
+
+	suite.postState(`{"open": false, "message": "Closed"}`)
+
+	change := next()
+	suite.Assert().Equal("state", change.event)
+	suite.Assert().Equal("1", change.id)
+	var state models.State
+	suite.Require().NoError(json.Unmarshal([]byte(change.data), &state))
+	suite.Assert().False(*state.Open)
+	suite.Assert().Equal("Closed", state.Message)
+}
+
+func (suite *StreamTestSuite) TestStream_EventTypesPerChangeKind() {
+	next := suite.connect("")
+	next()
+
+	req := httptest.NewRequest("POST", "/api/space/people", bytes.NewBufferString(`{"value": 7}`))
+	suite.handler.UpdatePeopleCount(httptest.NewRecorder(), req)
+	req = httptest.NewRequest("POST", "/api/space/event", bytes.NewBufferString(`{"name": "Alice", "type": "check-in"}`))
+	suite.handler.AddEvent(httptest.NewRecorder(), req)
+	req = httptest.NewRequest("POST", "/api/space/sensors/temperature", bytes.NewBufferString(`{"value": 21.5, "unit": "°C", "location": "Hackspace"}`))
+	w := httptest.NewRecorder()
+	suite.handler.UpdateSensor(w, mux.SetURLVars(req, map[string]string{"type": "temperature"}))
+	suite.Require().Equal(http.StatusOK, w.Code)
+
+	suite.Assert().Equal("people", next().event)
+	suite.Assert().Equal("event", next().event)
+
+	sensor := next()
+	suite.Assert().Equal("sensor", sensor.event)
+	var data struct {
+		Type    string                   `json:"type"`
+		Reading models.TemperatureSensor `json:"reading"`
+	}
+	suite.Require().NoError(json.Unmarshal([]byte(sensor.data), &data))
+	suite.Assert().Equal("temperature", data.Type)
+	suite.Assert().Equal(21.5, data.Reading.Value)
+}
+
+func (suite *StreamTestSuite) TestStream_ResumesFromLastEventID() {
+	suite.postState(`{"message": "one"}`)
+	suite.postState(`{"message": "two"}`)
+	suite.postState(`{"message": "three"}`)
+
+	next := suite.connect("1")
+
+	for _, expected := range []struct{ id, message string }{{"2", "two"}, {"3", "three"}} {
+		event := next()
+		suite.Assert().Equal("state", event.event)
+		suite.Assert().Equal(expected.id, event.id)
+		suite.Assert().Contains(event.data, expected.message)
+	}
+}
+
+func (suite *StreamTestSuite) TestStream_SnapshotWhenResumeIsImpossible() {
+	suite.postState(`{"message": "one"}`)
+
+	// An ID from before a restart
+	next := suite.connect("99")
+
+	event := next()
+	suite.Assert().Equal("snapshot", event.event)
+	suite.Assert().Equal("1", event.id)
+}
+
+func (suite *StreamTestSuite) TestStream_Heartbeat() {
+	suite.handler.heartbeat = 10 * time.Millisecond
+	next := suite.connect("")
+	next()
+
+	suite.Assert().Equal("heartbeat", next().comment)
+}
+
+func (suite *StreamTestSuite) TestStream_WithoutBus() {
+	handler := NewSpaceAPIHandler(services.NewStore(testutil.NewMockSpaceAPI(), nil), nil)
+	w := httptest.NewRecorder()
+	handler.StreamSpaceAPI(w, httptest.NewRequest("GET", "/api/space/stream", nil))
+	suite.Assert().Equal(http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/stretchr/testify/suite"
+)
+
+type WebhookHandlerTestSuite struct {
+	suite.Suite
+	dispatcher *services.WebhookDispatcher
+	handler    *WebhookHandler
+}
+
+func (suite *WebhookHandlerTestSuite) SetupTest() {
+	var err error
+	suite.dispatcher, err = services.NewWebhookDispatcher(services.WebhookConfig{
+		URLs:   []string{"https://example.com/hook"},
+		Secret: "secret",
+	})
+	suite.Require().NoError(err)
+	suite.handler = NewWebhookHandler(suite.dispatcher)
+}
+
+func TestWebhookHandlerTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookHandlerTestSuite))
+}
+
+func (suite *WebhookHandlerTestSuite) TestListDeliveries() {
+	suite.Require().NoError(suite.dispatcher.Notify(services.WebhookPayload{Space: "Test Space", Open: true}))
+
+	w := httptest.NewRecorder()
+	suite.handler.ListDeliveries(w, httptest.NewRequest("GET", "/api/space/webhooks/deliveries", nil))
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	var deliveries []services.Delivery
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &deliveries))
+	suite.Require().Len(deliveries, 1)
+	suite.Assert().Equal("https://example.com/hook", deliveries[0].URL)
+	suite.Assert().Equal(services.DeliveryPending, deliveries[0].Status)
+	suite.Assert().Equal(services.WebhookEventOpen, deliveries[0].Event)
+}
@@ -0,0 +1,76 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+type HTTPMetricsTestSuite struct {
+	suite.Suite
+	registry *Registry
+	metrics  *HTTPMetrics
+	router   *mux.Router
+}
+
+func (suite *HTTPMetricsTestSuite) SetupTest() {
+	suite.registry = NewRegistry()
+	suite.metrics = NewHTTPMetrics(suite.registry)
+	suite.router = mux.NewRouter()
+	suite.router.Use(suite.metrics.Middleware)
+	suite.router.HandleFunc("/api/space", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	}).Methods("GET")
+
+	updates := suite.router.PathPrefix("/api/space").Subrouter()
+	updates.HandleFunc("/sensors/{type}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Unknown sensor type", http.StatusNotFound)
+	}).Methods("POST")
+	updates.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		suite.Assert().True(ok, "recorder must keep the writer flushable")
+	}).Methods("GET")
+}
+
+func TestHTTPMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(HTTPMetricsTestSuite))
+}
+
+func (suite *HTTPMetricsTestSuite) serve(method, path string) {
+	suite.router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, path, nil))
+}
+
+func (suite *HTTPMetricsTestSuite) TestCountsPerRoute() {
+	suite.serve("GET", "/api/space")
+	suite.serve("GET", "/api/space")
+	suite.serve("POST", "/api/space/sensors/temperature")
+	suite.serve("POST", "/api/space/sensors/humidity")
+	suite.serve("GET", "/api/space/stream")
+
+	suite.Assert().Equal(float64(2), suite.metrics.requests.Value("/api/space", "GET", "200"))
+	suite.Assert().Equal(float64(2), suite.metrics.requests.Value("/api/space/sensors/{type}", "POST", "404"))
+
+	var buf bytes.Buffer
+	suite.Require().NoError(suite.registry.Write(&buf))
+	suite.Assert().Contains(buf.String(), `spaceapi_http_request_duration_seconds_count{route="/api/space",method="GET"} 2`)
+	suite.Assert().Contains(buf.String(), `spaceapi_http_requests_total{route="/api/space/stream",method="GET",code="200"} 1`)
+}
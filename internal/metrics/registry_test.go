@@ -0,0 +1,114 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RegistryTestSuite struct {
+	suite.Suite
+	registry *Registry
+}
+
+func (suite *RegistryTestSuite) SetupTest() {
+	suite.registry = NewRegistry()
+}
+
+func TestRegistryTestSuite(t *testing.T) {
+	suite.Run(t, new(RegistryTestSuite))
+}
+
+func (suite *RegistryTestSuite) output() string {
+	var buf bytes.Buffer
+	suite.Require().NoError(suite.registry.Write(&buf))
+	return buf.String()
+}
+
+func (suite *RegistryTestSuite) TestCounterVec() {
+	counter := suite.registry.NewCounterVec("test_total", "A test counter.", "kind")
+	counter.Inc("b")
+	counter.Add(2, "a")
+	counter.Inc("b")
+
+	suite.Assert().Equal(float64(2), counter.Value("b"))
+	suite.Assert().Equal(`# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{kind="a"} 2
+test_total{kind="b"} 2
+`, suite.output())
+}
+
+func (suite *RegistryTestSuite) TestHistogramVec() {
+	histogram := suite.registry.NewHistogramVec("test_seconds", "A test histogram.", []float64{0.1, 1}, "route")
+	histogram.Observe(0.05, "/")
+	histogram.Observe(0.5, "/")
+	histogram.Observe(5, "/")
+
+	suite.Assert().Equal(`# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/",le="0.1"} 1
+test_seconds_bucket{route="/",le="1"} 2
+test_seconds_bucket{route="/",le="+Inf"} 3
+test_seconds_sum{route="/"} 5.55
+test_seconds_count{route="/"} 3
+`, suite.output())
+}
+
+func (suite *RegistryTestSuite) TestGaugeFunc() {
+	suite.registry.NewGaugeFunc("test_value", "A test gauge.", func() []Sample {
+		return []Sample{
+			{LabelValues: []string{`quote " and \ backslash`}, Value: 1.5},
+			{LabelValues: []string{"line\nbreak"}, Value: -3},
+			// Duplicates are dropped
+			{LabelValues: []string{"line\nbreak"}, Value: 7},
+		}
+	}, "name")
+	suite.registry.NewGaugeFunc("test_empty", "Not written without samples.", func() []Sample { return nil })
+	suite.registry.NewCounterFunc("test_func_total", "A counter without labels.", func() []Sample {
+		return []Sample{{Value: 42}}
+	})
+
+	suite.Assert().Equal(`# HELP test_value A test gauge.
+# TYPE test_value gauge
+test_value{name="quote \" and \\ backslash"} 1.5
+test_value{name="line\nbreak"} -3
+# HELP test_func_total A counter without labels.
+# TYPE test_func_total counter
+test_func_total 42
+`, suite.output())
+}
+
+func (suite *RegistryTestSuite) TestHandler() {
+	suite.registry.NewCounterVec("test_total", "A test counter.").Inc()
+
+	w := httptest.NewRecorder()
+	suite.registry.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	suite.Assert().Contains(w.Body.String(), "test_total 1\n")
+}
+
+func (suite *RegistryTestSuite) TestWrongLabelCountPanics() {
+	counter := suite.registry.NewCounterVec("test_total", "A test counter.", "kind")
+	suite.Assert().Panics(func() { counter.Inc() })
+}
@@ -0,0 +1,162 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"github.com/q30-space/spaceapi-endpoint/internal/middleware"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+)
+
+// RegisterSpaceAPI registers gauges reporting the state and sensor readings
+// of the document in store
+func RegisterSpaceAPI(registry *Registry, store *services.Store) {
+	registry.NewGaugeFunc("spaceapi_open", "Whether the space is open (1) or closed (0).", func() []Sample {
+		state := store.Snapshot().State
+		if state == nil || state.Open == nil {
+			return nil
+		}
+		return []Sample{{Value: boolValue(*state.Open)}}
+	})
+	registry.NewGaugeFunc("spaceapi_state_lastchange_seconds", "Unix time of the last change of the open state.", func() []Sample {
+		state := store.Snapshot().State
+		if state == nil || state.Lastchange == 0 {
+			return nil
+		}
+		return []Sample{{Value: float64(state.Lastchange)}}
+	})
+	registry.NewGaugeFunc("spaceapi_sensor_value", "Current sensor readings.", func() []Sample {
+		return sensorSamples(store.Snapshot().Sensors)
+	}, "type", "location", "name", "unit")
+}
+
+// CountEvents counts the events published on bus by type until stop is closed
+func CountEvents(registry *Registry, bus *services.Bus, stop <-chan struct{}) *CounterVec {
+	events := registry.NewCounterVec("spaceapi_events_total", "Events added through the API by type.", "type")
+	bus.Follow(stop, func(change services.Change) {
+		if event, ok := change.Data.(models.Event); ok && change.Kind == services.ChangeEvent {
+			events.Inc(event.Type)
+		}
+	})
+	return events
+}
+
+// RegisterAuth registers the authentication failure counters of AuthMiddleware
+func RegisterAuth(registry *Registry) {
+	registry.NewCounterFunc("spaceapi_auth_failures_total", "Failed authentication attempts.", func() []Sample {
+		return []Sample{{Value: float64(middleware.GetAuthStats().Failures)}}
+	})
+	registry.NewCounterFunc("spaceapi_auth_blocks_total", "Times an IP was blocked after repeated failed authentication.", func() []Sample {
+		return []Sample{{Value: float64(middleware.GetAuthStats().Blocks)}}
+	})
+	registry.NewCounterFunc("spaceapi_auth_rejected_total", "Requests refused because their IP was blocked.", func() []Sample {
+		return []Sample{{Value: float64(middleware.GetAuthStats().Rejected)}}
+	})
+	registry.NewGaugeFunc("spaceapi_auth_blocked_ips", "IPs currently blocked after repeated failed authentication.", func() []Sample {
+		return []Sample{{Value: float64(middleware.GetAuthStats().BlockedIPs)}}
+	})
+}
+
+// sensorSamples lists every numeric reading. Structured sensors contribute
+// one sample per measurement, typed like radiation.alpha or wind.speed.
+func sensorSamples(sensors *models.Sensors) []Sample {
+	if sensors == nil {
+		return nil
+	}
+
+	var samples []Sample
+	add := func(sensorType string, meta models.SensorMeta, unit string, value float64) {
+		samples = append(samples, Sample{
+			LabelValues: []string{sensorType, meta.Location, meta.Name, unit},
+			Value:       value,
+		})
+	}
+
+	for _, r := range sensors.Temperature {
+		add("temperature", r.SensorMeta, r.Unit, r.Value)
+	}
+	for _, r := range sensors.CarbonDioxide {
+		add("carbondioxide", r.SensorMeta, r.Unit, r.Value)
+	}
+	for _, r := range sensors.DoorLocked {
+		add("door_locked", r.SensorMeta, "", boolValue(r.Value))
+	}
+	for _, r := range sensors.Barometer {
+		add("barometer", r.SensorMeta, r.Unit, r.Value)
+	}
+	if radiation := sensors.Radiation; radiation != nil {
+		kinds := []struct {
+			name     string
+			readings []models.RadiationSensor
+		}{
+			{"radiation.alpha", radiation.Alpha},
+			{"radiation.beta", radiation.Beta},
+			{"radiation.gamma", radiation.Gamma},
+			{"radiation.beta_gamma", radiation.BetaGamma},
+		}
+		for _, kind := range kinds {
+			for _, r := range kind.readings {
+				add(kind.name, r.SensorMeta, r.Unit, r.Value)
+			}
+		}
+	}
+	for _, r := range sensors.Humidity {
+		add("humidity", r.SensorMeta, r.Unit, r.Value)
+	}
+	for _, r := range sensors.BeverageSupply {
+		add("beverage_supply", r.SensorMeta, r.Unit, float64(r.Value))
+	}
+	for _, r := range sensors.PowerConsumption {
+		add("power_consumption", r.SensorMeta, r.Unit, r.Value)
+	}
+	for _, r := range sensors.PowerGeneration {
+		add("power_generation", r.SensorMeta, r.Unit, r.Value)
+	}
+	for _, r := range sensors.Wind {
+		add("wind.speed", r.SensorMeta, r.Properties.Speed.Unit, r.Properties.Speed.Value)
+		add("wind.gust", r.SensorMeta, r.Properties.Gust.Unit, r.Properties.Gust.Value)
+		add("wind.direction", r.SensorMeta, r.Properties.Direction.Unit, r.Properties.Direction.Value)
+		add("wind.elevation", r.SensorMeta, r.Properties.Elevation.Unit, r.Properties.Elevation.Value)
+	}
+	for _, r := range sensors.NetworkConnections {
+		add("network_connections", r.SensorMeta, "", float64(r.Value))
+	}
+	for _, r := range sensors.AccountBalance {
+		add("account_balance", r.SensorMeta, r.Unit, r.Value)
+	}
+	for _, r := range sensors.TotalMemberCount {
+		add("total_member_count", r.SensorMeta, "", float64(r.Value))
+	}
+	for _, r := range sensors.PeopleNowPresent {
+		add("people_now_present", r.SensorMeta, "", float64(r.Value))
+	}
+	for _, r := range sensors.NetworkTraffic {
+		if bps := r.Properties.BitsPerSecond; bps != nil {
+			add("network_traffic.bits_per_second", r.SensorMeta, "bit/s", bps.Value)
+		}
+		if pps := r.Properties.PacketsPerSecond; pps != nil {
+			add("network_traffic.packets_per_second", r.SensorMeta, "packet/s", pps.Value)
+		}
+	}
+	return samples
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/services"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type SpaceAPIMetricsTestSuite struct {
+	suite.Suite
+	registry *Registry
+	store    *services.Store
+}
+
+func (suite *SpaceAPIMetricsTestSuite) SetupTest() {
+	suite.registry = NewRegistry()
+	suite.store = services.NewStore(testutil.NewMockSpaceAPI(), nil)
+}
+
+func TestSpaceAPIMetricsTestSuite(t *testing.T) {
+	suite.Run(t, new(SpaceAPIMetricsTestSuite))
+}
+
+func (suite *SpaceAPIMetricsTestSuite) output() string {
+	var buf bytes.Buffer
+	suite.Require().NoError(suite.registry.Write(&buf))
+	return buf.String()
+}
+
+func (suite *SpaceAPIMetricsTestSuite) TestState() {
+	RegisterSpaceAPI(suite.registry, suite.store)
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Open = models.BoolPtr(true)
+		spaceAPI.State.Lastchange = 1700000000
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	output := suite.output()
+	suite.Assert().Contains(output, "# TYPE spaceapi_open gauge\nspaceapi_open 1\n")
+	suite.Assert().Contains(output, "spaceapi_state_lastchange_seconds 1.7e+09\n")
+}
+
+func (suite *SpaceAPIMetricsTestSuite) TestSensors() {
+	RegisterSpaceAPI(suite.registry, suite.store)
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.Sensors.Temperature = []models.TemperatureSensor{{Value: 21.5, Unit: "°C", SensorMeta: models.SensorMeta{Location: "Lab", Name: "Ceiling"}}}
+		spaceAPI.Sensors.DoorLocked = []models.DoorLockedSensor{{Value: true, SensorMeta: models.SensorMeta{Location: "Front door"}}}
+		spaceAPI.Sensors.Radiation = &models.RadiationSensors{Gamma: []models.RadiationSensor{{Value: 12, Unit: "cpm"}}}
+		spaceAPI.Sensors.Wind = []models.WindSensor{{
+			Properties: models.WindProperties{
+				Speed:     models.Measurement{Value: 4.2, Unit: "km/h"},
+				Gust:      models.Measurement{Value: 9.1, Unit: "km/h"},
+				Direction: models.Measurement{Value: 270, Unit: "°"},
+				Elevation: models.Measurement{Value: 42, Unit: "m"},
+			},
+			SensorMeta: models.SensorMeta{Location: "Roof"},
+		}}
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	output := suite.output()
+	suite.Assert().Contains(output, `spaceapi_sensor_value{type="temperature",location="Lab",name="Ceiling",unit="°C"} 21.5`)
+	suite.Assert().Contains(output, `spaceapi_sensor_value{type="door_locked",location="Front door",name="",unit=""} 1`)
+	suite.Assert().Contains(output, `spaceapi_sensor_value{type="radiation.gamma",location="",name="",unit="cpm"} 12`)
+	suite.Assert().Contains(output, `spaceapi_sensor_value{type="wind.direction",location="Roof",name="",unit="°"} 270`)
+	suite.Assert().Contains(output, `spaceapi_sensor_value{type="people_now_present",location="Main Space",name="People Counter",unit=""} 3`)
+}
+
+func (suite *SpaceAPIMetricsTestSuite) TestUnknownStateIsOmitted() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.State.Open = nil
+	RegisterSpaceAPI(suite.registry, services.NewStore(spaceAPI, nil))
+
+	suite.Assert().NotContains(suite.output(), "spaceapi_open")
+}
+
+func (suite *SpaceAPIMetricsTestSuite) TestCountEvents() {
+	bus := services.NewBus(services.DefaultBusHistory)
+	stop := make(chan struct{})
+	defer close(stop)
+	events := CountEvents(suite.registry, bus, stop)
+
+	bus.Publish(services.ChangeEvent, models.Event{Name: "Alice", Type: "check-in"}, nil)
+	bus.Publish(services.ChangeEvent, models.Event{Name: "Bob", Type: "check-in"}, nil)
+	bus.Publish(services.ChangeState, models.State{}, models.State{})
+	bus.Publish(services.ChangeEvent, models.Event{Name: "Alice", Type: "check-out"}, nil)
+
+	suite.Require().Eventually(func() bool {
+		return events.Value("check-in") == 2 && events.Value("check-out") == 1
+	}, time.Second, time.Millisecond)
+	suite.Assert().Contains(suite.output(), `spaceapi_events_total{type="check-in"} 2`)
+}
+
+func (suite *SpaceAPIMetricsTestSuite) TestRegisterAuth() {
+	RegisterAuth(suite.registry)
+
+	output := suite.output()
+	suite.Assert().Contains(output, "# TYPE spaceapi_auth_failures_total counter\n")
+	suite.Assert().Contains(output, "# TYPE spaceapi_auth_blocked_ips gauge\nspaceapi_auth_blocked_ips 0\n")
+}
@@ -0,0 +1,178 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// Forwarding headers ClientIP can read
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+)
+
+// Trusted proxies used by ClientIP and the header they set
+var trustedProxies []netip.Prefix
+var forwardedHeader = HeaderXForwardedFor
+var trustedProxiesMutex sync.RWMutex
+
+// ParseTrustedProxies parses a list of CIDRs or single addresses separated
+// by commas or spaces
+func ParseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// SetTrustedProxies sets the proxies whose forwarding headers ClientIP believes
+func SetTrustedProxies(prefixes []netip.Prefix) {
+	trustedProxiesMutex.Lock()
+	defer trustedProxiesMutex.Unlock()
+	trustedProxies = prefixes
+}
+
+// ParseForwardedHeader checks the name of the header trusted proxies record
+// the client in. An empty name selects X-Forwarded-For.
+func ParseForwardedHeader(name string) (string, error) {
+	switch {
+	case name == "" || strings.EqualFold(name, HeaderXForwardedFor):
+		return HeaderXForwardedFor, nil
+	case strings.EqualFold(name, HeaderForwarded):
+		return HeaderForwarded, nil
+	}
+	return "", fmt.Errorf("invalid forwarded header %q, expected %s or %s", name, HeaderXForwardedFor, HeaderForwarded)
+}
+
+// SetForwardedHeader sets the header, as returned by ParseForwardedHeader,
+// that ClientIP reads. Only this header is believed: proxies pass others
+// through as sent by the client.
+func SetForwardedHeader(name string) {
+	trustedProxiesMutex.Lock()
+	defer trustedProxiesMutex.Unlock()
+	forwardedHeader = name
+}
+
+func getForwardedHeader() string {
+	trustedProxiesMutex.RLock()
+	defer trustedProxiesMutex.RUnlock()
+	return forwardedHeader
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	trustedProxiesMutex.RLock()
+	defer trustedProxiesMutex.RUnlock()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that made the request. The
+// configured forwarding header is only believed when the request came from a
+// trusted proxy, and is read right to left, stopping at the first address
+// that is not a trusted proxy; entries further left could have been made up
+// by the client.
+func ClientIP(r *http.Request) string {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return r.RemoteAddr
+	}
+	if !isTrustedProxy(peer) {
+		return peer.String()
+	}
+
+	hops := forwardedFor(r.Header, getForwardedHeader())
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			// Obfuscated or unknown, the client cannot be identified further
+			break
+		}
+		client = addr
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the client addresses recorded by proxies in the
+// header name, nearest proxy last
+func forwardedFor(header http.Header, name string) []string {
+	var hops []string
+	if name == HeaderForwarded {
+		for _, value := range header.Values(HeaderForwarded) {
+			for _, element := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(element, ";") {
+					name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					if ok && strings.EqualFold(name, "for") {
+						hops = append(hops, strings.Trim(value, `"`))
+					}
+				}
+			}
+		}
+		return hops
+	}
+
+	for _, value := range header.Values(HeaderXForwardedFor) {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseAddr parses an address with optional port, such as 192.0.2.1,
+// 192.0.2.1:8080, 2001:db8::1 or [2001:db8::1]:8080
+func parseAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	// Zones are only meaningful on the host that received the packet
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,193 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ClientIPTestSuite struct {
+	suite.Suite
+}
+
+func (suite *ClientIPTestSuite) SetupTest() {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 2001:db8:ffff::/48 192.0.2.1")
+	suite.Require().NoError(err)
+	SetTrustedProxies(proxies)
+}
+
+func (suite *ClientIPTestSuite) TearDownTest() {
+	SetTrustedProxies(nil)
+}
+
+func TestClientIPTestSuite(t *testing.T) {
+	suite.Run(t, new(ClientIPTestSuite))
+}
+
+func (suite *ClientIPTestSuite) TestClientIP() {
+	cases := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		expected   string
+	}{
+		{"port is stripped", "203.0.113.7:54321", nil, "203.0.113.7"},
+		{"IPv6 port is stripped", "[2001:db8::7]:54321", nil, "2001:db8::7"},
+		{"IPv4-mapped IPv6 is unmapped", "[::ffff:203.0.113.7]:80", nil, "203.0.113.7"},
+		{"untrusted peer cannot forward", "203.0.113.7:1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.2:1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed entries left of the client are ignored", "10.0.0.2:1",
+			http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, 192.0.2.1", "10.1.1.1"}}, "198.51.100.1"},
+		{"only trusted proxies", "10.0.0.2:1",
+			http.Header{"X-Forwarded-For": {"10.0.0.9, 10.0.0.8"}}, "10.0.0.9"},
+		{"garbage stops the walk", "10.0.0.2:1",
+			http.Header{"X-Forwarded-For": {"198.51.100.1, not-an-ip"}}, "10.0.0.2"},
+		{"spoofed forwarded header is ignored", "10.0.0.2:1",
+			http.Header{"Forwarded": {"for=1.2.3.4"}, "X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"spoofed forwarded header without X-Forwarded-For", "10.0.0.2:1",
+			http.Header{"Forwarded": {"for=1.2.3.4"}}, "10.0.0.2"},
+	}
+
+	for _, tc := range cases {
+		suite.Assert().Equal(tc.expected, ClientIP(newRequest(tc.remoteAddr, tc.header)), tc.name)
+	}
+}
+
+func (suite *ClientIPTestSuite) TestClientIP_ForwardedHeader() {
+	SetForwardedHeader(HeaderForwarded)
+	defer SetForwardedHeader(HeaderXForwardedFor)
+
+	cases := []struct {
+		name       string
+		remoteAddr string
+		header     http.Header
+		expected   string
+	}{
+		{"forwarded header", "10.0.0.2:1",
+			http.Header{"Forwarded": {`for=1.2.3.4, for=198.51.100.1;proto=https;by=10.0.0.2`}}, "198.51.100.1"},
+		{"forwarded IPv6 with port", "[2001:db8:ffff::1]:443",
+			http.Header{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"X-Forwarded-For is ignored", "10.0.0.2:1",
+			http.Header{"Forwarded": {"for=198.51.100.1"}, "X-Forwarded-For": {"198.51.100.99"}}, "198.51.100.1"},
+		{"obfuscated forwarded identifier", "10.0.0.2:1",
+			http.Header{"Forwarded": {"for=_hidden, for=unknown"}}, "10.0.0.2"},
+	}
+
+	for _, tc := range cases {
+		suite.Assert().Equal(tc.expected, ClientIP(newRequest(tc.remoteAddr, tc.header)), tc.name)
+	}
+}
+
+// newRequest builds a request from remoteAddr carrying header
+func newRequest(remoteAddr string, header http.Header) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = remoteAddr
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	return req
+}
+
+func (suite *ClientIPTestSuite) TestParseForwardedHeader() {
+	for spec, expected := range map[string]string{
+		"":                HeaderXForwardedFor,
+		"x-forwarded-for": HeaderXForwardedFor,
+		"Forwarded":       HeaderForwarded,
+	} {
+		name, err := ParseForwardedHeader(spec)
+		suite.Require().NoError(err)
+		suite.Assert().Equal(expected, name)
+	}
+
+	_, err := ParseForwardedHeader("X-Real-IP")
+	suite.Assert().ErrorContains(err, "X-Real-IP")
+}
+
+func (suite *ClientIPTestSuite) TestParseTrustedProxies() {
+	proxies, err := ParseTrustedProxies("")
+	suite.Require().NoError(err)
+	suite.Assert().Empty(proxies)
+
+	proxies, err = ParseTrustedProxies("10.1.2.3/8,::1")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("10.0.0.0/8", proxies[0].String())
+	suite.Assert().Equal("::1/128", proxies[1].String())
+
+	_, err = ParseTrustedProxies("10.0.0.0/33")
+	suite.Assert().Error(err)
+	_, err = ParseTrustedProxies("proxy.example.com")
+	suite.Assert().ErrorContains(err, "proxy.example.com")
+}
+
+func (suite *ClientIPTestSuite) TestRateLimiterUsesClientIP() {
+	keys, err := NewKeyStore([]APIKey{{Name: "door", Hash: HashKey(doorKey), Scopes: []string{ScopeAll}}})
+	suite.Require().NoError(err)
+	SetKeyStore(keys)
+	defer SetKeyStore(nil)
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	attempt := func(forwardedFor string) int {
+		req := httptest.NewRequest("POST", "/api/space/state", nil)
+		req.RemoteAddr = "203.0.113.50:1234"
+		req.Header.Set("X-API-Key", "wrong")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Rotating the header from an untrusted address does not evade the block
+	for i := 0; i < 5; i++ {
+		suite.Assert().Equal(http.StatusUnauthorized, attempt("198.51.100."+string(rune('1'+i))))
+	}
+	suite.Assert().Equal(http.StatusTooManyRequests, attempt("198.51.100.200"))
+}
+
+func (suite *ClientIPTestSuite) TestRateLimiterIgnoresSpoofedForwarded() {
+	keys, err := NewKeyStore([]APIKey{{Name: "door", Hash: HashKey(doorKey), Scopes: []string{ScopeAll}}})
+	suite.Require().NoError(err)
+	SetKeyStore(keys)
+	defer SetKeyStore(nil)
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	attempt := func(spoofed string) int {
+		// The trusted proxy appends the real client to X-Forwarded-For and
+		// passes the client's Forwarded header through
+		req := httptest.NewRequest("POST", "/api/space/state", nil)
+		req.RemoteAddr = "10.0.0.2:1234"
+		req.Header.Set("X-API-Key", "wrong")
+		req.Header.Set("Forwarded", "for="+spoofed)
+		req.Header.Set("X-Forwarded-For", "203.0.113.51")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		suite.Assert().Equal(http.StatusUnauthorized, attempt("198.51.100."+string(rune('1'+i))))
+	}
+	suite.Assert().Equal(http.StatusTooManyRequests, attempt("198.51.100.200"))
+}
@@ -15,20 +15,204 @@
 
 package middleware
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// CORSPolicy configures Cross-Origin Resource Sharing for a group of routes
+type CORSPolicy struct {
+	// AllowedOrigins lists the origins allowed to call the routes. "*" allows
+	// any origin and "https://*.example.com" any subdomain of example.com.
+	AllowedOrigins []string
+	// AllowedMethods limits the methods preflights succeed for. A preflight
+	// announces those of them the matched route registers.
+	AllowedMethods []string
+	// AllowedHeaders are announced in preflight responses
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers scripts may read
+	ExposedHeaders []string
+	// AllowCredentials lets browsers send cookies and client certificates
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// DefaultReadCORS returns the policy for the public read routes, which any
+// origin may read
+func DefaultReadCORS() CORSPolicy {
+	return CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet},
+		AllowedHeaders: []string{"Last-Event-ID", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders: []string{"ETag"},
+		MaxAge:         time.Hour,
+	}
+}
+
+// DefaultWriteCORS returns the policy for the authenticated routes. No
+// origin is allowed until configured.
+func DefaultWriteCORS() CORSPolicy {
+	return CORSPolicy{
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", SignatureHeader, TimestampHeader, NonceHeader, "If-Match"},
+		ExposedHeaders: []string{"ETag", "Retry-After"},
+		MaxAge:         time.Hour,
+	}
+}
+
+// ParseOrigins splits a comma separated list of origins and checks each is
+// "*" or a scheme and host, optionally with a "*." wildcard subdomain
+func ParseOrigins(spec string) ([]string, error) {
+	var origins []string
+	for _, field := range strings.Split(spec, ",") {
+		origin := strings.ToLower(strings.TrimSpace(field))
+		if origin == "" {
+			continue
+		}
+		if origin != "*" {
+			scheme, host, ok := strings.Cut(origin, "://")
+			if !ok || scheme == "" || host == "" || strings.Contains(host, "/") ||
+				strings.Count(origin, "*") > 1 || (strings.Contains(host, "*") && !strings.HasPrefix(host, "*.")) {
+				return nil, fmt.Errorf("invalid CORS origin %q", field)
+			}
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// allowsAnyOrigin reports whether the policy allows every origin
+func (p CORSPolicy) allowsAnyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsOrigin reports whether origin may call the routes
+func (p CORSPolicy) allowsOrigin(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok &&
+			len(origin) > len(prefix)+len(suffix) &&
+			strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			!strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:") {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMethods returns the methods of the policy the route matching r
+// registers. Outside a router, or for a route accepting any method, these
+// are all methods of the policy.
+func (p CORSPolicy) routeMethods(r *http.Request) []string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return p.AllowedMethods
+	}
+	registered, err := route.GetMethods()
+	if err != nil {
+		return p.AllowedMethods
+	}
+	var methods []string
+	for _, method := range registered {
+		if method != http.MethodOptions && containsMethod(p.AllowedMethods, method) {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// containsMethod reports whether methods lists method
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware adds the CORS headers of the policy. OPTIONS requests are
+// answered as preflights without calling next, so routes must list OPTIONS
+// among their methods to accept preflights.
+func (p CORSPolicy) Middleware(next http.Handler) http.Handler {
+	// With a fixed "*" every origin gets the same response, otherwise the
+	// origin is echoed and caches must keep the responses apart
+	wildcard := p.allowsAnyOrigin() && !p.AllowCredentials
 
-// CORSMiddleware handles Cross-Origin Resource Sharing
-func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		preflight := r.Method == http.MethodOptions
+		if !wildcard {
+			w.Header().Add("Vary", "Origin")
+		}
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		origin := r.Header.Get("Origin")
+		allowed := origin != "" && p.allowsOrigin(origin)
+		if allowed {
+			if wildcard {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if p.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+		if !preflight {
+			if allowed && len(p.ExposedHeaders) > 0 {
+				w.Header().Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// Without the CORS headers the browser refuses the actual request
+		methods := p.routeMethods(r)
+		if allowed && containsMethod(methods, r.Header.Get("Access-Control-Request-Method")) {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(p.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+			}
+			if p.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge.Seconds())))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
 	})
 }
+
+// PreflightFor matches preflight requests for one of methods and any other
+// request. Routes sharing a path, under the same or different policies, use
+// it so each route answers the preflights for its own methods.
+func PreflightFor(methods ...string) mux.MatcherFunc {
+	return func(r *http.Request, match *mux.RouteMatch) bool {
+		if r.Method != http.MethodOptions {
+			return true
+		}
+		requested := r.Header.Get("Access-Control-Request-Method")
+		for _, method := range methods {
+			if method == requested {
+				return true
+			}
+		}
+		return false
+	}
+}
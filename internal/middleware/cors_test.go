@@ -19,192 +19,219 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/suite"
 )
 
 type CORSMiddlewareTestSuite struct {
 	suite.Suite
+	router *mux.Router
+}
+
+func (suite *CORSMiddlewareTestSuite) SetupTest() {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("success"))
+	}
+
+	write := DefaultWriteCORS()
+	write.AllowedOrigins = []string{"https://door.example.com", "https://*.q30.space"}
+
+	suite.router = mux.NewRouter()
+	readRouter := suite.router.NewRoute().Subrouter()
+	readRouter.Use(DefaultReadCORS().Middleware)
+	readRouter.HandleFunc("/api/space", ok).Methods("GET", "OPTIONS").MatcherFunc(PreflightFor("GET"))
+	writeRouter := suite.router.PathPrefix("/api/space").Subrouter()
+	writeRouter.Use(write.Middleware)
+	writeRouter.HandleFunc("", ok).Methods("PATCH", "OPTIONS").MatcherFunc(PreflightFor("PATCH"))
+	writeRouter.HandleFunc("/state", ok).Methods("POST", "OPTIONS")
+	writeRouter.HandleFunc("/auth/blocks", ok).Methods("GET", "OPTIONS").MatcherFunc(PreflightFor("GET"))
+	writeRouter.HandleFunc("/auth/blocks", ok).Methods("DELETE", "OPTIONS").MatcherFunc(PreflightFor("DELETE"))
 }
 
 func TestCORSMiddlewareTestSuite(t *testing.T) {
 	suite.Run(t, new(CORSMiddlewareTestSuite))
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_RegularRequest() {
-	req := httptest.NewRequest("GET", "/api/space", nil)
+func (suite *CORSMiddlewareTestSuite) serve(method, path, origin string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w
+}
 
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("success"))
-	}))
+func (suite *CORSMiddlewareTestSuite) preflight(path, origin, method string) *httptest.ResponseRecorder {
+	return suite.serve("OPTIONS", path, origin, map[string]string{
+		"Access-Control-Request-Method":  method,
+		"Access-Control-Request-Headers": "content-type, x-api-key",
+	})
+}
 
-	handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestRead_AnyOrigin() {
+	w := suite.serve("GET", "/api/space", "https://example.com", nil)
 
 	suite.Assert().Equal(http.StatusOK, w.Code)
 	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	suite.Assert().Equal("ETag", w.Header().Get("Access-Control-Expose-Headers"))
+	suite.Assert().Empty(w.Header().Values("Vary"))
 	suite.Assert().Equal("success", w.Body.String())
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_POSTRequest() {
-	req := httptest.NewRequest("POST", "/api/space/state", nil)
-	req.Header.Set("Content-Type", "application/json")
-	w := httptest.NewRecorder()
-
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("success"))
-	}))
-
-	handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestRead_WithoutOrigin() {
+	w := suite.serve("GET", "/api/space", "", nil)
 
 	suite.Assert().Equal(http.StatusOK, w.Code)
-	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Origin"))
 	suite.Assert().Equal("success", w.Body.String())
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_OPTIONSRequest() {
-	req := httptest.NewRequest("OPTIONS", "/api/space", nil)
-	req.Header.Set("Access-Control-Request-Method", "POST")
-	req.Header.Set("Access-Control-Request-Headers", "Content-Type, Authorization")
-	w := httptest.NewRecorder()
+func (suite *CORSMiddlewareTestSuite) TestRead_Preflight() {
+	w := suite.preflight("/api/space", "https://example.com", "GET")
 
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// This should not be called for OPTIONS requests
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("handler-called"))
-	}))
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal("GET", w.Header().Get("Access-Control-Allow-Methods"))
+	suite.Assert().Equal("Last-Event-ID, If-None-Match, If-Modified-Since", w.Header().Get("Access-Control-Allow-Headers"))
+	suite.Assert().Equal("3600", w.Header().Get("Access-Control-Max-Age"))
+	suite.Assert().Empty(w.Body.String())
+}
 
-	handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestWrite_AllowedOrigin() {
+	w := suite.serve("POST", "/api/space/state", "https://door.example.com", nil)
 
 	suite.Assert().Equal(http.StatusOK, w.Code)
-	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
-	suite.Assert().Equal("", w.Body.String()) // Handler should not be called
+	suite.Assert().Equal("https://door.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal("ETag, Retry-After", w.Header().Get("Access-Control-Expose-Headers"))
+	suite.Assert().Equal([]string{"Origin"}, w.Header().Values("Vary"))
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_OPTIONSRequestWithOrigin() {
-	req := httptest.NewRequest("OPTIONS", "/api/space", nil)
-	req.Header.Set("Origin", "https://example.com")
-	req.Header.Set("Access-Control-Request-Method", "POST")
-	req.Header.Set("Access-Control-Request-Headers", "Content-Type, Authorization")
-	w := httptest.NewRecorder()
+func (suite *CORSMiddlewareTestSuite) TestWrite_WildcardSubdomain() {
+	w := suite.serve("POST", "/api/space/state", "https://bot.q30.space", nil)
+	suite.Assert().Equal("https://bot.q30.space", w.Header().Get("Access-Control-Allow-Origin"))
 
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("handler-called"))
-	}))
+	for _, origin := range []string{"https://q30.space", "http://bot.q30.space", "https://evil.com/.q30.space", "https://bot.q30.space.evil.com"} {
+		w = suite.serve("POST", "/api/space/state", origin, nil)
+		suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Origin"), origin)
+	}
+}
 
-	handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestWrite_DisallowedOrigin() {
+	w := suite.serve("POST", "/api/space/state", "https://example.com", nil)
 
+	// The request still reaches the handler, the browser hides the response
 	suite.Assert().Equal(http.StatusOK, w.Code)
-	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
-	suite.Assert().Equal("", w.Body.String()) // Handler should not be called
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal([]string{"Origin"}, w.Header().Values("Vary"))
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_WithAuthorizationHeader() {
-	req := httptest.NewRequest("GET", "/api/space", nil)
-	req.Header.Set("Authorization", "Bearer test-token")
-	w := httptest.NewRecorder()
+func (suite *CORSMiddlewareTestSuite) TestWrite_VaryWithoutOrigin() {
+	w := suite.serve("POST", "/api/space/state", "", nil)
 
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("success"))
-	}))
+	suite.Assert().Equal([]string{"Origin"}, w.Header().Values("Vary"))
+}
 
-	handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestWrite_Preflight() {
+	w := suite.preflight("/api/space/state", "https://door.example.com", "POST")
 
-	suite.Assert().Equal(http.StatusOK, w.Code)
-	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
-	suite.Assert().Equal("success", w.Body.String())
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	suite.Assert().Equal("https://door.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal("POST", w.Header().Get("Access-Control-Allow-Methods"))
+	suite.Assert().Equal("Content-Type, Authorization, X-API-Key, X-Signature, X-Timestamp, X-Nonce, If-Match", w.Header().Get("Access-Control-Allow-Headers"))
+	suite.Assert().Equal([]string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, w.Header().Values("Vary"))
+	suite.Assert().Empty(w.Body.String())
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_WithContentTypeHeader() {
-	req := httptest.NewRequest("POST", "/api/space/state", nil)
-	req.Header.Set("Content-Type", "application/json")
-	w := httptest.NewRecorder()
+func (suite *CORSMiddlewareTestSuite) TestWrite_PreflightDisallowedOrigin() {
+	w := suite.preflight("/api/space/state", "https://example.com", "POST")
 
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("success"))
-	}))
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Methods"))
+}
 
-	handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestWrite_PreflightDisallowedMethod() {
+	w := suite.preflight("/api/space/state", "https://door.example.com", "PUT")
 
-	suite.Assert().Equal(http.StatusOK, w.Code)
-	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
-	suite.Assert().Equal("success", w.Body.String())
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Methods"))
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_ErrorResponse() {
-	req := httptest.NewRequest("GET", "/api/space", nil)
-	w := httptest.NewRecorder()
+func (suite *CORSMiddlewareTestSuite) TestWrite_PreflightMethodOfOtherRoute() {
+	// The policy allows PATCH, but /api/space/state only accepts POST
+	w := suite.preflight("/api/space/state", "https://door.example.com", "PATCH")
 
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte("error"))
-	}))
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Methods"))
+}
 
-	handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestWrite_PreflightPerMethodRoutes() {
+	w := suite.preflight("/api/space/auth/blocks", "https://door.example.com", "DELETE")
+	suite.Assert().Equal("DELETE", w.Header().Get("Access-Control-Allow-Methods"))
 
-	suite.Assert().Equal(http.StatusInternalServerError, w.Code)
-	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
-	suite.Assert().Equal("error", w.Body.String())
+	w = suite.preflight("/api/space/auth/blocks", "https://door.example.com", "GET")
+	suite.Assert().Equal("GET", w.Header().Get("Access-Control-Allow-Methods"))
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_AllHTTPMethods() {
-	methods := []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD"}
+func (suite *CORSMiddlewareTestSuite) TestPreflight_SharedPath() {
+	// GET /api/space is public, PATCH /api/space follows the write policy
+	w := suite.preflight("/api/space", "https://example.com", "GET")
+	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal("GET", w.Header().Get("Access-Control-Allow-Methods"))
 
-	for _, method := range methods {
-		req := httptest.NewRequest(method, "/api/space", nil)
-		w := httptest.NewRecorder()
+	w = suite.preflight("/api/space", "https://example.com", "PATCH")
+	suite.Assert().Equal(http.StatusNoContent, w.Code)
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Origin"))
 
-		handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte("success"))
-		}))
+	w = suite.preflight("/api/space", "https://door.example.com", "PATCH")
+	suite.Assert().Equal("https://door.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal("PATCH", w.Header().Get("Access-Control-Allow-Methods"))
+}
 
-		handler.ServeHTTP(w, req)
+func (suite *CORSMiddlewareTestSuite) TestPreflight_UnknownRoute() {
+	w := suite.preflight("/api/space/unknown", "https://door.example.com", "POST")
 
-		suite.Assert().Equal(http.StatusOK, w.Code)
-		suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-		suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-		suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
-	}
+	suite.Assert().Equal(http.StatusNotFound, w.Code)
+	suite.Assert().Empty(w.Header().Get("Access-Control-Allow-Origin"))
 }
 
-func (suite *CORSMiddlewareTestSuite) TestCORSMiddleware_ChainedMiddleware() {
+func (suite *CORSMiddlewareTestSuite) TestCredentials() {
+	policy := CORSPolicy{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+	handler := policy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
 	req := httptest.NewRequest("GET", "/api/space", nil)
+	req.Header.Set("Origin", "https://example.com")
 	w := httptest.NewRecorder()
 
-	// Chain CORS with another middleware
-	handler := CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Add custom header
-		w.Header().Set("X-Custom-Header", "test-value")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("success"))
-	}))
-
 	handler.ServeHTTP(w, req)
 
-	suite.Assert().Equal(http.StatusOK, w.Code)
-	suite.Assert().Equal("*", w.Header().Get("Access-Control-Allow-Origin"))
-	suite.Assert().Equal("GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	suite.Assert().Equal("Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
-	suite.Assert().Equal("test-value", w.Header().Get("X-Custom-Header"))
-	suite.Assert().Equal("success", w.Body.String())
+	// Credentials rule out "*", so the origin is echoed
+	suite.Assert().Equal("https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	suite.Assert().Equal("true", w.Header().Get("Access-Control-Allow-Credentials"))
+	suite.Assert().Equal([]string{"Origin"}, w.Header().Values("Vary"))
+}
+
+func (suite *CORSMiddlewareTestSuite) TestParseOrigins() {
+	origins, err := ParseOrigins(" https://Door.example.com, https://*.q30.space ,,")
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]string{"https://door.example.com", "https://*.q30.space"}, origins)
+
+	origins, err = ParseOrigins("")
+	suite.Require().NoError(err)
+	suite.Assert().Empty(origins)
+
+	for _, spec := range []string{"example.com", "https://", "https://example.com/path", "https://foo*.example.com", "https://*.*.example.com"} {
+		_, err := ParseOrigins(spec)
+		suite.Assert().Error(err, spec)
+	}
 }
@@ -0,0 +1,273 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// Scopes granted to API keys. Sensor scopes may name a single sensor type,
+// as in sensors:write:temperature.
+const (
+	ScopeAll           = "*"
+	ScopeStateWrite    = "state:write"
+	ScopePeopleWrite   = "people:write"
+	ScopeEventsWrite   = "events:write"
+	ScopeSensorsWrite  = "sensors:write"
+	ScopeDocumentWrite = "document:write"
+	ScopeAdmin         = "admin"
+)
+
+// hashPrefix marks the hash algorithm of stored keys
+const hashPrefix = "sha256:"
+
+// errNoKeys is reported when no API keys are configured
+var errNoKeys = errors.New("no API keys configured, set SPACEAPI_KEYS_FILE or SPACEAPI_AUTH_KEY")
+
+// APIKey is a named key and the scopes it grants
+type APIKey struct {
+	Name string `json:"name"`
+	// Hash is "sha256:" followed by the hex SHA-256 of the key
+	Hash   string   `json:"hash"`
+	Scopes []string `json:"scopes"`
+
+	sum        []byte
+	signingKey []byte
+}
+
+// Allows reports whether the key grants scope. A granted scope also covers
+// its sub-scopes, so sensors:write allows sensors:write:temperature, and
+// sensors:write:radiation allows the sub-kinds sensors:write:radiation.alpha
+// and sensors:write:radiation.beta.
+func (k *APIKey) Allows(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == ScopeAll || granted == scope ||
+			strings.HasPrefix(scope, granted+":") || strings.HasPrefix(scope, granted+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyStore holds the API keys accepted by AuthMiddleware
+type KeyStore struct {
+	keys    []*APIKey
+	signing bool
+}
+
+// HashKey returns the value to store in the hash field for key
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// NewKeyStore checks keys and creates a store holding them
+func NewKeyStore(keys []APIKey) (*KeyStore, error) {
+	ks := &KeyStore{}
+	names := make(map[string]bool)
+	for i := range keys {
+		key := keys[i]
+		if key.Name == "" {
+			return nil, fmt.Errorf("key %d has no name", i+1)
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("key %q is defined twice", key.Name)
+		}
+		names[key.Name] = true
+
+		sum, err := hex.DecodeString(strings.TrimPrefix(key.Hash, hashPrefix))
+		if !strings.HasPrefix(key.Hash, hashPrefix) || err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("key %q: hash must be %s followed by 64 hex digits", key.Name, hashPrefix)
+		}
+		key.sum = sum
+
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("key %q has no scopes", key.Name)
+		}
+		for _, scope := range key.Scopes {
+			if !validScope(scope) {
+				return nil, fmt.Errorf("key %q: unknown scope %q", key.Name, scope)
+			}
+		}
+		ks.keys = append(ks.keys, &key)
+	}
+	return ks, nil
+}
+
+// EnableSigning accepts signed requests, made with the signing keys derived
+// from secret. The secret must be kept apart from the key file: anyone who
+// has both can sign requests for every key.
+func (ks *KeyStore) EnableSigning(secret string) {
+	for _, key := range ks.keys {
+		key.signingKey = mac([]byte(secret), key.sum)
+	}
+	ks.signing = true
+}
+
+// LoadKeyStore reads keys from a JSON file of the form
+// {"keys": [{"name": ..., "hash": ..., "scopes": [...]}]}
+func LoadKeyStore(path string) (*KeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+	var file struct {
+		Keys []APIKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys defined", path)
+	}
+	ks, err := NewKeyStore(file.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ks, nil
+}
+
+// LoadKeyStoreFromEnv loads the keys named by SPACEAPI_KEYS_FILE, or falls
+// back to SPACEAPI_AUTH_KEY as a single key named "default" with all scopes.
+// Signed requests are accepted if SPACEAPI_SIGNING_SECRET is set. It returns
+// nil if no keys are configured.
+func LoadKeyStoreFromEnv() (*KeyStore, error) {
+	var ks *KeyStore
+	var err error
+	if path := os.Getenv("SPACEAPI_KEYS_FILE"); path != "" {
+		ks, err = LoadKeyStore(path)
+	} else if key := os.Getenv("SPACEAPI_AUTH_KEY"); key != "" {
+		ks, err = NewKeyStore([]APIKey{{Name: "default", Hash: HashKey(key), Scopes: []string{ScopeAll}}})
+	}
+	if ks == nil || err != nil {
+		return nil, err
+	}
+
+	if secret := os.Getenv("SPACEAPI_SIGNING_SECRET"); secret != "" {
+		ks.EnableSigning(secret)
+	}
+	return ks, nil
+}
+
+// Authenticate returns the key matching the presented secret
+func (ks *KeyStore) Authenticate(secret string) (*APIKey, bool) {
+	sum := sha256.Sum256([]byte(secret))
+	var match *APIKey
+	// Compare against every key so the time taken does not reveal which
+	// key came close
+	for _, key := range ks.keys {
+		if subtle.ConstantTimeCompare(sum[:], key.sum) == 1 {
+			match = key
+		}
+	}
+	return match, match != nil
+}
+
+// validScope reports whether scope is one of the known scopes
+func validScope(scope string) bool {
+	switch scope {
+	case ScopeAll, ScopeStateWrite, ScopePeopleWrite, ScopeEventsWrite, ScopeSensorsWrite, ScopeDocumentWrite, ScopeAdmin:
+		return true
+	}
+	sensorType, ok := strings.CutPrefix(scope, ScopeSensorsWrite+":")
+	return ok && sensorType != ""
+}
+
+// Key store used by AuthMiddleware
+var keyStore *KeyStore
+var keyStoreMutex sync.RWMutex
+
+// SetKeyStore sets the keys accepted by AuthMiddleware
+func SetKeyStore(ks *KeyStore) {
+	keyStoreMutex.Lock()
+	defer keyStoreMutex.Unlock()
+	keyStore = ks
+}
+
+// getKeyStore returns the configured keys. Without SetKeyStore the
+// environment is consulted on every call.
+func getKeyStore() (*KeyStore, error) {
+	keyStoreMutex.RLock()
+	ks := keyStore
+	keyStoreMutex.RUnlock()
+	if ks != nil {
+		return ks, nil
+	}
+	return LoadKeyStoreFromEnv()
+}
+
+type keyContextKey struct{}
+
+// withKey attaches the authenticated key to ctx
+func withKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, keyContextKey{}, key)
+}
+
+// KeyFromContext returns the key that authenticated the request, if any
+func KeyFromContext(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(keyContextKey{}).(*APIKey)
+	return key
+}
+
+// KeyName returns the name of the key that authenticated the request, or an
+// empty string
+func KeyName(ctx context.Context) string {
+	if key := KeyFromContext(ctx); key != nil {
+		return key.Name
+	}
+	return ""
+}
+
+// RequireScope rejects requests whose key does not grant scope. Route
+// variables in braces are substituted, so sensors:write:{type} checks the
+// sensor type of the request. It must run after AuthMiddleware.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			required := scope
+			for name, value := range mux.Vars(r) {
+				required = strings.ReplaceAll(required, "{"+name+"}", value)
+			}
+
+			key := KeyFromContext(r.Context())
+			if key == nil {
+				log.Printf("ERROR: %s requires %s but the request was not authenticated", r.URL.Path, required)
+				http.Error(w, "Server configuration error", http.StatusInternalServerError)
+				return
+			}
+			if !key.Allows(required) {
+				log.Printf("SECURITY: Key %q lacks scope %s for %s %s", key.Name, required, r.Method, r.URL.Path)
+				http.Error(w, "API key lacks the "+required+" scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	doorKey   = "door-controller-secret"
+	sensorKey = "sensor-hub-secret"
+)
+
+// testClients hands out a distinct address per request so failed attempts
+// in one test cannot get another blocked by the shared rate limiter
+var testClients atomic.Int32
+
+type KeyStoreTestSuite struct {
+	suite.Suite
+	router *mux.Router
+}
+
+func (suite *KeyStoreTestSuite) SetupTest() {
+	keys, err := NewKeyStore([]APIKey{
+		{Name: "door", Hash: HashKey(doorKey), Scopes: []string{ScopeStateWrite}},
+		{Name: "sensors", Hash: HashKey(sensorKey), Scopes: []string{"sensors:write:temperature", "sensors:write:radiation", ScopePeopleWrite}},
+	})
+	suite.Require().NoError(err)
+	SetKeyStore(keys)
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(KeyName(r.Context())))
+	}
+	suite.router = mux.NewRouter()
+	protected := suite.router.PathPrefix("/api/space").Subrouter()
+	protected.Use(AuthMiddleware)
+	protected.Handle("/state", RequireScope(ScopeStateWrite)(http.HandlerFunc(ok))).Methods("POST")
+	protected.Handle("/sensors/{type}", RequireScope(ScopeSensorsWrite+":{type}")(http.HandlerFunc(ok))).Methods("POST")
+}
+
+func (suite *KeyStoreTestSuite) TearDownTest() {
+	SetKeyStore(nil)
+}
+
+func TestKeyStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(KeyStoreTestSuite))
+}
+
+func (suite *KeyStoreTestSuite) post(path, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, nil)
+	req.RemoteAddr = fmt.Sprintf("198.51.100.%d:1234", testClients.Add(1))
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	w := httptest.NewRecorder()
+	suite.router.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *KeyStoreTestSuite) TestScopeGranted() {
+	w := suite.post("/api/space/state", doorKey)
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("door", w.Body.String())
+}
+
+func (suite *KeyStoreTestSuite) TestScopeMissing() {
+	w := suite.post("/api/space/state", sensorKey)
+
+	suite.Assert().Equal(http.StatusForbidden, w.Code)
+	suite.Assert().Contains(w.Body.String(), "state:write")
+}
+
+func (suite *KeyStoreTestSuite) TestSensorScopePerType() {
+	suite.Assert().Equal(http.StatusOK, suite.post("/api/space/sensors/temperature", sensorKey).Code)
+
+	w := suite.post("/api/space/sensors/humidity", sensorKey)
+	suite.Assert().Equal(http.StatusForbidden, w.Code)
+	suite.Assert().Contains(w.Body.String(), "sensors:write:humidity")
+
+	suite.Assert().Equal(http.StatusOK, suite.post("/api/space/sensors/radiation.alpha", sensorKey).Code)
+	suite.Assert().Equal(http.StatusOK, suite.post("/api/space/sensors/radiation.beta", sensorKey).Code)
+}
+
+func (suite *KeyStoreTestSuite) TestUnknownKey() {
+	suite.Assert().Equal(http.StatusUnauthorized, suite.post("/api/space/state", "wrong").Code)
+	suite.Assert().Equal(http.StatusUnauthorized, suite.post("/api/space/state", "").Code)
+}
+
+func (suite *KeyStoreTestSuite) TestLegacyEnvironmentKey() {
+	SetKeyStore(nil)
+	suite.T().Setenv("SPACEAPI_KEYS_FILE", "")
+	suite.T().Setenv("SPACEAPI_AUTH_KEY", "legacy-secret")
+
+	w := suite.post("/api/space/sensors/anything", "legacy-secret")
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	suite.Assert().Equal("default", w.Body.String())
+}
+
+func (suite *KeyStoreTestSuite) TestNoKeysConfigured() {
+	SetKeyStore(nil)
+	suite.T().Setenv("SPACEAPI_KEYS_FILE", "")
+	suite.T().Setenv("SPACEAPI_AUTH_KEY", "")
+
+	suite.Assert().Equal(http.StatusInternalServerError, suite.post("/api/space/state", doorKey).Code)
+}
+
+func (suite *KeyStoreTestSuite) TestAllows() {
+	key := APIKey{Scopes: []string{ScopeSensorsWrite, ScopeEventsWrite}}
+	suite.Assert().True(key.Allows("sensors:write:temperature"))
+	suite.Assert().True(key.Allows(ScopeEventsWrite))
+	suite.Assert().False(key.Allows(ScopeStateWrite))
+	// Only whole scope segments match
+	suite.Assert().False((&APIKey{Scopes: []string{"sensors:write:temp"}}).Allows("sensors:write:temperature"))
+
+	// A sensor type covers its sub-kinds
+	radiation := APIKey{Scopes: []string{"sensors:write:radiation"}}
+	suite.Assert().True(radiation.Allows("sensors:write:radiation.alpha"))
+	suite.Assert().True(radiation.Allows("sensors:write:radiation.beta_gamma"))
+	suite.Assert().False(radiation.Allows("sensors:write:radiation_level"))
+	gamma := APIKey{Scopes: []string{"sensors:write:radiation.gamma"}}
+	suite.Assert().True(gamma.Allows("sensors:write:radiation.gamma"))
+	suite.Assert().False(gamma.Allows("sensors:write:radiation.alpha"))
+	suite.Assert().False(gamma.Allows("sensors:write:radiation"))
+
+	all := APIKey{Scopes: []string{ScopeAll}}
+	suite.Assert().True(all.Allows(ScopeAdmin))
+}
+
+func (suite *KeyStoreTestSuite) TestLoadKeyStore() {
+	path := filepath.Join(suite.T().TempDir(), "keys.json")
+	content := fmt.Sprintf(`{"keys": [{"name": "bot", "hash": %q, "scopes": ["events:write"]}]}`, HashKey("bot-secret"))
+	suite.Require().NoError(os.WriteFile(path, []byte(content), 0o600))
+
+	keys, err := LoadKeyStore(path)
+	suite.Require().NoError(err)
+	key, ok := keys.Authenticate("bot-secret")
+	suite.Require().True(ok)
+	suite.Assert().Equal("bot", key.Name)
+	_, ok = keys.Authenticate("bot-secret ")
+	suite.Assert().False(ok)
+}
+
+func (suite *KeyStoreTestSuite) TestNewKeyStore_Invalid() {
+	hash := HashKey("secret")
+	cases := map[string][]APIKey{
+		"has no name":        {{Hash: hash, Scopes: []string{ScopeAll}}},
+		"is defined twice":   {{Name: "a", Hash: hash, Scopes: []string{ScopeAll}}, {Name: "a", Hash: HashKey("other"), Scopes: []string{ScopeAll}}},
+		"hash must be":       {{Name: "a", Hash: "secret", Scopes: []string{ScopeAll}}},
+		"has no scopes":      {{Name: "a", Hash: hash}},
+		"unknown scope":      {{Name: "a", Hash: hash, Scopes: []string{"state:read"}}},
+		`unknown scope "sen`: {{Name: "a", Hash: hash, Scopes: []string{"sensors:write:"}}},
+	}
+	for message, keys := range cases {
+		_, err := NewKeyStore(keys)
+		suite.Assert().ErrorContains(err, message)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type RateLimiterTestSuite struct {
+	suite.Suite
+	now     time.Time
+	limiter *RateLimiter
+}
+
+func (suite *RateLimiterTestSuite) SetupTest() {
+	// Blocks loaded from disk are checked against the real clock
+	suite.now = time.Now()
+	suite.limiter = suite.newLimiter(RateLimitConfig{
+		MaxAttempts:   3,
+		Window:        10 * time.Minute,
+		BlockDuration: 30 * time.Minute,
+	})
+}
+
+func TestRateLimiterTestSuite(t *testing.T) {
+	suite.Run(t, new(RateLimiterTestSuite))
+}
+
+// newLimiter creates a limiter whose clock is suite.now
+func (suite *RateLimiterTestSuite) newLimiter(config RateLimitConfig) *RateLimiter {
+	limiter, err := NewRateLimiter(config)
+	suite.Require().NoError(err)
+	limiter.now = func() time.Time { return suite.now }
+	return limiter
+}
+
+func (suite *RateLimiterTestSuite) fail(ip string, times int) {
+	for i := 0; i < times; i++ {
+		suite.limiter.recordFailedAttempt(ip)
+	}
+}
+
+func (suite *RateLimiterTestSuite) TestDefaults() {
+	limiter, err := NewRateLimiter(RateLimitConfig{})
+	suite.Require().NoError(err)
+
+	suite.Assert().Equal(DefaultRateLimitAttempts, limiter.config.MaxAttempts)
+	suite.Assert().Equal(DefaultRateLimitWindow, limiter.config.Window)
+	suite.Assert().Equal(DefaultRateLimitBlock, limiter.config.BlockDuration)
+	suite.Assert().Equal(DefaultIPv6PrefixLength, limiter.config.IPv6PrefixLength)
+}
+
+func (suite *RateLimiterTestSuite) TestBlocksAfterMaxAttempts() {
+	suite.fail("192.0.2.1", 2)
+	suite.Assert().False(suite.limiter.isBlocked("192.0.2.1"))
+
+	suite.fail("192.0.2.1", 1)
+	suite.Assert().True(suite.limiter.isBlocked("192.0.2.1"))
+	suite.Assert().False(suite.limiter.isBlocked("192.0.2.2"))
+	suite.Assert().Equal(1800, suite.limiter.getRetryAfter("192.0.2.1"))
+
+	suite.now = suite.now.Add(30 * time.Minute)
+	suite.Assert().False(suite.limiter.isBlocked("192.0.2.1"))
+}
+
+func (suite *RateLimiterTestSuite) TestWindowExpires() {
+	suite.fail("192.0.2.1", 2)
+	suite.now = suite.now.Add(11 * time.Minute)
+	suite.fail("192.0.2.1", 2)
+
+	suite.Assert().False(suite.limiter.isBlocked("192.0.2.1"))
+}
+
+func (suite *RateLimiterTestSuite) TestCountsAfreshAfterBlock() {
+	suite.fail("192.0.2.1", 3)
+	suite.now = suite.now.Add(31 * time.Minute)
+
+	suite.fail("192.0.2.1", 1)
+
+	suite.Assert().False(suite.limiter.isBlocked("192.0.2.1"))
+	suite.Assert().Equal(uint64(1), suite.limiter.Stats().Blocks)
+}
+
+func (suite *RateLimiterTestSuite) TestGroupsIPv6Networks() {
+	suite.fail("2001:db8:1:2::1", 1)
+	suite.fail("2001:db8:1:2:aaaa::1", 1)
+	suite.fail("2001:db8:1:2:ffff:ffff:ffff:ffff", 1)
+
+	suite.Assert().True(suite.limiter.isBlocked("2001:db8:1:2::99"))
+	suite.Assert().False(suite.limiter.isBlocked("2001:db8:1:3::1"))
+	suite.Assert().Equal("2001:db8:1:2::/64", suite.limiter.Blocked()[0].Address)
+}
+
+func (suite *RateLimiterTestSuite) TestIPv6PrefixLength() {
+	suite.limiter = suite.newLimiter(RateLimitConfig{MaxAttempts: 2, IPv6PrefixLength: 128})
+	suite.fail("2001:db8::1", 1)
+	suite.fail("2001:db8::2", 1)
+
+	suite.Assert().False(suite.limiter.isBlocked("2001:db8::1"))
+}
+
+func (suite *RateLimiterTestSuite) TestUnblock() {
+	suite.fail("192.0.2.1", 3)
+	suite.fail("2001:db8::1", 3)
+	suite.Require().Len(suite.limiter.Blocked(), 2)
+
+	suite.Assert().True(suite.limiter.Unblock("192.0.2.1"))
+	suite.Assert().False(suite.limiter.isBlocked("192.0.2.1"))
+	suite.Assert().False(suite.limiter.Unblock("192.0.2.1"))
+
+	// An address inside a blocked IPv6 network unblocks the network
+	suite.Assert().True(suite.limiter.Unblock("2001:db8::abcd"))
+	suite.Assert().Empty(suite.limiter.Blocked())
+}
+
+func (suite *RateLimiterTestSuite) TestUnblockNetwork() {
+	suite.fail("2001:db8::1", 3)
+
+	suite.Assert().True(suite.limiter.Unblock("2001:db8::/64"))
+	suite.Assert().False(suite.limiter.isBlocked("2001:db8::1"))
+}
+
+func (suite *RateLimiterTestSuite) TestUnblockNonCanonical() {
+	cases := []struct {
+		client  string
+		address string
+	}{
+		{"192.0.2.1", "192.0.2.1/32"},
+		{"192.0.2.1", "192.0.2.0/24"},
+		{"192.0.2.1", "::ffff:192.0.2.1"},
+		{"2001:db8::1", "2001:DB8:0:0:0:0:0:1"},
+		{"2001:db8::1", "2001:0db8::/32"},
+		{"2001:db8::1", "[2001:db8::5]"},
+	}
+
+	for _, tc := range cases {
+		suite.fail(tc.client, 3)
+		suite.Require().True(suite.limiter.isBlocked(tc.client))
+
+		suite.Assert().True(suite.limiter.Unblock(tc.address), tc.address)
+		suite.Assert().False(suite.limiter.isBlocked(tc.client), tc.address)
+	}
+
+	suite.Assert().False(suite.limiter.Unblock("not an address"))
+}
+
+func (suite *RateLimiterTestSuite) TestUnblockAll() {
+	suite.fail("192.0.2.1", 3)
+	suite.fail("192.0.2.2", 3)
+	suite.fail("192.0.2.3", 1)
+
+	suite.Assert().Equal(2, suite.limiter.UnblockAll())
+	suite.Assert().Empty(suite.limiter.Blocked())
+	suite.Assert().Zero(suite.limiter.Stats().BlockedIPs)
+}
+
+func (suite *RateLimiterTestSuite) TestPrune() {
+	suite.fail("192.0.2.1", 3)
+	suite.fail("192.0.2.2", 1)
+	suite.now = suite.now.Add(5 * time.Minute)
+	suite.fail("192.0.2.3", 1)
+
+	suite.now = suite.now.Add(6 * time.Minute)
+	suite.limiter.prune()
+
+	suite.Assert().Contains(suite.limiter.attempts, "192.0.2.1")
+	suite.Assert().NotContains(suite.limiter.attempts, "192.0.2.2")
+	suite.Assert().Contains(suite.limiter.attempts, "192.0.2.3")
+
+	suite.now = suite.now.Add(30 * time.Minute)
+	suite.limiter.prune()
+	suite.Assert().Empty(suite.limiter.attempts)
+}
+
+func (suite *RateLimiterTestSuite) TestPersistsBlocks() {
+	path := filepath.Join(suite.T().TempDir(), "ratelimit.json")
+	config := RateLimitConfig{MaxAttempts: 3, BlockDuration: 30 * time.Minute, StatePath: path}
+	suite.limiter = suite.newLimiter(config)
+	suite.fail("192.0.2.1", 3)
+	suite.fail("192.0.2.2", 1)
+
+	restarted, err := NewRateLimiter(config)
+	suite.Require().NoError(err)
+	restarted.now = func() time.Time { return suite.now }
+	suite.Assert().True(restarted.isBlocked("192.0.2.1"))
+	suite.Assert().Len(restarted.Blocked(), 1)
+
+	// Unblocking is persisted too
+	restarted.UnblockAll()
+	restarted, err = NewRateLimiter(config)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(restarted.Blocked())
+}
+
+func (suite *RateLimiterTestSuite) TestLoadSkipsExpiredBlocks() {
+	path := filepath.Join(suite.T().TempDir(), "ratelimit.json")
+	suite.Require().NoError(os.WriteFile(path, []byte(`{"blocks": [
+		{"address": "192.0.2.1", "failures": 5, "first_attempt": "2020-01-01T00:00:00Z", "blocked_until": "2020-01-01T01:00:00Z"}
+	]}`), 0o600))
+
+	limiter, err := NewRateLimiter(RateLimitConfig{StatePath: path})
+
+	suite.Require().NoError(err)
+	suite.Assert().Empty(limiter.attempts)
+}
+
+func (suite *RateLimiterTestSuite) TestLoadInvalidState() {
+	path := filepath.Join(suite.T().TempDir(), "ratelimit.json")
+	suite.Require().NoError(os.WriteFile(path, []byte(`{"blocks": `), 0o600))
+
+	_, err := NewRateLimiter(RateLimitConfig{StatePath: path})
+
+	suite.Assert().Error(err)
+}
+
+func (suite *RateLimiterTestSuite) TestMiddlewareRetryAfter() {
+	keys, err := NewKeyStore([]APIKey{{Name: "door", Hash: HashKey(doorKey), Scopes: []string{ScopeAll}}})
+	suite.Require().NoError(err)
+	SetKeyStore(keys)
+	defer SetKeyStore(nil)
+	// Real clock, as the middleware compares against time.Now
+	limiter, err := NewRateLimiter(RateLimitConfig{MaxAttempts: 1, BlockDuration: 90 * time.Second})
+	suite.Require().NoError(err)
+	SetRateLimiter(limiter)
+	defer SetRateLimiter(nil)
+
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	request := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/space/state", nil)
+		req.RemoteAddr = "192.0.2.50:1234"
+		req.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	suite.Assert().Equal(http.StatusUnauthorized, request("wrong").Code)
+
+	w := request(doorKey)
+	suite.Assert().Equal(http.StatusTooManyRequests, w.Code)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	suite.Require().NoError(err)
+	suite.Assert().InDelta(90, retryAfter, 1)
+}
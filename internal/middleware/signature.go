@@ -0,0 +1,232 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers of signed requests
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+)
+
+// MaxClockSkew is how far the timestamp of a signed request may be from the
+// server clock
+const MaxClockSkew = 5 * time.Minute
+
+// maxSignedBody limits the body read to verify a signature
+const maxSignedBody = 1 << 20
+
+var (
+	errSigningDisabled  = errors.New("signed requests are not enabled on this server")
+	errMissingSignature = errors.New("signed requests need X-Signature, X-Timestamp and X-Nonce headers")
+	errStaleTimestamp   = errors.New("request timestamp outside the allowed window")
+	errBadSignature     = errors.New("invalid signature")
+	errReplayed         = errors.New("request was already used")
+	errBodyTooLarge     = errors.New("request body too large")
+)
+
+// StringToSign returns the text covered by the signature of a request:
+// method, request URI, timestamp, nonce and the hex SHA-256 of the body, one
+// per line
+func StringToSign(method, uri, timestamp, nonce string, body []byte) string {
+	bodySum := sha256.Sum256(body)
+	return strings.Join([]string{method, uri, timestamp, nonce, hex.EncodeToString(bodySum[:])}, "\n")
+}
+
+// SigningKey derives the key a client signs requests with from the server's
+// signing secret and the client's API key. The server derives it from the
+// stored key hash, so the key file alone is not enough to sign requests.
+func SigningKey(secret, apiKey string) []byte {
+	keySum := sha256.Sum256([]byte(apiKey))
+	return mac([]byte(secret), keySum[:])
+}
+
+// Sign returns the signature of stringToSign made with a signing key
+func Sign(signingKey []byte, stringToSign string) string {
+	return hex.EncodeToString(mac(signingKey, []byte(stringToSign)))
+}
+
+// mac returns the HMAC-SHA256 of message
+func mac(key, message []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// SignRequest adds signature headers made with signingKey to r, leaving its
+// body readable
+func SignRequest(r *http.Request, signingKey []byte, nonce string, now time.Time) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(TimestampHeader, timestamp)
+	r.Header.Set(NonceHeader, nonce)
+	r.Header.Set(SignatureHeader, Sign(signingKey, StringToSign(r.Method, r.URL.RequestURI(), timestamp, nonce, body)))
+	return nil
+}
+
+// verifySignature authenticates a signed request and leaves its body
+// readable for the handler
+func (ks *KeyStore) verifySignature(w http.ResponseWriter, r *http.Request, nonces *NonceCache, now time.Time) (*APIKey, error) {
+	if !ks.signing {
+		return nil, errSigningDisabled
+	}
+
+	signature := r.Header.Get(SignatureHeader)
+	timestamp := r.Header.Get(TimestampHeader)
+	nonce := r.Header.Get(NonceHeader)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return nil, errMissingSignature
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errStaleTimestamp
+	}
+	signedAt := time.Unix(seconds, 0)
+	if signedAt.Before(now.Add(-MaxClockSkew)) || signedAt.After(now.Add(MaxClockSkew)) {
+		return nil, errStaleTimestamp
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBody))
+	if err != nil {
+		return nil, errBodyTooLarge
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	provided, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return nil, errBadSignature
+	}
+	stringToSign := StringToSign(r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	var match *APIKey
+	for _, key := range ks.keys {
+		if hmac.Equal(provided, mac(key.signingKey, []byte(stringToSign))) {
+			match = key
+		}
+	}
+	if match == nil {
+		return nil, errBadSignature
+	}
+
+	// A nonce only needs to be remembered while its timestamp is accepted
+	if !nonces.Add(match.Name+"\x00"+nonce, signedAt.Add(MaxClockSkew)) {
+		return nil, errReplayed
+	}
+	return match, nil
+}
+
+// NonceCache remembers the nonces of signed requests until their timestamp
+// falls out of the allowed window
+type NonceCache struct {
+	mutex  sync.Mutex
+	nonces map[string]time.Time
+	// nextPrune is when expired nonces are dropped next
+	nextPrune time.Time
+}
+
+// NewNonceCache creates an empty cache
+func NewNonceCache() *NonceCache {
+	return &NonceCache{nonces: make(map[string]time.Time)}
+}
+
+// Add records nonce until expiry. It returns false if the nonce was already
+// recorded and has not expired.
+func (c *NonceCache) Add(nonce string, expiry time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if now.After(c.nextPrune) {
+		for seen, until := range c.nonces {
+			if now.After(until) {
+				delete(c.nonces, seen)
+			}
+		}
+		c.nextPrune = now.Add(MaxClockSkew)
+	}
+
+	if until, ok := c.nonces[nonce]; ok && !now.After(until) {
+		return false
+	}
+	c.nonces[nonce] = expiry
+	return true
+}
+
+// Len returns the number of remembered nonces
+func (c *NonceCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.nonces)
+}
+
+// Global nonce cache instance
+var nonceCache *NonceCache
+var nonceCacheOnce sync.Once
+
+// getNonceCache returns the global nonce cache, initializing it if needed
+func getNonceCache() *NonceCache {
+	nonceCacheOnce.Do(func() {
+		nonceCache = NewNonceCache()
+	})
+	return nonceCache
+}
+
+// signatureStatus maps verification errors to response codes
+func signatureStatus(err error) int {
+	switch err {
+	case errMissingSignature:
+		return http.StatusBadRequest
+	case errBodyTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusUnauthorized
+	}
+}
+
+// describeSignatureError formats err for the response body
+func describeSignatureError(err error) string {
+	if err == errStaleTimestamp {
+		return fmt.Sprintf("Request timestamp must be within %d seconds of the server time", int(MaxClockSkew.Seconds()))
+	}
+	s := err.Error()
+	return strings.ToUpper(s[:1]) + s[1:]
+}
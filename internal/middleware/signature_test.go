@@ -0,0 +1,174 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// signingSecret is the server-side secret signing keys are derived from
+const signingSecret = "server-signing-secret"
+
+type SignatureTestSuite struct {
+	suite.Suite
+	handler http.Handler
+	nonce   int
+}
+
+func (suite *SignatureTestSuite) SetupTest() {
+	keys, err := NewKeyStore([]APIKey{
+		{Name: "door-sensor", Hash: HashKey(doorKey), Scopes: []string{ScopeStateWrite}},
+	})
+	suite.Require().NoError(err)
+	keys.EnableSigning(signingSecret)
+	SetKeyStore(keys)
+
+	suite.handler = AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", KeyName(r.Context()), body)
+	}))
+}
+
+func (suite *SignatureTestSuite) TearDownTest() {
+	SetKeyStore(nil)
+}
+
+func TestSignatureTestSuite(t *testing.T) {
+	suite.Run(t, new(SignatureTestSuite))
+}
+
+// signed builds a request signed with the signing key of key at the given
+// time
+func (suite *SignatureTestSuite) signed(path, body, key string, at time.Time) *http.Request {
+	return suite.signedWith(path, body, SigningKey(signingSecret, key), at)
+}
+
+// signedWith builds a request signed with signingKey at the given time
+func (suite *SignatureTestSuite) signedWith(path, body string, signingKey []byte, at time.Time) *http.Request {
+	suite.nonce++
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.RemoteAddr = fmt.Sprintf("198.51.100.%d:1234", testClients.Add(1))
+	suite.Require().NoError(SignRequest(req, signingKey, fmt.Sprintf("%s-nonce-%d", suite.T().Name(), suite.nonce), at))
+	return req
+}
+
+func (suite *SignatureTestSuite) serve(req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	suite.handler.ServeHTTP(w, req)
+	return w
+}
+
+func (suite *SignatureTestSuite) TestValidSignature() {
+	w := suite.serve(suite.signed("/api/space/state", `{"open": true}`, doorKey, time.Now()))
+
+	suite.Assert().Equal(http.StatusOK, w.Code)
+	// The handler still sees the body that was hashed
+	suite.Assert().Equal(`door-sensor {"open": true}`, w.Body.String())
+}
+
+func (suite *SignatureTestSuite) TestTamperedRequest() {
+	req := suite.signed("/api/space/state", `{"open": true}`, doorKey, time.Now())
+	req.Body = io.NopCloser(bytes.NewBufferString(`{"open": false}`))
+	suite.Assert().Equal(http.StatusUnauthorized, suite.serve(req).Code)
+
+	req = suite.signed("/api/space/state", `{"open": true}`, doorKey, time.Now())
+	req.URL.Path = "/api/space/people"
+	suite.Assert().Equal(http.StatusUnauthorized, suite.serve(req).Code)
+
+	req = suite.signed("/api/space/state", `{"open": true}`, "some-other-key", time.Now())
+	suite.Assert().Equal(http.StatusUnauthorized, suite.serve(req).Code)
+}
+
+func (suite *SignatureTestSuite) TestKeyHashCannotSign() {
+	// The key file only holds the hash, which must not be enough to sign
+	keySum := sha256.Sum256([]byte(doorKey))
+	req := suite.signedWith("/api/space/state", `{"open": true}`, keySum[:], time.Now())
+	suite.Assert().Equal(http.StatusUnauthorized, suite.serve(req).Code)
+
+	req = suite.signed("/api/space/state", `{"open": true}`, doorKey, time.Now())
+	suite.Assert().Equal(http.StatusOK, suite.serve(req).Code)
+}
+
+func (suite *SignatureTestSuite) TestSigningDisabled() {
+	keys, err := NewKeyStore([]APIKey{
+		{Name: "door-sensor", Hash: HashKey(doorKey), Scopes: []string{ScopeStateWrite}},
+	})
+	suite.Require().NoError(err)
+	SetKeyStore(keys)
+
+	w := suite.serve(suite.signed("/api/space/state", `{"open": true}`, doorKey, time.Now()))
+	suite.Assert().Equal(http.StatusUnauthorized, w.Code)
+	suite.Assert().Contains(w.Body.String(), "not enabled")
+}
+
+func (suite *SignatureTestSuite) TestReplay() {
+	req := suite.signed("/api/space/state", `{"open": true}`, doorKey, time.Now())
+	replay := req.Clone(req.Context())
+	replay.Body = io.NopCloser(bytes.NewBufferString(`{"open": true}`))
+
+	suite.Assert().Equal(http.StatusOK, suite.serve(req).Code)
+	w := suite.serve(replay)
+	suite.Assert().Equal(http.StatusUnauthorized, w.Code)
+	suite.Assert().Contains(w.Body.String(), "already used")
+}
+
+func (suite *SignatureTestSuite) TestClockSkew() {
+	failures := GetAuthStats().Failures
+
+	w := suite.serve(suite.signed("/api/space/state", `{}`, doorKey, time.Now().Add(-MaxClockSkew-time.Minute)))
+	suite.Assert().Equal(http.StatusUnauthorized, w.Code)
+	suite.Assert().Contains(w.Body.String(), "within 300 seconds")
+
+	suite.Assert().Equal(http.StatusUnauthorized, suite.serve(suite.signed("/api/space/state", `{}`, doorKey, time.Now().Add(MaxClockSkew+time.Minute))).Code)
+	suite.Assert().Equal(http.StatusOK, suite.serve(suite.signed("/api/space/state", `{}`, doorKey, time.Now().Add(-MaxClockSkew/2))).Code)
+
+	// A device with a wrong clock is not treated as an attacker
+	suite.Assert().Equal(failures, GetAuthStats().Failures)
+}
+
+func (suite *SignatureTestSuite) TestMissingHeaders() {
+	req := suite.signed("/api/space/state", `{}`, doorKey, time.Now())
+	req.Header.Del(NonceHeader)
+
+	suite.Assert().Equal(http.StatusBadRequest, suite.serve(req).Code)
+}
+
+func (suite *SignatureTestSuite) TestStringToSign() {
+	// Clients in other languages implement this layout
+	suite.Assert().Equal(
+		"POST\n/api/space/state\n1700000000\nabc\n"+
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		StringToSign("POST", "/api/space/state", "1700000000", "abc", nil))
+}
+
+func (suite *SignatureTestSuite) TestNonceCache() {
+	cache := NewNonceCache()
+	suite.Assert().True(cache.Add("a", time.Now().Add(time.Minute)))
+	suite.Assert().False(cache.Add("a", time.Now().Add(time.Minute)))
+	// Expired nonces may be used again, their timestamp is rejected anyway
+	suite.Assert().True(cache.Add("b", time.Now().Add(-time.Second)))
+	suite.Assert().True(cache.Add("b", time.Now().Add(time.Minute)))
+	suite.Assert().Equal(2, cache.Len())
+}
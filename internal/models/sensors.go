@@ -0,0 +1,444 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+// Sensors holds the sensor readings of the SpaceAPI v14/v15 specification.
+// Sensor kinds outside the specification, such as ext_* sensors, are kept
+// in Extra.
+type Sensors struct {
+	Temperature        []TemperatureSensor        `json:"temperature,omitempty"`
+	CarbonDioxide      []CarbonDioxideSensor      `json:"carbondioxide,omitempty"`
+	DoorLocked         []DoorLockedSensor         `json:"door_locked,omitempty"`
+	Barometer          []BarometerSensor          `json:"barometer,omitempty"`
+	Radiation          *RadiationSensors          `json:"radiation,omitempty"`
+	Humidity           []HumiditySensor           `json:"humidity,omitempty"`
+	BeverageSupply     []BeverageSupplySensor     `json:"beverage_supply,omitempty"`
+	PowerConsumption   []PowerSensor              `json:"power_consumption,omitempty"`
+	PowerGeneration    []PowerSensor              `json:"power_generation,omitempty"`
+	Wind               []WindSensor               `json:"wind,omitempty"`
+	NetworkConnections []NetworkConnectionsSensor `json:"network_connections,omitempty"`
+	AccountBalance     []AccountBalanceSensor     `json:"account_balance,omitempty"`
+	TotalMemberCount   []TotalMemberCountSensor   `json:"total_member_count,omitempty"`
+	PeopleNowPresent   []PeopleNowPresentSensor   `json:"people_now_present,omitempty"`
+	NetworkTraffic     []NetworkTrafficSensor     `json:"network_traffic,omitempty"`
+	Extra              Extra                      `json:"-"`
+}
+
+// SensorMeta holds the fields shared by all sensor readings. Members of a
+// reading that its type does not know, such as ext_* keys, are kept in Extra.
+type SensorMeta struct {
+	Location    string `json:"location,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Lastchange  int64  `json:"lastchange,omitempty"`
+	Extra       Extra  `json:"-"`
+}
+
+// Meta gives generic code access to the shared fields of a reading
+func (m *SensorMeta) Meta() *SensorMeta {
+	return m
+}
+
+// Sensor is implemented by pointers to all sensor reading types
+type Sensor interface {
+	Meta() *SensorMeta
+}
+
+type TemperatureSensor struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+	SensorMeta
+}
+
+type CarbonDioxideSensor struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+	SensorMeta
+}
+
+type DoorLockedSensor struct {
+	Value bool `json:"value"`
+	SensorMeta
+}
+
+type BarometerSensor struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+	SensorMeta
+}
+
+// RadiationSensors groups radiation readings by the kind of radiation
+type RadiationSensors struct {
+	Alpha     []RadiationSensor `json:"alpha,omitempty"`
+	Beta      []RadiationSensor `json:"beta,omitempty"`
+	Gamma     []RadiationSensor `json:"gamma,omitempty"`
+	BetaGamma []RadiationSensor `json:"beta_gamma,omitempty"`
+	Extra     Extra             `json:"-"`
+}
+
+type RadiationSensor struct {
+	Value            float64  `json:"value"`
+	Unit             string   `json:"unit"`
+	DeadTime         *float64 `json:"dead_time,omitempty"`
+	ConversionFactor *float64 `json:"conversion_factor,omitempty"`
+	SensorMeta
+}
+
+type HumiditySensor struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+	SensorMeta
+}
+
+type BeverageSupplySensor struct {
+	Value int    `json:"value"`
+	Unit  string `json:"unit"`
+	SensorMeta
+}
+
+// PowerSensor is used for both power_consumption and power_generation
+type PowerSensor struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+	SensorMeta
+}
+
+type WindSensor struct {
+	Properties WindProperties `json:"properties"`
+	SensorMeta
+}
+
+type WindProperties struct {
+	Speed     Measurement `json:"speed"`
+	Gust      Measurement `json:"gust"`
+	Direction Measurement `json:"direction"`
+	Elevation Measurement `json:"elevation"`
+}
+
+// Measurement is a value with its unit, used inside structured sensors
+type Measurement struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type NetworkConnectionsSensor struct {
+	Type     string    `json:"type,omitempty"`
+	Value    int       `json:"value"`
+	Machines []Machine `json:"machines,omitempty"`
+	SensorMeta
+}
+
+type Machine struct {
+	Name string `json:"name,omitempty"`
+	MAC  string `json:"mac"`
+}
+
+type AccountBalanceSensor struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+	SensorMeta
+}
+
+type TotalMemberCountSensor struct {
+	Value int `json:"value"`
+	SensorMeta
+}
+
+type PeopleNowPresentSensor struct {
+	Value int      `json:"value"`
+	Names []string `json:"names,omitempty"`
+	SensorMeta
+}
+
+type NetworkTrafficSensor struct {
+	Properties NetworkTrafficProperties `json:"properties"`
+	SensorMeta
+}
+
+type NetworkTrafficProperties struct {
+	BitsPerSecond    *BitsPerSecond    `json:"bits_per_second,omitempty"`
+	PacketsPerSecond *PacketsPerSecond `json:"packets_per_second,omitempty"`
+}
+
+type BitsPerSecond struct {
+	Value   float64  `json:"value"`
+	Maximum *float64 `json:"maximum,omitempty"`
+}
+
+type PacketsPerSecond struct {
+	Value float64 `json:"value"`
+}
+
+type plainSensors Sensors
+
+func (s Sensors) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainSensors(s), s.Extra)
+}
+
+func (s *Sensors) UnmarshalJSON(data []byte) error {
+	var plain plainSensors
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = Sensors(plain)
+	s.Extra = extra
+	return nil
+}
+
+// The readings embed SensorMeta, whose Extra holds their unknown members
+type (
+	plainRadiationSensors         RadiationSensors
+	plainTemperatureSensor        TemperatureSensor
+	plainCarbonDioxideSensor      CarbonDioxideSensor
+	plainDoorLockedSensor         DoorLockedSensor
+	plainBarometerSensor          BarometerSensor
+	plainRadiationSensor          RadiationSensor
+	plainHumiditySensor           HumiditySensor
+	plainBeverageSupplySensor     BeverageSupplySensor
+	plainPowerSensor              PowerSensor
+	plainWindSensor               WindSensor
+	plainNetworkConnectionsSensor NetworkConnectionsSensor
+	plainAccountBalanceSensor     AccountBalanceSensor
+	plainTotalMemberCountSensor   TotalMemberCountSensor
+	plainPeopleNowPresentSensor   PeopleNowPresentSensor
+	plainNetworkTrafficSensor     NetworkTrafficSensor
+)
+
+func (r RadiationSensors) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainRadiationSensors(r), r.Extra)
+}
+
+func (r *RadiationSensors) UnmarshalJSON(data []byte) error {
+	var plain plainRadiationSensors
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*r = RadiationSensors(plain)
+	r.Extra = extra
+	return nil
+}
+
+func (s TemperatureSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainTemperatureSensor(s), s.Extra)
+}
+
+func (s *TemperatureSensor) UnmarshalJSON(data []byte) error {
+	var plain plainTemperatureSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = TemperatureSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s CarbonDioxideSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainCarbonDioxideSensor(s), s.Extra)
+}
+
+func (s *CarbonDioxideSensor) UnmarshalJSON(data []byte) error {
+	var plain plainCarbonDioxideSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = CarbonDioxideSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s DoorLockedSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainDoorLockedSensor(s), s.Extra)
+}
+
+func (s *DoorLockedSensor) UnmarshalJSON(data []byte) error {
+	var plain plainDoorLockedSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = DoorLockedSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s BarometerSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainBarometerSensor(s), s.Extra)
+}
+
+func (s *BarometerSensor) UnmarshalJSON(data []byte) error {
+	var plain plainBarometerSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = BarometerSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s RadiationSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainRadiationSensor(s), s.Extra)
+}
+
+func (s *RadiationSensor) UnmarshalJSON(data []byte) error {
+	var plain plainRadiationSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = RadiationSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s HumiditySensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainHumiditySensor(s), s.Extra)
+}
+
+func (s *HumiditySensor) UnmarshalJSON(data []byte) error {
+	var plain plainHumiditySensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = HumiditySensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s BeverageSupplySensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainBeverageSupplySensor(s), s.Extra)
+}
+
+func (s *BeverageSupplySensor) UnmarshalJSON(data []byte) error {
+	var plain plainBeverageSupplySensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = BeverageSupplySensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s PowerSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainPowerSensor(s), s.Extra)
+}
+
+func (s *PowerSensor) UnmarshalJSON(data []byte) error {
+	var plain plainPowerSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = PowerSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s WindSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainWindSensor(s), s.Extra)
+}
+
+func (s *WindSensor) UnmarshalJSON(data []byte) error {
+	var plain plainWindSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = WindSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s NetworkConnectionsSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainNetworkConnectionsSensor(s), s.Extra)
+}
+
+func (s *NetworkConnectionsSensor) UnmarshalJSON(data []byte) error {
+	var plain plainNetworkConnectionsSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = NetworkConnectionsSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s AccountBalanceSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainAccountBalanceSensor(s), s.Extra)
+}
+
+func (s *AccountBalanceSensor) UnmarshalJSON(data []byte) error {
+	var plain plainAccountBalanceSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = AccountBalanceSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s TotalMemberCountSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainTotalMemberCountSensor(s), s.Extra)
+}
+
+func (s *TotalMemberCountSensor) UnmarshalJSON(data []byte) error {
+	var plain plainTotalMemberCountSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = TotalMemberCountSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s PeopleNowPresentSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainPeopleNowPresentSensor(s), s.Extra)
+}
+
+func (s *PeopleNowPresentSensor) UnmarshalJSON(data []byte) error {
+	var plain plainPeopleNowPresentSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = PeopleNowPresentSensor(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (s NetworkTrafficSensor) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainNetworkTrafficSensor(s), s.Extra)
+}
+
+func (s *NetworkTrafficSensor) UnmarshalJSON(data []byte) error {
+	var plain plainNetworkTrafficSensor
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = NetworkTrafficSensor(plain)
+	s.Extra = extra
+	return nil
+}
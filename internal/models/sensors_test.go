@@ -0,0 +1,164 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SensorsModelTestSuite struct {
+	suite.Suite
+}
+
+func TestSensorsModelTestSuite(t *testing.T) {
+	suite.Run(t, new(SensorsModelTestSuite))
+}
+
+// specSensors follows the sensor examples of the SpaceAPI v15 documentation
+const specSensors = `{
+	"temperature": [
+		{"value": 23.5, "unit": "°C", "location": "Roof", "name": "Weather station", "description": "Outside", "lastchange": 1700000000}
+	],
+	"carbondioxide": [
+		{"value": 612, "unit": "ppm", "location": "Main room"}
+	],
+	"door_locked": [
+		{"value": true, "location": "Front door"}
+	],
+	"barometer": [
+		{"value": 1013.25, "unit": "hPa", "location": "Roof"}
+	],
+	"radiation": {
+		"alpha": [{"value": 0.7, "unit": "cpm", "dead_time": 0.00012, "conversion_factor": 0.0057, "location": "Lab"}],
+		"beta_gamma": [{"value": 0.11, "unit": "µSv/h"}]
+	},
+	"humidity": [
+		{"value": 46, "unit": "%", "location": "Workshop"}
+	],
+	"beverage_supply": [
+		{"value": 12, "unit": "crt", "location": "Kitchen"}
+	],
+	"power_consumption": [
+		{"value": 1250, "unit": "W", "location": "Total"}
+	],
+	"power_generation": [
+		{"value": 830, "unit": "W", "location": "Roof", "name": "Solar"}
+	],
+	"wind": [
+		{
+			"properties": {
+				"speed": {"value": 3.1, "unit": "m/s"},
+				"gust": {"value": 7.4, "unit": "m/s"},
+				"direction": {"value": 225, "unit": "°"},
+				"elevation": {"value": 35, "unit": "m"}
+			},
+			"location": "Roof"
+		}
+	],
+	"network_connections": [
+		{"type": "wifi", "value": 2, "machines": [{"name": "hal", "mac": "00:11:22:33:44:55"}, {"mac": "66:77:88:99:aa:bb"}], "location": "Main room"}
+	],
+	"account_balance": [
+		{"value": 1337.42, "unit": "EUR", "location": "Bank", "name": "Main account"}
+	],
+	"total_member_count": [
+		{"value": 42}
+	],
+	"people_now_present": [
+		{"value": 2, "names": ["alice", "bob"], "location": "Main room"}
+	],
+	"network_traffic": [
+		{
+			"properties": {
+				"bits_per_second": {"value": 4500000, "maximum": 100000000},
+				"packets_per_second": {"value": 850}
+			},
+			"location": "Uplink"
+		}
+	],
+	"ext_plant_moisture": [
+		{"value": 40, "unit": "%"}
+	]
+}`
+
+func (suite *SensorsModelTestSuite) TestRoundTrip() {
+	var sensors Sensors
+	suite.Require().NoError(json.Unmarshal([]byte(specSensors), &sensors))
+
+	data, err := json.Marshal(sensors)
+	suite.Require().NoError(err)
+	suite.Assert().JSONEq(specSensors, string(data))
+}
+
+func (suite *SensorsModelTestSuite) TestRoundTrip_ReadingExtensions() {
+	doc := `{
+		"temperature": [{"value": 21, "unit": "°C", "location": "Lab", "ext_foo": {"calibrated": true}}],
+		"radiation": {"ext_kind": "geiger", "alpha": [{"value": 0.5, "unit": "cpm", "ext_tube": "SBM-20"}]}
+	}`
+
+	var sensors Sensors
+	suite.Require().NoError(json.Unmarshal([]byte(doc), &sensors))
+	suite.Assert().Equal("Lab", sensors.Temperature[0].Location)
+	suite.Assert().JSONEq(`{"calibrated": true}`, string(sensors.Temperature[0].Extra["ext_foo"]))
+	suite.Assert().NotContains(sensors.Temperature[0].Extra, "location")
+
+	data, err := json.Marshal(sensors)
+	suite.Require().NoError(err)
+	suite.Assert().JSONEq(doc, string(data))
+}
+
+func (suite *SensorsModelTestSuite) TestTypedFields() {
+	var sensors Sensors
+	suite.Require().NoError(json.Unmarshal([]byte(specSensors), &sensors))
+
+	suite.Assert().Equal(23.5, sensors.Temperature[0].Value)
+	suite.Assert().Equal("Roof", sensors.Temperature[0].Location)
+	suite.Assert().Equal(int64(1700000000), sensors.Temperature[0].Lastchange)
+	suite.Assert().True(sensors.DoorLocked[0].Value)
+	suite.Assert().Equal(0.00012, *sensors.Radiation.Alpha[0].DeadTime)
+	suite.Assert().Nil(sensors.Radiation.BetaGamma[0].DeadTime)
+	suite.Assert().Equal(12, sensors.BeverageSupply[0].Value)
+	suite.Assert().Equal("Solar", sensors.PowerGeneration[0].Name)
+	suite.Assert().Equal(Measurement{Value: 7.4, Unit: "m/s"}, sensors.Wind[0].Properties.Gust)
+	suite.Assert().Equal(225.0, sensors.Wind[0].Properties.Direction.Value)
+	suite.Assert().Equal("00:11:22:33:44:55", sensors.NetworkConnections[0].Machines[0].MAC)
+	suite.Assert().Equal(42, sensors.TotalMemberCount[0].Value)
+	suite.Assert().Equal([]string{"alice", "bob"}, sensors.PeopleNowPresent[0].Names)
+	suite.Assert().Equal(100000000.0, *sensors.NetworkTraffic[0].Properties.BitsPerSecond.Maximum)
+	suite.Assert().Equal(850.0, sensors.NetworkTraffic[0].Properties.PacketsPerSecond.Value)
+	suite.Assert().Contains(sensors.Extra, "ext_plant_moisture")
+}
+
+func (suite *SensorsModelTestSuite) TestTypeMismatch() {
+	var sensors Sensors
+
+	err := json.Unmarshal([]byte(`{"door_locked": [{"value": "yes"}]}`), &sensors)
+	suite.Assert().Error(err)
+
+	err = json.Unmarshal([]byte(`{"people_now_present": [{"value": 1.5}]}`), &sensors)
+	suite.Assert().Error(err)
+}
+
+func (suite *SensorsModelTestSuite) TestMeta() {
+	reading := TemperatureSensor{Value: 20, Unit: "°C"}
+	var sensor Sensor = &reading
+
+	sensor.Meta().Location = "Lab"
+	suite.Assert().Equal("Lab", reading.Location)
+}
@@ -33,16 +33,18 @@ type SpaceAPI struct {
 	Links            []Link           `json:"links,omitempty"`
 	MembershipPlans  []MembershipPlan `json:"membership_plans,omitempty"`
 	LinkedSpaces     []LinkedSpace    `json:"linked_spaces,omitempty"`
+	Extra            Extra            `json:"-"`
 }
 
 type Location struct {
 	Address     string  `json:"address,omitempty"`
-	Lat         float64 `json:"lat,omitempty"`
-	Lon         float64 `json:"lon,omitempty"`
+	Lat         float64 `json:"lat"`
+	Lon         float64 `json:"lon"`
 	Timezone    string  `json:"timezone,omitempty"`
 	CountryCode string  `json:"country_code,omitempty"`
 	Hint        string  `json:"hint,omitempty"`
 	Areas       []Area  `json:"areas,omitempty"`
+	Extra       Extra   `json:"-"`
 }
 
 type Area struct {
@@ -62,6 +64,7 @@ type State struct {
 	TriggerPerson string `json:"trigger_person,omitempty"`
 	Message       string `json:"message,omitempty"`
 	Icon          *Icon  `json:"icon,omitempty"`
+	Extra         Extra  `json:"-"`
 }
 
 type Icon struct {
@@ -90,6 +93,7 @@ type Contact struct {
 	ML         string      `json:"ml,omitempty"`
 	XMPP       string      `json:"xmpp,omitempty"`
 	IssueMail  string      `json:"issue_mail,omitempty"`
+	Extra      Extra       `json:"-"`
 }
 
 type Keymaster struct {
@@ -103,31 +107,6 @@ type Keymaster struct {
 	Matrix   string `json:"matrix,omitempty"`
 }
 
-type Sensors struct {
-	Temperature        []SensorValue `json:"temperature,omitempty"`
-	DoorLocked         []SensorValue `json:"door_locked,omitempty"`
-	Barometer          []SensorValue `json:"barometer,omitempty"`
-	Radiation          []SensorValue `json:"radiation,omitempty"`
-	Humidity           []SensorValue `json:"humidity,omitempty"`
-	BeverageSupply     []SensorValue `json:"beverage_supply,omitempty"`
-	PowerConsumption   []SensorValue `json:"power_consumption,omitempty"`
-	Wind               []SensorValue `json:"wind,omitempty"`
-	NetworkConnections []SensorValue `json:"network_connections,omitempty"`
-	AccountBalance     []SensorValue `json:"account_balance,omitempty"`
-	TotalMemberCount   []SensorValue `json:"total_member_count,omitempty"`
-	PeopleNowPresent   []SensorValue `json:"people_now_present,omitempty"`
-	NetworkTraffic     []SensorValue `json:"network_traffic,omitempty"`
-}
-
-type SensorValue struct {
-	Value       interface{} `json:"value"`
-	Unit        string      `json:"unit,omitempty"`
-	Location    string      `json:"location,omitempty"`
-	Name        string      `json:"name,omitempty"`
-	Description string      `json:"description,omitempty"`
-	Lastchange  int64       `json:"lastchange,omitempty"`
-}
-
 type Feeds struct {
 	Blog     *Feed `json:"blog,omitempty"`
 	Wiki     *Feed `json:"wiki,omitempty"`
@@ -159,6 +138,75 @@ type LinkedSpace struct {
 	Website  string `json:"website,omitempty"`
 }
 
+// The plain* types have the same fields but none of the methods, so the
+// custom (un)marshalers below can use the default encoding without recursion
+type (
+	plainSpaceAPI SpaceAPI
+	plainLocation Location
+	plainState    State
+	plainContact  Contact
+)
+
+func (s SpaceAPI) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainSpaceAPI(s), s.Extra)
+}
+
+func (s *SpaceAPI) UnmarshalJSON(data []byte) error {
+	var plain plainSpaceAPI
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = SpaceAPI(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (l Location) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainLocation(l), l.Extra)
+}
+
+func (l *Location) UnmarshalJSON(data []byte) error {
+	var plain plainLocation
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*l = Location(plain)
+	l.Extra = extra
+	return nil
+}
+
+func (s State) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainState(s), s.Extra)
+}
+
+func (s *State) UnmarshalJSON(data []byte) error {
+	var plain plainState
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*s = State(plain)
+	s.Extra = extra
+	return nil
+}
+
+func (c Contact) MarshalJSON() ([]byte, error) {
+	return marshalWithExtra(plainContact(c), c.Extra)
+}
+
+func (c *Contact) UnmarshalJSON(data []byte) error {
+	var plain plainContact
+	extra, err := unmarshalWithExtra(data, &plain)
+	if err != nil {
+		return err
+	}
+	*c = Contact(plain)
+	c.Extra = extra
+	return nil
+}
+
 // Helper function to create a bool pointer
 func BoolPtr(b bool) *bool {
 	return &b
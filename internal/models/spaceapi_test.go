@@ -0,0 +1,120 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package models
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SpaceAPIModelTestSuite struct {
+	suite.Suite
+}
+
+func TestSpaceAPIModelTestSuite(t *testing.T) {
+	suite.Run(t, new(SpaceAPIModelTestSuite))
+}
+
+// roundTrip decodes doc into a SpaceAPI and encodes it again
+func (suite *SpaceAPIModelTestSuite) roundTrip(doc string) string {
+	var spaceAPI SpaceAPI
+	suite.Require().NoError(json.Unmarshal([]byte(doc), &spaceAPI))
+	data, err := json.Marshal(spaceAPI)
+	suite.Require().NoError(err)
+	return string(data)
+}
+
+func (suite *SpaceAPIModelTestSuite) TestExtra_ExampleDocument() {
+	data, err := os.ReadFile(filepath.Join("..", "..", "spaceapi.json.example"))
+	suite.Require().NoError(err)
+
+	var spaceAPI SpaceAPI
+	suite.Require().NoError(json.Unmarshal(data, &spaceAPI))
+	suite.Assert().JSONEq(`["email"]`, string(spaceAPI.Extra["issue_report_channels"]))
+
+	out, err := json.Marshal(spaceAPI)
+	suite.Require().NoError(err)
+
+	var expected, actual map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(data, &expected))
+	suite.Require().NoError(json.Unmarshal(out, &actual))
+	// A zero lastchange means "unknown" and is omitted
+	delete(expected["state"].(map[string]interface{}), "lastchange")
+	suite.Assert().Equal(expected, actual)
+}
+
+func (suite *SpaceAPIModelTestSuite) TestExtra_TopLevel() {
+	doc := `{
+		"api_compatibility": ["14", "15"],
+		"api": "0.13",
+		"space": "Test Space",
+		"logo": "https://example.com/logo.png",
+		"url": "https://example.com",
+		"contact": {},
+		"ext_ccc": "chaostreff",
+		"ext_habitat": {"rooms": 3, "nested": [1, 2]}
+	}`
+
+	suite.Assert().JSONEq(doc, suite.roundTrip(doc))
+}
+
+func (suite *SpaceAPIModelTestSuite) TestExtra_Nested() {
+	doc := `{
+		"api_compatibility": ["15"],
+		"space": "Test Space",
+		"logo": "https://example.com/logo.png",
+		"url": "https://example.com",
+		"location": {"lat": 1.5, "lon": 2.5, "ext_floor": 3},
+		"state": {"open": true, "ext_door": "side entrance"},
+		"contact": {"email": "test@example.com", "ext_signal": "+123", "matrix": "#space:example.com"},
+		"sensors": {
+			"people_now_present": [{"value": 2}],
+			"ext_plant_moisture": [{"value": 40, "unit": "%"}]
+		}
+	}`
+
+	suite.Assert().JSONEq(doc, suite.roundTrip(doc))
+}
+
+func (suite *SpaceAPIModelTestSuite) TestExtra_KnownFieldsNotDuplicated() {
+	var state State
+	suite.Require().NoError(json.Unmarshal([]byte(`{"Open": true, "MESSAGE": "hi"}`), &state))
+
+	suite.Assert().Empty(state.Extra)
+	suite.Assert().True(*state.Open)
+	suite.Assert().Equal("hi", state.Message)
+}
+
+func (suite *SpaceAPIModelTestSuite) TestExtra_ModelWinsOverExtra() {
+	state := State{
+		Open:  BoolPtr(false),
+		Extra: Extra{"open": json.RawMessage("true"), "ext_a": json.RawMessage(`"b"`)},
+	}
+
+	data, err := json.Marshal(state)
+	suite.Require().NoError(err)
+	suite.Assert().JSONEq(`{"open": false, "ext_a": "b"}`, string(data))
+}
+
+func (suite *SpaceAPIModelTestSuite) TestExtra_EmptyObject() {
+	data, err := json.Marshal(Contact{Extra: Extra{"ext_a": json.RawMessage("1")}})
+	suite.Require().NoError(err)
+	suite.Assert().JSONEq(`{"ext_a": 1}`, string(data))
+}
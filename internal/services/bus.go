@@ -0,0 +1,241 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Kinds of changes published on the bus
+const (
+	ChangeState  = "state"
+	ChangePeople = "people"
+	ChangeSensor = "sensor"
+	ChangeEvent  = "event"
+	ChangeReload = "reload"
+	ChangePatch  = "patch"
+)
+
+// DefaultBusHistory is how many changes are kept for subscribers resuming
+// after a disconnect
+const DefaultBusHistory = 100
+
+// subscriberBuffer is how many changes may queue up for a subscriber before
+// it is considered too slow and dropped
+const subscriberBuffer = 64
+
+// followerBuffer is how many changes may queue up for a follower before
+// publishing waits for it. It is large enough to absorb a slow disk write
+// without holding up updates.
+const followerBuffer = 1024
+
+// Change describes a committed modification of the document
+type Change struct {
+	ID   uint64
+	Kind string
+	Time time.Time
+	// Data is the JSON-encodable value after the change
+	Data interface{}
+	// Previous is the value before the change, if known, so subscribers can
+	// detect transitions
+	Previous interface{}
+	// Source names the API key that made the change, if any
+	Source string
+}
+
+// Bus distributes document changes to subscribers and keeps a short history
+// so subscribers can catch up on changes they missed
+type Bus struct {
+	publishMu   sync.Mutex // serializes publishers while followers catch up
+	mutex       sync.Mutex
+	lastID      uint64
+	history     []Change
+	historySize int
+	subscribers map[chan Change]struct{}
+	followers   map[*follower]struct{}
+}
+
+// follower is an internal subscriber registered with Follow
+type follower struct {
+	ch   chan Change
+	stop <-chan struct{}
+}
+
+// NewBus creates a bus remembering the last historySize changes
+func NewBus(historySize int) *Bus {
+	return &Bus{
+		historySize: historySize,
+		subscribers: make(map[chan Change]struct{}),
+		followers:   make(map[*follower]struct{}),
+	}
+}
+
+// SensorChange is the data of a ChangeSensor change
+type SensorChange struct {
+	Type     string      `json:"type"`
+	Reading  interface{} `json:"reading"`
+	Readings interface{} `json:"readings"`
+}
+
+// Publish assigns the next ID to a change and delivers it to all
+// subscribers. Subscribers that cannot keep up are disconnected; followers
+// that cannot keep up make Publish wait for them.
+func (b *Bus) Publish(kind string, data, previous interface{}) Change {
+	return b.PublishFrom("", kind, data, previous)
+}
+
+// PublishFrom publishes a change made with the API key named source
+func (b *Bus) PublishFrom(source, kind string, data, previous interface{}) Change {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	change, followers := b.deliver(source, kind, data, previous)
+	for _, f := range followers {
+		select {
+		case f.ch <- change:
+		case <-f.stop:
+		}
+	}
+	return change
+}
+
+// deliver records a change and hands it to the subscribers. It returns the
+// change and the followers still to be given it.
+func (b *Bus) deliver(source, kind string, data, previous interface{}) (Change, []*follower) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.lastID++
+	change := Change{
+		ID:       b.lastID,
+		Kind:     kind,
+		Time:     time.Now(),
+		Data:     data,
+		Previous: previous,
+		Source:   source,
+	}
+
+	b.history = append(b.history, change)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- change:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	followers := make([]*follower, 0, len(b.followers))
+	for f := range b.followers {
+		followers = append(followers, f)
+	}
+	return change, followers
+}
+
+// LastID returns the ID of the most recent change
+func (b *Bus) LastID() uint64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.lastID
+}
+
+// Subscription receives the changes published after it was created
+type Subscription struct {
+	// Missed holds the remembered changes after the ID passed to Subscribe
+	Missed []Change
+	// Complete reports whether Missed holds every change after that ID
+	Complete bool
+	// LastID is the ID of the latest change when subscribing
+	LastID uint64
+	// Changes delivers later changes. It is closed when the subscriber is
+	// dropped for falling behind.
+	Changes <-chan Change
+
+	bus *Bus
+	ch  chan Change
+}
+
+// Subscribe registers a subscriber interested in the changes after afterID
+func (b *Bus) Subscribe(afterID uint64) *Subscription {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var missed []Change
+	for _, change := range b.history {
+		if change.ID > afterID {
+			missed = append(missed, change)
+		}
+	}
+	// The replay is complete if nothing between afterID and the oldest
+	// remembered change was forgotten. IDs beyond lastID were handed out
+	// before a restart and cannot be resumed.
+	complete := afterID == b.lastID ||
+		(afterID < b.lastID && len(b.history) > 0 && b.history[0].ID <= afterID+1)
+
+	ch := make(chan Change, subscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	return &Subscription{
+		Missed:   missed,
+		Complete: complete,
+		LastID:   b.lastID,
+		Changes:  ch,
+		bus:      b,
+		ch:       ch,
+	}
+}
+
+// Close unsubscribes
+func (s *Subscription) Close() {
+	s.bus.mutex.Lock()
+	defer s.bus.mutex.Unlock()
+	if _, ok := s.bus.subscribers[s.ch]; ok {
+		delete(s.bus.subscribers, s.ch)
+		close(s.ch)
+	}
+}
+
+// Follow calls fn, from a new goroutine, for every change published after
+// Follow returns until stop is closed. Each follower has its own queue, so a
+// slow fn does not hold up publishing. Unlike subscribers, followers are
+// never dropped: once the queue is full, publishing waits for fn, so
+// internal consumers such as webhooks and histories see every change.
+// Closing stop releases publishers waiting for the follower.
+func (b *Bus) Follow(stop <-chan struct{}, fn func(Change)) {
+	f := &follower{ch: make(chan Change, followerBuffer), stop: stop}
+	b.mutex.Lock()
+	b.followers[f] = struct{}{}
+	b.mutex.Unlock()
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				b.mutex.Lock()
+				delete(b.followers, f)
+				b.mutex.Unlock()
+				return
+			case change := <-f.ch:
+				fn(change)
+			}
+		}
+	}()
+}
@@ -0,0 +1,188 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type BusTestSuite struct {
+	suite.Suite
+	bus *Bus
+}
+
+func (suite *BusTestSuite) SetupTest() {
+	suite.bus = NewBus(3)
+}
+
+func TestBusTestSuite(t *testing.T) {
+	suite.Run(t, new(BusTestSuite))
+}
+
+func (suite *BusTestSuite) TestPublish_DeliversToSubscribers() {
+	sub := suite.bus.Subscribe(0)
+	defer sub.Close()
+
+	suite.bus.Publish(ChangeEvent, "first", nil)
+	suite.bus.Publish(ChangeState, "second", "before")
+
+	first := <-sub.Changes
+	second := <-sub.Changes
+	suite.Assert().Equal(uint64(1), first.ID)
+	suite.Assert().Equal(ChangeEvent, first.Kind)
+	suite.Assert().Equal(uint64(2), second.ID)
+	suite.Assert().Equal("second", second.Data)
+	suite.Assert().Equal("before", second.Previous)
+	suite.Assert().Empty(second.Source)
+}
+
+func (suite *BusTestSuite) TestPublishFrom_RecordsSource() {
+	change := suite.bus.PublishFrom("door", ChangeState, "open", nil)
+
+	suite.Assert().Equal("door", change.Source)
+}
+
+func (suite *BusTestSuite) TestSubscribe_ReplaysMissedChanges() {
+	suite.bus.Publish(ChangeEvent, "one", nil)
+	suite.bus.Publish(ChangeEvent, "two", nil)
+	suite.bus.Publish(ChangeEvent, "three", nil)
+
+	sub := suite.bus.Subscribe(1)
+	defer sub.Close()
+
+	suite.Assert().True(sub.Complete)
+	suite.Assert().Equal(uint64(3), sub.LastID)
+	suite.Require().Len(sub.Missed, 2)
+	suite.Assert().Equal("two", sub.Missed[0].Data)
+	suite.Assert().Equal("three", sub.Missed[1].Data)
+}
+
+func (suite *BusTestSuite) TestSubscribe_IncompleteWhenHistoryIsGone() {
+	for i := 0; i < 5; i++ {
+		suite.bus.Publish(ChangeEvent, i, nil)
+	}
+
+	sub := suite.bus.Subscribe(1)
+	defer sub.Close()
+	suite.Assert().False(sub.Complete)
+	suite.Assert().Len(sub.Missed, 3)
+
+	// The oldest remembered change directly follows the last one seen
+	resumed := suite.bus.Subscribe(2)
+	defer resumed.Close()
+	suite.Assert().True(resumed.Complete)
+}
+
+func (suite *BusTestSuite) TestSubscribe_UnknownIDFromEarlierRun() {
+	suite.bus.Publish(ChangeEvent, "one", nil)
+
+	sub := suite.bus.Subscribe(42)
+	defer sub.Close()
+	suite.Assert().False(sub.Complete)
+	suite.Assert().Empty(sub.Missed)
+}
+
+func (suite *BusTestSuite) TestPublish_DropsSlowSubscribers() {
+	sub := suite.bus.Subscribe(0)
+	defer sub.Close()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		suite.bus.Publish(ChangeEvent, i, nil)
+	}
+
+	received := 0
+	for range sub.Changes {
+		received++
+	}
+	suite.Assert().Equal(subscriberBuffer, received)
+}
+
+func (suite *BusTestSuite) TestClose_StopsDelivery() {
+	sub := suite.bus.Subscribe(0)
+	sub.Close()
+	sub.Close()
+
+	suite.bus.Publish(ChangeEvent, "ignored", nil)
+	_, ok := <-sub.Changes
+	suite.Assert().False(ok)
+}
+
+func (suite *BusTestSuite) TestFollow_SlowFollowerMissesNothing() {
+	bus := NewBus(10)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	const changes = followerBuffer + 10
+	release := make(chan struct{})
+	received := make(chan int, changes)
+	bus.Follow(stop, func(change Change) {
+		<-release
+		received <- change.Data.(int)
+	})
+
+	// The follower blocks on the first change until the buffer fills up,
+	// then publishing waits for it
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < changes; i++ {
+			bus.Publish(ChangeEvent, i, nil)
+		}
+	}()
+	select {
+	case <-published:
+		suite.FailNow("publishing did not wait for the follower")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	for i := 0; i < changes; i++ {
+		select {
+		case value := <-received:
+			suite.Require().Equal(i, value)
+		case <-time.After(time.Second):
+			suite.FailNow("follower did not catch up", "stopped after %d changes", i)
+		}
+	}
+	<-published
+}
+
+func (suite *BusTestSuite) TestFollow_StoppedFollowerDoesNotBlock() {
+	bus := NewBus(DefaultBusHistory)
+	stop := make(chan struct{})
+	blocked := make(chan struct{})
+	defer close(blocked)
+	bus.Follow(stop, func(Change) {
+		<-blocked
+	})
+	close(stop)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 2*followerBuffer; i++ {
+			bus.Publish(ChangeEvent, i, nil)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		suite.FailNow("publishing blocked on a stopped follower")
+	}
+}
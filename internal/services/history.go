@@ -0,0 +1,291 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// StateTransition records the space opening or closing
+type StateTransition struct {
+	Timestamp     int64  `json:"timestamp"`
+	Open          bool   `json:"open"`
+	Message       string `json:"message,omitempty"`
+	TriggerPerson string `json:"trigger_person,omitempty"`
+	// Source names the API key that made the change
+	Source string `json:"source,omitempty"`
+}
+
+// StateHistory keeps every open/close transition in memory and appends it to
+// a JSON Lines file, so the history survives restarts
+type StateHistory struct {
+	path string
+
+	mutex       sync.RWMutex
+	transitions []StateTransition
+}
+
+// NewStateHistory loads the history kept at path. An empty path keeps the
+// history in memory only.
+func NewStateHistory(path string) (*StateHistory, error) {
+	h := &StateHistory{path: path}
+	err := readJournal(path, func(line []byte) error {
+		var transition StateTransition
+		if err := json.Unmarshal(line, &transition); err != nil {
+			return err
+		}
+		h.insert(transition)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load state history: %w", err)
+	}
+	return h, nil
+}
+
+// Watch records every open/close transition published on bus until stop is
+// closed
+func (h *StateHistory) Watch(bus *Bus, stop <-chan struct{}) {
+	bus.Follow(stop, func(change Change) {
+		if change.Kind != ChangeState {
+			return
+		}
+		state, _ := change.Data.(models.State)
+		previous, _ := change.Previous.(models.State)
+		if !isTransition(previous, state) {
+			return
+		}
+		if err := h.Record(StateTransition{
+			Timestamp:     state.Lastchange,
+			Open:          *state.Open,
+			Message:       state.Message,
+			TriggerPerson: state.TriggerPerson,
+			Source:        change.Source,
+		}); err != nil {
+			log.Printf("Error recording state history: %v", err)
+		}
+	})
+}
+
+// Record adds transition to the history and appends it to the file
+func (h *StateHistory) Record(transition StateTransition) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.insert(transition)
+	return appendJournal(h.path, transition)
+}
+
+// insert adds transition in timestamp order. The mutex must be held.
+func (h *StateHistory) insert(transition StateTransition) {
+	i := sort.Search(len(h.transitions), func(i int) bool {
+		return h.transitions[i].Timestamp > transition.Timestamp
+	})
+	h.transitions = append(h.transitions, StateTransition{})
+	copy(h.transitions[i+1:], h.transitions[i:])
+	h.transitions[i] = transition
+}
+
+// Between returns the transitions from from up to, but excluding, to, oldest
+// first. A zero from or to leaves that end open.
+func (h *StateHistory) Between(from, to time.Time) []StateTransition {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(h.transitions), func(i int) bool {
+			return h.transitions[i].Timestamp >= from.Unix()
+		})
+	}
+	end := len(h.transitions)
+	if !to.IsZero() {
+		end = sort.Search(len(h.transitions), func(i int) bool {
+			return h.transitions[i].Timestamp >= to.Unix()
+		})
+	}
+	if start >= end {
+		return []StateTransition{}
+	}
+	return append([]StateTransition(nil), h.transitions[start:end]...)
+}
+
+// Latest returns up to n of the most recent transitions, newest first
+func (h *StateHistory) Latest(n int) []StateTransition {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	n = min(n, len(h.transitions))
+	latest := make([]StateTransition, 0, n)
+	for i := len(h.transitions) - 1; i >= len(h.transitions)-n; i-- {
+		latest = append(latest, h.transitions[i])
+	}
+	return latest
+}
+
+// RecordedEvent is an event added to the document, kept after it dropped out
+// of the events list
+type RecordedEvent struct {
+	models.Event
+	// Source names the API key that added the event
+	Source string `json:"source,omitempty"`
+}
+
+// EventHistory keeps every event added to the document in memory and appends
+// it to a JSON Lines file, as the document only holds the latest events
+type EventHistory struct {
+	path string
+
+	mutex  sync.RWMutex
+	events []RecordedEvent
+}
+
+// NewEventHistory loads the history kept at path. An empty path keeps the
+// history in memory only.
+func NewEventHistory(path string) (*EventHistory, error) {
+	h := &EventHistory{path: path}
+	err := readJournal(path, func(line []byte) error {
+		var event RecordedEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return err
+		}
+		h.insert(event)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load event history: %w", err)
+	}
+	return h, nil
+}
+
+// Watch records every event published on bus until stop is closed
+func (h *EventHistory) Watch(bus *Bus, stop <-chan struct{}) {
+	bus.Follow(stop, func(change Change) {
+		if change.Kind != ChangeEvent {
+			return
+		}
+		event, ok := change.Data.(models.Event)
+		if !ok {
+			return
+		}
+		if err := h.Record(RecordedEvent{Event: event, Source: change.Source}); err != nil {
+			log.Printf("Error recording event history: %v", err)
+		}
+	})
+}
+
+// Record adds event to the history and appends it to the file
+func (h *EventHistory) Record(event RecordedEvent) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.insert(event)
+	return appendJournal(h.path, event)
+}
+
+// insert adds event in timestamp order. The mutex must be held.
+func (h *EventHistory) insert(event RecordedEvent) {
+	i := sort.Search(len(h.events), func(i int) bool {
+		return h.events[i].Timestamp > event.Timestamp
+	})
+	h.events = append(h.events, RecordedEvent{})
+	copy(h.events[i+1:], h.events[i:])
+	h.events[i] = event
+}
+
+// Latest returns up to n of the most recent events, newest first
+func (h *EventHistory) Latest(n int) []RecordedEvent {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	n = min(n, len(h.events))
+	latest := make([]RecordedEvent, 0, n)
+	for i := len(h.events) - 1; i >= len(h.events)-n; i-- {
+		latest = append(latest, h.events[i])
+	}
+	return latest
+}
+
+// readJournal calls parse with every line of the JSON Lines file at path.
+// Lines parse rejects are skipped with a warning. A crash while appending
+// leaves a partial last line behind, which is cut off so the next append
+// starts on a line of its own. A missing file is an empty journal.
+func readJournal(path string, parse func(line []byte) error) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	if end := bytes.LastIndexByte(data, '\n') + 1; end < len(data) {
+		log.Printf("WARNING: Dropping partial last line of %s", path)
+		if err := os.Truncate(path, int64(end)); err != nil {
+			return fmt.Errorf("could not repair %s: %w", path, err)
+		}
+		data = data[:end]
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := parse(scanner.Bytes()); err != nil {
+			log.Printf("WARNING: Skipping line %d of %s: %v", line, path, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// appendJournal appends v as a line to the JSON Lines file at path. An empty
+// path does nothing.
+func appendJournal(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/stretchr/testify/suite"
+)
+
+type StateHistoryTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (suite *StateHistoryTestSuite) SetupTest() {
+	suite.path = filepath.Join(suite.T().TempDir(), "state-history.jsonl")
+}
+
+func TestStateHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(StateHistoryTestSuite))
+}
+
+func (suite *StateHistoryTestSuite) newHistory() *StateHistory {
+	history, err := NewStateHistory(suite.path)
+	suite.Require().NoError(err)
+	return history
+}
+
+func (suite *StateHistoryTestSuite) TestRecordAndBetween() {
+	history := suite.newHistory()
+	suite.Require().NoError(history.Record(StateTransition{Timestamp: 300, Open: false}))
+	suite.Require().NoError(history.Record(StateTransition{Timestamp: 100, Open: true, Message: "Hack night"}))
+	suite.Require().NoError(history.Record(StateTransition{Timestamp: 200, Open: false}))
+
+	all := history.Between(time.Time{}, time.Time{})
+	suite.Require().Len(all, 3)
+	suite.Assert().Equal(int64(100), all[0].Timestamp)
+	suite.Assert().Equal(int64(300), all[2].Timestamp)
+
+	// from is inclusive, to exclusive
+	some := history.Between(time.Unix(200, 0), time.Unix(300, 0))
+	suite.Require().Len(some, 1)
+	suite.Assert().Equal(int64(200), some[0].Timestamp)
+
+	suite.Assert().Empty(history.Between(time.Unix(400, 0), time.Time{}))
+}
+
+func (suite *StateHistoryTestSuite) TestLatest() {
+	history := suite.newHistory()
+	for _, timestamp := range []int64{200, 100, 300} {
+		suite.Require().NoError(history.Record(StateTransition{Timestamp: timestamp}))
+	}
+
+	latest := history.Latest(2)
+	suite.Require().Len(latest, 2)
+	suite.Assert().Equal(int64(300), latest[0].Timestamp)
+	suite.Assert().Equal(int64(200), latest[1].Timestamp)
+	suite.Assert().Len(history.Latest(10), 3)
+}
+
+func (suite *StateHistoryTestSuite) TestSurvivesRestart() {
+	history := suite.newHistory()
+	suite.Require().NoError(history.Record(StateTransition{Timestamp: 100, Open: true, TriggerPerson: "Ada", Source: "door"}))
+	suite.Require().NoError(history.Record(StateTransition{Timestamp: 200, Open: false}))
+
+	reloaded := suite.newHistory()
+	suite.Assert().Equal(history.Between(time.Time{}, time.Time{}), reloaded.Between(time.Time{}, time.Time{}))
+}
+
+func (suite *StateHistoryTestSuite) TestSkipsPartialLine() {
+	content := `{"timestamp": 100, "open": true}` + "\n" + `{"timestamp": 200, "op`
+	suite.Require().NoError(os.WriteFile(suite.path, []byte(content), 0644))
+
+	history := suite.newHistory()
+	suite.Assert().Len(history.Between(time.Time{}, time.Time{}), 1)
+}
+
+func (suite *StateHistoryTestSuite) TestRecordAfterPartialLine() {
+	content := `{"timestamp": 100, "open": true}` + "\n" + `{"timestamp": 200, "op`
+	suite.Require().NoError(os.WriteFile(suite.path, []byte(content), 0644))
+
+	history := suite.newHistory()
+	suite.Require().NoError(history.Record(StateTransition{Timestamp: 300, Open: false}))
+
+	reloaded := suite.newHistory().Between(time.Time{}, time.Time{})
+	suite.Require().Len(reloaded, 2)
+	suite.Assert().Equal(int64(100), reloaded[0].Timestamp)
+	suite.Assert().Equal(int64(300), reloaded[1].Timestamp)
+}
+
+func (suite *StateHistoryTestSuite) TestInMemory() {
+	history, err := NewStateHistory("")
+	suite.Require().NoError(err)
+	suite.Require().NoError(history.Record(StateTransition{Timestamp: 100, Open: true}))
+	suite.Assert().Len(history.Between(time.Time{}, time.Time{}), 1)
+}
+
+func (suite *StateHistoryTestSuite) TestWatchRecordsTransitions() {
+	history := suite.newHistory()
+	bus := NewBus(DefaultBusHistory)
+	stop := make(chan struct{})
+	defer close(stop)
+	history.Watch(bus, stop)
+
+	open, closed := true, false
+	bus.PublishFrom("door", ChangeState, models.State{Open: &open, Lastchange: 100, TriggerPerson: "Ada"}, models.State{Open: &closed})
+	// Only the message changes
+	bus.PublishFrom("door", ChangeState, models.State{Open: &open, Lastchange: 150, Message: "Pizza"}, models.State{Open: &open})
+	bus.Publish(ChangeEvent, models.Event{Name: "Ada"}, nil)
+	bus.PublishFrom("bot", ChangeState, models.State{Open: &closed, Lastchange: 200}, models.State{Open: &open})
+
+	suite.Require().Eventually(func() bool {
+		return len(history.Between(time.Time{}, time.Time{})) == 2
+	}, 2*time.Second, 5*time.Millisecond)
+	transitions := history.Between(time.Time{}, time.Time{})
+	suite.Assert().Equal(StateTransition{Timestamp: 100, Open: true, TriggerPerson: "Ada", Source: "door"}, transitions[0])
+	suite.Assert().Equal(StateTransition{Timestamp: 200, Open: false, Source: "bot"}, transitions[1])
+}
+
+type EventHistoryTestSuite struct {
+	suite.Suite
+	path string
+}
+
+func (suite *EventHistoryTestSuite) SetupTest() {
+	suite.path = filepath.Join(suite.T().TempDir(), "event-history.jsonl")
+}
+
+func TestEventHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(EventHistoryTestSuite))
+}
+
+func (suite *EventHistoryTestSuite) newHistory() *EventHistory {
+	history, err := NewEventHistory(suite.path)
+	suite.Require().NoError(err)
+	return history
+}
+
+func (suite *EventHistoryTestSuite) TestRecordAndLatest() {
+	history := suite.newHistory()
+	suite.Require().NoError(history.Record(RecordedEvent{Event: models.Event{Name: "Ada", Type: "check-in", Timestamp: 200}}))
+	suite.Require().NoError(history.Record(RecordedEvent{Event: models.Event{Name: "Bob", Type: "check-in", Timestamp: 100}}))
+	suite.Require().NoError(history.Record(RecordedEvent{Event: models.Event{Name: "Ada", Type: "check-out", Timestamp: 300}, Source: "door"}))
+
+	latest := history.Latest(2)
+	suite.Require().Len(latest, 2)
+	suite.Assert().Equal("check-out", latest[0].Type)
+	suite.Assert().Equal("door", latest[0].Source)
+	suite.Assert().Equal(int64(200), latest[1].Timestamp)
+	suite.Assert().Empty(history.Latest(0))
+}
+
+func (suite *EventHistoryTestSuite) TestSurvivesRestart() {
+	history := suite.newHistory()
+	for i := int64(1); i <= 12; i++ {
+		suite.Require().NoError(history.Record(RecordedEvent{Event: models.Event{Name: "Ada", Type: "check-in", Timestamp: i, Extra: "Hi"}}))
+	}
+
+	reloaded := suite.newHistory()
+	suite.Assert().Equal(history.Latest(100), reloaded.Latest(100))
+	suite.Assert().Len(reloaded.Latest(100), 12, "more events than the document keeps")
+}
+
+func (suite *EventHistoryTestSuite) TestSkipsPartialLine() {
+	content := `{"name": "Ada", "type": "check-in", "timestamp": 100}` + "\n" + `{"name": "Bo`
+	suite.Require().NoError(os.WriteFile(suite.path, []byte(content), 0644))
+
+	suite.Assert().Len(suite.newHistory().Latest(10), 1)
+}
+
+func (suite *EventHistoryTestSuite) TestWatchRecordsEvents() {
+	history := suite.newHistory()
+	bus := NewBus(DefaultBusHistory)
+	stop := make(chan struct{})
+	defer close(stop)
+	history.Watch(bus, stop)
+
+	open, closed := true, false
+	bus.PublishFrom("door", ChangeState, models.State{Open: &open, Lastchange: 100}, models.State{Open: &closed})
+	bus.PublishFrom("bot", ChangeEvent, models.Event{Name: "Ada", Type: "check-in", Timestamp: 150}, nil)
+
+	suite.Require().Eventually(func() bool {
+		return len(history.Latest(10)) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	suite.Assert().Equal(RecordedEvent{Event: models.Event{Name: "Ada", Type: "check-in", Timestamp: 150}, Source: "bot"}, history.Latest(10)[0])
+}
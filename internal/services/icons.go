@@ -0,0 +1,180 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for IconCache
+const (
+	DefaultIconTTL     = time.Hour
+	DefaultIconTimeout = 10 * time.Second
+)
+
+// MaxIconSize limits the size of a fetched icon
+const MaxIconSize = 1 << 20
+
+// Icon is a fetched state icon
+type Icon struct {
+	Data        []byte
+	ContentType string
+	Fetched     time.Time
+
+	// validator is the origin's ETag, used to revalidate the copy
+	validator string
+}
+
+// IconCache fetches the state icons so they can be served from this server.
+// Copies are refreshed after a TTL and kept if the origin becomes
+// unreachable.
+type IconCache struct {
+	client *http.Client
+	ttl    time.Duration
+	now    func() time.Time
+
+	mutex    sync.Mutex
+	icons    map[string]*Icon
+	inflight map[string]*iconFetch
+}
+
+// iconFetch is a fetch in progress, shared by all requests for its URL
+type iconFetch struct {
+	done chan struct{}
+	icon *Icon
+	err  error
+}
+
+// NewIconCache creates a cache keeping icons for ttl before revalidating them
+func NewIconCache(ttl time.Duration) *IconCache {
+	if ttl <= 0 {
+		ttl = DefaultIconTTL
+	}
+	return &IconCache{
+		client:   &http.Client{Timeout: DefaultIconTimeout},
+		ttl:      ttl,
+		now:      time.Now,
+		icons:    make(map[string]*Icon),
+		inflight: make(map[string]*iconFetch),
+	}
+}
+
+// Get returns the icon at iconURL, fetching it if it is not cached or has
+// expired. If fetching fails an expired copy is returned. Concurrent calls
+// for the same URL share a single fetch.
+func (c *IconCache) Get(ctx context.Context, iconURL string) (*Icon, error) {
+	c.mutex.Lock()
+	cached := c.icons[iconURL]
+	if cached != nil && c.now().Sub(cached.Fetched) < c.ttl {
+		c.mutex.Unlock()
+		return cached, nil
+	}
+	f, running := c.inflight[iconURL]
+	if !running {
+		f = &iconFetch{done: make(chan struct{})}
+		c.inflight[iconURL] = f
+	}
+	c.mutex.Unlock()
+
+	if running {
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			if cached != nil {
+				return cached, nil
+			}
+			return nil, ctx.Err()
+		}
+	} else {
+		// The fetch is shared, so one client going away must not cancel it
+		f.icon, f.err = c.fetch(context.WithoutCancel(ctx), iconURL, cached)
+		c.mutex.Lock()
+		if f.err == nil {
+			c.icons[iconURL] = f.icon
+		}
+		delete(c.inflight, iconURL)
+		c.mutex.Unlock()
+		close(f.done)
+	}
+
+	if f.err != nil {
+		if cached != nil {
+			return cached, nil
+		}
+		return nil, f.err
+	}
+	return f.icon, nil
+}
+
+// fetch downloads the icon at iconURL, revalidating cached if given
+func (c *IconCache) fetch(ctx context.Context, iconURL string, cached *Icon) (*Icon, error) {
+	u, err := url.Parse(iconURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid icon URL %q", iconURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, iconURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+	if cached != nil && cached.validator != "" {
+		req.Header.Set("If-None-Match", cached.validator)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch icon %s: %w", iconURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		refreshed := *cached
+		refreshed.Fetched = c.now()
+		return &refreshed, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch icon %s: status %d", iconURL, resp.StatusCode)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("icon %s is not an image but %q", iconURL, contentType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxIconSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch icon %s: %w", iconURL, err)
+	}
+	if len(data) > MaxIconSize {
+		return nil, errors.New("icon " + iconURL + " is larger than 1 MiB")
+	}
+
+	return &Icon{
+		Data:        data,
+		ContentType: contentType,
+		Fetched:     c.now(),
+		validator:   resp.Header.Get("ETag"),
+	}, nil
+}
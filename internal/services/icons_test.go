@@ -0,0 +1,166 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type IconCacheTestSuite struct {
+	suite.Suite
+	server      *httptest.Server
+	requests    atomic.Int32
+	revalidated atomic.Int32
+	down        atomic.Bool
+	release     chan struct{}
+	now         time.Time
+	cache       *IconCache
+}
+
+func (suite *IconCacheTestSuite) SetupTest() {
+	suite.requests.Store(0)
+	suite.revalidated.Store(0)
+	suite.down.Store(false)
+	suite.release = make(chan struct{})
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		suite.requests.Add(1)
+		if suite.down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		switch r.URL.Path {
+		case "/open.png":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				suite.revalidated.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte("png"))
+		case "/slow.png":
+			<-suite.release
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("png"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html>"))
+		case "/huge.png":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(bytes.Repeat([]byte("x"), MaxIconSize+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	suite.now = time.Now()
+	suite.cache = NewIconCache(time.Hour)
+	suite.cache.now = func() time.Time { return suite.now }
+}
+
+func (suite *IconCacheTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func TestIconCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(IconCacheTestSuite))
+}
+
+func (suite *IconCacheTestSuite) get(path string) (*Icon, error) {
+	return suite.cache.Get(context.Background(), suite.server.URL+path)
+}
+
+func (suite *IconCacheTestSuite) TestCachesIcon() {
+	icon, err := suite.get("/open.png")
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]byte("png"), icon.Data)
+	suite.Assert().Equal("image/png", icon.ContentType)
+
+	_, err = suite.get("/open.png")
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int32(1), suite.requests.Load())
+}
+
+func (suite *IconCacheTestSuite) TestRevalidatesAfterTTL() {
+	_, err := suite.get("/open.png")
+	suite.Require().NoError(err)
+
+	suite.now = suite.now.Add(2 * time.Hour)
+	icon, err := suite.get("/open.png")
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]byte("png"), icon.Data)
+	suite.Assert().Equal(int32(1), suite.revalidated.Load())
+
+	// The revalidated copy is fresh again
+	_, err = suite.get("/open.png")
+	suite.Require().NoError(err)
+	suite.Assert().Equal(int32(2), suite.requests.Load())
+}
+
+func (suite *IconCacheTestSuite) TestKeepsStaleCopyWhenOriginFails() {
+	_, err := suite.get("/open.png")
+	suite.Require().NoError(err)
+
+	suite.down.Store(true)
+	suite.now = suite.now.Add(2 * time.Hour)
+	icon, err := suite.get("/open.png")
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]byte("png"), icon.Data)
+}
+
+func (suite *IconCacheTestSuite) TestRejectsInvalidIcons() {
+	for _, path := range []string{"/missing.png", "/page.html", "/huge.png"} {
+		_, err := suite.get(path)
+		suite.Assert().Error(err, path)
+	}
+
+	_, err := suite.cache.Get(context.Background(), "file:///etc/passwd")
+	suite.Assert().ErrorContains(err, "invalid icon URL")
+}
+
+func (suite *IconCacheTestSuite) TestGet_SharesConcurrentFetches() {
+	const clients = 10
+	icons := make(chan *Icon, clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			icon, err := suite.cache.Get(context.Background(), suite.server.URL+"/slow.png")
+			suite.Assert().NoError(err)
+			icons <- icon
+		}()
+	}
+
+	// Let every client reach the cache before the origin answers
+	suite.Require().Eventually(func() bool {
+		return suite.requests.Load() == 1
+	}, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(suite.release)
+
+	first := <-icons
+	suite.Require().NotNil(first)
+	for i := 1; i < clients; i++ {
+		suite.Assert().Same(first, <-icons)
+	}
+	suite.Assert().Equal(int32(1), suite.requests.Load())
+}
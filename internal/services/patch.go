@@ -0,0 +1,442 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// Media types of document patches
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// RuntimeFields are the JSON pointers of the parts of the document owned by
+// the update endpoints. Patches may not change them.
+var RuntimeFields = []string{
+	"/state/open",
+	"/state/message",
+	"/state/trigger_person",
+	"/state/lastchange",
+	"/sensors",
+	"/events",
+}
+
+// PatchError reports a patch that is malformed or cannot be applied
+type PatchError struct {
+	Message string
+}
+
+func (e *PatchError) Error() string {
+	return e.Message
+}
+
+// ErrPatchTestFailed is returned when a "test" operation of a JSON Patch
+// does not match the document
+var ErrPatchTestFailed = errors.New("patch test operation failed")
+
+// ProtectedFieldError reports a patch changing a runtime-owned field
+type ProtectedFieldError struct {
+	Pointer string
+}
+
+func (e *ProtectedFieldError) Error() string {
+	return fmt.Sprintf("%s is updated through its own endpoint and cannot be patched", e.Pointer)
+}
+
+func patchErrorf(format string, args ...interface{}) error {
+	return &PatchError{Message: fmt.Sprintf(format, args...)}
+}
+
+// PatchSpaceAPI applies a patch of the given media type to spaceAPI in
+// place. Patches changing a RuntimeFields entry are rejected with
+// *ProtectedFieldError. The patched document is validated before it is
+// decoded, so nulls and values of the wrong type are reported as
+// *ValidationError instead of being coerced by the decoder.
+func PatchSpaceAPI(spaceAPI *models.SpaceAPI, mediaType string, patch []byte) error {
+	data, err := json.Marshal(spaceAPI)
+	if err != nil {
+		return err
+	}
+	document, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+	original, err := decodeJSON(data)
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case MergePatchType:
+		patchValue, err := decodeJSON(patch)
+		if err != nil {
+			return patchErrorf("invalid merge patch: %v", err)
+		}
+		document = mergePatch(document, patchValue)
+	case JSONPatchType:
+		var operations []patchOperation
+		if err := json.Unmarshal(patch, &operations); err != nil {
+			return patchErrorf("invalid JSON patch: %v", err)
+		}
+		for i, operation := range operations {
+			if document, err = operation.apply(document); err != nil {
+				var patchErr *PatchError
+				if errors.As(err, &patchErr) {
+					return patchErrorf("operation %d: %s", i, patchErr.Message)
+				}
+				return err
+			}
+		}
+	default:
+		return patchErrorf("unsupported patch type %q", mediaType)
+	}
+
+	for _, pointer := range RuntimeFields {
+		before, _ := getPointer(original, pointer)
+		after, _ := getPointer(document, pointer)
+		if !reflect.DeepEqual(before, after) {
+			return &ProtectedFieldError{Pointer: pointer}
+		}
+	}
+
+	if _, ok := document.(map[string]interface{}); !ok {
+		return patchErrorf("the patched document must be an object")
+	}
+	patched, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	if err := ValidateSpaceAPIJSON(patched); err != nil {
+		return err
+	}
+	var result models.SpaceAPI
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return patchErrorf("the patched document does not fit the SpaceAPI format: %v", err)
+	}
+	*spaceAPI = result
+	return nil
+}
+
+// decodeJSON decodes data keeping numbers exact
+func decodeJSON(data []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// mergePatch applies an RFC 7386 merge patch to target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = mergePatch(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
+
+// patchOperation is one operation of an RFC 6902 JSON Patch
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"` // not a pointer, to tell null from missing
+}
+
+// apply performs the operation on document and returns the result
+func (o patchOperation) apply(document interface{}) (interface{}, error) {
+	if o.Path == nil {
+		return nil, patchErrorf("missing path")
+	}
+	path := *o.Path
+
+	switch o.Op {
+	case "add", "replace", "test":
+		if o.Value == nil {
+			return nil, patchErrorf("%s needs a value", o.Op)
+		}
+		value, err := decodeJSON(o.Value)
+		if err != nil {
+			return nil, patchErrorf("invalid value: %v", err)
+		}
+		switch o.Op {
+		case "add":
+			return addPointer(document, path, value)
+		case "replace":
+			if _, err := getPointer(document, path); err != nil {
+				return nil, err
+			}
+			if path == "" {
+				return value, nil
+			}
+			document, err = removePointer(document, path)
+			if err != nil {
+				return nil, err
+			}
+			return addPointer(document, path, value)
+		default:
+			current, err := getPointer(document, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(normalizeNumbers(current), normalizeNumbers(value)) {
+				return nil, ErrPatchTestFailed
+			}
+			return document, nil
+		}
+	case "remove":
+		return removePointer(document, path)
+	case "move", "copy":
+		if o.From == nil {
+			return nil, patchErrorf("%s needs from", o.Op)
+		}
+		value, err := getPointer(document, *o.From)
+		if err != nil {
+			return nil, err
+		}
+		if o.Op == "move" {
+			if strings.HasPrefix(path, *o.From+"/") {
+				return nil, patchErrorf("cannot move %s into itself", *o.From)
+			}
+			if document, err = removePointer(document, *o.From); err != nil {
+				return nil, err
+			}
+		} else {
+			// Copies must not share nested values with the source
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			if value, err = decodeJSON(data); err != nil {
+				return nil, err
+			}
+		}
+		return addPointer(document, path, value)
+	default:
+		return nil, patchErrorf("unknown operation %q", o.Op)
+	}
+}
+
+// normalizeNumbers converts json.Number values to float64 so that 1 and
+// 1.0 compare equal
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return v.String()
+		}
+		return f
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeNumbers(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeNumbers(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+// splitPointer parses an RFC 6901 JSON pointer into its reference tokens
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, patchErrorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token. "-" refers to the position after
+// the last element and is only valid when allowEnd is set.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, patchErrorf("invalid array index %q", token)
+	}
+	limit := length - 1
+	if allowEnd {
+		limit = length
+	}
+	if index > limit {
+		return 0, patchErrorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+// getPointer returns the value at pointer
+func getPointer(document interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := document
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, patchErrorf("%s does not exist", pointer)
+			}
+			current = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[index]
+		default:
+			return nil, patchErrorf("%s does not exist", pointer)
+		}
+	}
+	return current, nil
+}
+
+// addPointer adds value at pointer, inserting into arrays, and returns the
+// resulting document
+func addPointer(document interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateParent(document, tokens, pointer, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		default:
+			return nil, patchErrorf("the parent of %s is not an object or array", pointer)
+		}
+	})
+}
+
+// removePointer removes the value at pointer and returns the resulting
+// document
+func removePointer(document interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, patchErrorf("cannot remove the whole document")
+	}
+	return updateParent(document, tokens, pointer, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, patchErrorf("%s does not exist", pointer)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:index], node[index+1:]...), nil
+		default:
+			return nil, patchErrorf("%s does not exist", pointer)
+		}
+	})
+}
+
+// updateParent walks to the parent of the last token and replaces it with
+// the result of fn, so arrays can grow and shrink
+func updateParent(document interface{}, tokens []string, pointer string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return fn(document, tokens[0])
+	}
+
+	token := tokens[0]
+	switch node := document.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+		if !ok {
+			return nil, patchErrorf("the parent of %s does not exist", pointer)
+		}
+		updated, err := updateParent(child, tokens[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = updated
+		return node, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(node), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateParent(node[index], tokens[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = updated
+		return node, nil
+	default:
+		return nil, patchErrorf("the parent of %s does not exist", pointer)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"testing"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type PatchTestSuite struct {
+	suite.Suite
+	spaceAPI *models.SpaceAPI
+}
+
+func (suite *PatchTestSuite) SetupTest() {
+	suite.spaceAPI = testutil.NewMockSpaceAPI()
+}
+
+func TestPatchTestSuite(t *testing.T) {
+	suite.Run(t, new(PatchTestSuite))
+}
+
+func (suite *PatchTestSuite) jsonPatch(patch string) error {
+	return PatchSpaceAPI(suite.spaceAPI, JSONPatchType, []byte(patch))
+}
+
+func (suite *PatchTestSuite) TestMergePatch() {
+	err := PatchSpaceAPI(suite.spaceAPI, MergePatchType, []byte(`{
+		"contact": {"phone": "+49 30 1234", "irc": null},
+		"state": {"icon": {"open": "https://example.com/open.png", "closed": "https://example.com/closed.png"}}
+	}`))
+
+	suite.Require().NoError(err)
+	suite.Assert().Equal("+49 30 1234", suite.spaceAPI.Contact.Phone)
+	suite.Assert().Empty(suite.spaceAPI.Contact.IRC)
+	// Untouched members are kept
+	suite.Assert().Equal("test@example.com", suite.spaceAPI.Contact.Email)
+	suite.Assert().Equal("https://example.com/open.png", suite.spaceAPI.State.Icon.Open)
+	suite.Assert().True(*suite.spaceAPI.State.Open)
+}
+
+func (suite *PatchTestSuite) TestMergePatch_KeepsExtensions() {
+	err := PatchSpaceAPI(suite.spaceAPI, MergePatchType, []byte(`{"ext_habitat": {"plants": 12}}`))
+
+	suite.Require().NoError(err)
+	suite.Assert().Contains(suite.spaceAPI.Extra, "ext_habitat")
+}
+
+func (suite *PatchTestSuite) TestJSONPatch() {
+	err := suite.jsonPatch(`[
+		{"op": "test", "path": "/links/0/name", "value": "Website"},
+		{"op": "add", "path": "/links/-", "value": {"name": "Wiki", "url": "https://wiki.example.com"}},
+		{"op": "add", "path": "/links/0", "value": {"name": "Blog", "url": "https://blog.example.com"}},
+		{"op": "replace", "path": "/contact/email", "value": "info@example.com"},
+		{"op": "copy", "from": "/contact/email", "path": "/contact/ml"},
+		{"op": "move", "from": "/contact/twitter", "path": "/contact/mastodon"},
+		{"op": "remove", "path": "/contact/irc"}
+	]`)
+
+	suite.Require().NoError(err)
+	suite.Require().Len(suite.spaceAPI.Links, 3)
+	suite.Assert().Equal("Blog", suite.spaceAPI.Links[0].Name)
+	suite.Assert().Equal("Website", suite.spaceAPI.Links[1].Name)
+	suite.Assert().Equal("Wiki", suite.spaceAPI.Links[2].Name)
+	suite.Assert().Equal("info@example.com", suite.spaceAPI.Contact.Email)
+	suite.Assert().Equal("info@example.com", suite.spaceAPI.Contact.ML)
+	suite.Assert().Equal("@testspace", suite.spaceAPI.Contact.Mastodon)
+	suite.Assert().Empty(suite.spaceAPI.Contact.Twitter)
+	suite.Assert().Empty(suite.spaceAPI.Contact.IRC)
+}
+
+func (suite *PatchTestSuite) TestJSONPatch_EscapedPointer() {
+	err := suite.jsonPatch(`[{"op": "add", "path": "/ext_a~1b~0c", "value": 1}]`)
+
+	suite.Require().NoError(err)
+	suite.Assert().Contains(suite.spaceAPI.Extra, "ext_a/b~c")
+}
+
+func (suite *PatchTestSuite) TestJSONPatch_TestFailure() {
+	err := suite.jsonPatch(`[
+		{"op": "replace", "path": "/contact/email", "value": "info@example.com"},
+		{"op": "test", "path": "/space", "value": "Other Space"}
+	]`)
+
+	suite.Assert().ErrorIs(err, ErrPatchTestFailed)
+}
+
+func (suite *PatchTestSuite) TestJSONPatch_TestComparesNumbers() {
+	suite.Assert().NoError(suite.jsonPatch(`[{"op": "test", "path": "/location/lat", "value": 40.71280}]`))
+}
+
+func (suite *PatchTestSuite) TestJSONPatch_Invalid() {
+	patches := []string{
+		`{"op": "add"}`,
+		`[{"op": "add", "path": "/contact/phone"}]`,
+		`[{"op": "jump", "path": "/space"}]`,
+		`[{"op": "remove", "path": "/contact/phone"}]`,
+		`[{"op": "replace", "path": "/nothing/here", "value": 1}]`,
+		`[{"op": "add", "path": "/links/5", "value": {}}]`,
+		`[{"op": "add", "path": "/links/01", "value": {}}]`,
+		`[{"op": "remove", "path": "links"}]`,
+		`[{"op": "move", "from": "/contact", "path": "/contact/inner"}]`,
+		`[{"op": "remove", "path": ""}]`,
+	}
+	for _, patch := range patches {
+		suite.SetupTest()
+		var patchErr *PatchError
+		suite.Assert().ErrorAs(suite.jsonPatch(patch), &patchErr, patch)
+	}
+}
+
+func (suite *PatchTestSuite) TestRejectsNullAndWrongTypes() {
+	for _, tc := range []struct {
+		mediaType string
+		patch     string
+		path      string
+	}{
+		{MergePatchType, `{"space": null}`, "(root)"},
+		{MergePatchType, `{"location": {"lat": "52.5"}}`, "location.lat"},
+		{MergePatchType, `{"contact": {"email": 42}}`, "contact.email"},
+		{JSONPatchType, `[{"op": "replace", "path": "/space", "value": null}]`, "space"},
+		{JSONPatchType, `[{"op": "replace", "path": "/links", "value": {"name": "Wiki"}}]`, "links"},
+		{JSONPatchType, `[{"op": "replace", "path": "/location", "value": "nowhere"}]`, "location"},
+	} {
+		before := *suite.spaceAPI
+		err := PatchSpaceAPI(suite.spaceAPI, tc.mediaType, []byte(tc.patch))
+
+		var validationErr *ValidationError
+		suite.Require().ErrorAs(err, &validationErr, tc.patch)
+		paths := make([]string, 0, len(validationErr.Violations))
+		for _, violation := range validationErr.Violations {
+			paths = append(paths, violation.Path)
+		}
+		suite.Assert().Contains(paths, tc.path, tc.patch)
+		suite.Assert().Equal(before, *suite.spaceAPI, tc.patch)
+	}
+}
+
+func (suite *PatchTestSuite) TestUnsupportedType() {
+	var patchErr *PatchError
+	suite.Assert().ErrorAs(PatchSpaceAPI(suite.spaceAPI, "application/json", []byte(`{}`)), &patchErr)
+}
+
+func (suite *PatchTestSuite) TestProtectsRuntimeFields() {
+	patches := map[string]string{
+		"/state/open":           `{"state": {"open": false}}`,
+		"/state/lastchange":     `{"state": {"lastchange": 1}}`,
+		"/state/message":        `{"state": {"message": null}}`,
+		"/state/trigger_person": `{"state": {"trigger_person": "Mallory"}}`,
+		"/sensors":              `{"sensors": {"people_now_present": []}}`,
+		"/events":               `{"events": []}`,
+	}
+	for pointer, patch := range patches {
+		suite.SetupTest()
+		before := *suite.spaceAPI.State
+		err := PatchSpaceAPI(suite.spaceAPI, MergePatchType, []byte(patch))
+
+		var protectedErr *ProtectedFieldError
+		if suite.Assert().ErrorAs(err, &protectedErr, patch) {
+			suite.Assert().Equal(pointer, protectedErr.Pointer, patch)
+		}
+		suite.Assert().Equal(before, *suite.spaceAPI.State)
+	}
+}
+
+func (suite *PatchTestSuite) TestProtectedFieldsMayBeTestedAndKept() {
+	lastchange := suite.spaceAPI.State.Lastchange
+
+	err := suite.jsonPatch(`[
+		{"op": "copy", "from": "/state/message", "path": "/location/hint"}
+	]`)
+
+	suite.Require().NoError(err)
+	suite.Assert().Equal(lastchange, suite.spaceAPI.State.Lastchange)
+	suite.Assert().Equal(suite.spaceAPI.State.Message, suite.spaceAPI.Location.Hint)
+}
@@ -0,0 +1,204 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/fileutil"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// DefaultSaveDelay is how long the persister waits after the last mutation
+// before writing the document to disk
+const DefaultSaveDelay = 2 * time.Second
+
+// ErrEditedOnDisk is returned by Persister.Flush when the file was changed
+// by someone else since the persister last wrote or loaded it
+var ErrEditedOnDisk = errors.New("file was edited since it was last loaded")
+
+// Persister writes the SpaceAPI document back to its file. Writes are
+// debounced so that a burst of updates results in a single write. A write
+// never replaces edits made to the file by someone else: it is held back
+// until the edited file has been reloaded.
+type Persister struct {
+	path    string
+	delay   time.Duration
+	onEdit  func()
+	mutex   sync.Mutex
+	timer   *time.Timer
+	pending []byte
+	// written is the checksum of the file as the persister last wrote or
+	// loaded it, so writes can be told from edits made by someone else.
+	// modTime and size spare hashing the file when it was not touched.
+	written [sha256.Size]byte
+	modTime time.Time
+	size    int64
+}
+
+// NewPersister creates a persister writing to path after delay. The current
+// content of path is taken as loaded.
+func NewPersister(path string, delay time.Duration) *Persister {
+	p := &Persister{
+		path:  path,
+		delay: delay,
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		p.written = sha256.Sum256(data)
+	}
+	return p
+}
+
+// Path returns the file the persister writes to
+func (p *Persister) Path() string {
+	return p.path
+}
+
+// SetOnEdit sets a function called when a scheduled write is held back
+// because the file was edited. It should reload the file, which lets the
+// write proceed. It must be set before the first Schedule.
+func (p *Persister) SetOnEdit(onEdit func()) {
+	p.onEdit = onEdit
+}
+
+// Schedule serializes the document and schedules it to be written once no
+// further changes arrive within the save delay. The document is encoded
+// immediately, so the caller may keep mutating it afterwards.
+func (p *Persister) Schedule(spaceAPI *models.SpaceAPI) {
+	data, err := marshalSpaceAPI(spaceAPI)
+	if err != nil {
+		log.Printf("Error encoding SpaceAPI data for %s: %v", p.path, err)
+		return
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.pending = data
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.arm()
+}
+
+// arm starts the save timer. The mutex must be held.
+func (p *Persister) arm() {
+	p.timer = time.AfterFunc(p.delay, func() {
+		err := p.Flush()
+		switch {
+		case errors.Is(err, ErrEditedOnDisk):
+			log.Printf("Not saving %s as it was edited, reloading it first", p.path)
+			if p.onEdit != nil {
+				p.onEdit()
+			}
+		case err != nil:
+			log.Printf("Error saving %s: %v", p.path, err)
+		}
+	})
+}
+
+// Flush writes any pending document immediately. If the file was edited
+// since it was last written or loaded, nothing is written, the document
+// stays pending and ErrEditedOnDisk is returned.
+func (p *Persister) Flush() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	if p.pending == nil {
+		return nil
+	}
+	if p.editedOnDisk() {
+		return ErrEditedOnDisk
+	}
+
+	if err := fileutil.WriteFileAtomic(p.path, p.pending); err != nil {
+		return err
+	}
+	p.written = sha256.Sum256(p.pending)
+	p.modTime, p.size = time.Time{}, 0
+	if info, err := os.Stat(p.path); err == nil {
+		p.modTime, p.size = info.ModTime(), info.Size()
+	}
+	p.pending = nil
+	return nil
+}
+
+// editedOnDisk reports whether the file differs from what the persister last
+// wrote or loaded. A missing file has no edits to lose. The mutex must be
+// held.
+func (p *Persister) editedOnDisk() bool {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return false
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return false
+	}
+	return sha256.Sum256(data) != p.written
+}
+
+// Loaded records that the live document was reloaded from file content with
+// checksum sum. A write held back by an edit is scheduled again.
+func (p *Persister) Loaded(sum [sha256.Size]byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.written = sum
+	p.modTime, p.size = time.Time{}, 0
+	if p.pending != nil && p.timer == nil {
+		p.arm()
+	}
+}
+
+// Wrote reports whether sum is the checksum of the file as the persister
+// last wrote or loaded it
+func (p *Persister) Wrote(sum [sha256.Size]byte) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return sum == p.written
+}
+
+// SaveSpaceAPIData writes the document to path, replacing the file atomically
+func SaveSpaceAPIData(path string, spaceAPI *models.SpaceAPI) error {
+	data, err := marshalSpaceAPI(spaceAPI)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteFileAtomic(path, data)
+}
+
+// marshalSpaceAPI encodes the document in the same layout as spaceapi.json.example
+func marshalSpaceAPI(spaceAPI *models.SpaceAPI) ([]byte, error) {
+	data, err := json.MarshalIndent(spaceAPI, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,125 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type PersisterTestSuite struct {
+	suite.Suite
+	dir  string
+	path string
+}
+
+func (suite *PersisterTestSuite) SetupTest() {
+	suite.dir = suite.T().TempDir()
+	suite.path = filepath.Join(suite.dir, "spaceapi.json")
+}
+
+func TestPersisterTestSuite(t *testing.T) {
+	suite.Run(t, new(PersisterTestSuite))
+}
+
+func (suite *PersisterTestSuite) TestSaveSpaceAPIData_RoundTrip() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+
+	suite.Require().NoError(SaveSpaceAPIData(suite.path, spaceAPI))
+
+	loaded, err := LoadSpaceAPIData(suite.path)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(spaceAPI.Space, loaded.Space)
+	suite.Assert().Equal(*spaceAPI.State.Open, *loaded.State.Open)
+	suite.Assert().Equal(spaceAPI.State.Message, loaded.State.Message)
+}
+
+func (suite *PersisterTestSuite) TestSaveSpaceAPIData_KeepsPermissions() {
+	suite.Require().NoError(os.WriteFile(suite.path, []byte("{}"), 0o600))
+
+	suite.Require().NoError(SaveSpaceAPIData(suite.path, testutil.NewMockSpaceAPI()))
+
+	info, err := os.Stat(suite.path)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(os.FileMode(0o600), info.Mode().Perm())
+}
+
+func (suite *PersisterTestSuite) TestSaveSpaceAPIData_NoTemporaryFilesLeft() {
+	suite.Require().NoError(SaveSpaceAPIData(suite.path, testutil.NewMockSpaceAPI()))
+
+	entries, err := os.ReadDir(suite.dir)
+	suite.Require().NoError(err)
+	suite.Assert().Len(entries, 1)
+	suite.Assert().Equal("spaceapi.json", entries[0].Name())
+}
+
+func (suite *PersisterTestSuite) TestSaveSpaceAPIData_MissingDirectory() {
+	err := SaveSpaceAPIData(filepath.Join(suite.dir, "missing", "spaceapi.json"), testutil.NewMockSpaceAPI())
+	suite.Assert().Error(err)
+}
+
+func (suite *PersisterTestSuite) TestSchedule_Debounced() {
+	persister := NewPersister(suite.path, 50*time.Millisecond)
+	spaceAPI := testutil.NewMockSpaceAPI()
+
+	for i := 0; i < 5; i++ {
+		spaceAPI.State.Message = "update"
+		persister.Schedule(spaceAPI)
+	}
+
+	// Nothing is written before the delay expires
+	_, err := os.Stat(suite.path)
+	suite.Assert().True(os.IsNotExist(err))
+
+	suite.Assert().Eventually(func() bool {
+		_, err := os.Stat(suite.path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	loaded, err := LoadSpaceAPIData(suite.path)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("update", loaded.State.Message)
+}
+
+func (suite *PersisterTestSuite) TestSchedule_EncodesAtCallTime() {
+	persister := NewPersister(suite.path, time.Hour)
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.State.Open = models.BoolPtr(false)
+
+	persister.Schedule(spaceAPI)
+	spaceAPI.State.Open = models.BoolPtr(true)
+
+	suite.Require().NoError(persister.Flush())
+
+	loaded, err := LoadSpaceAPIData(suite.path)
+	suite.Require().NoError(err)
+	suite.Assert().False(*loaded.State.Open)
+}
+
+func (suite *PersisterTestSuite) TestFlush_NothingPending() {
+	persister := NewPersister(suite.path, time.Hour)
+
+	suite.Assert().NoError(persister.Flush())
+
+	_, err := os.Stat(suite.path)
+	suite.Assert().True(os.IsNotExist(err))
+}
@@ -0,0 +1,194 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// DefaultWatchInterval is how often the configuration file is checked for changes
+const DefaultWatchInterval = 2 * time.Second
+
+// errUnchanged aborts a reload that would not change the document
+var errUnchanged = errors.New("document unchanged")
+
+// ReloadSpaceAPIData re-reads the document at path and makes it the live
+// document, keeping the runtime-owned parts: the open state, message,
+// trigger person and lastchange of State, all Sensors and all Events. A file
+// that fails to parse or validate is not applied. It reports whether the
+// live document changed. publish, if not nil, is called with the reloaded
+// document as described for Store.UpdateAndPublish. Writes of the store's
+// persister held back by an edit of the file may proceed afterwards.
+func ReloadSpaceAPIData(store *Store, path string, publish func(spaceAPI *models.SpaceAPI)) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("could not load %s: %w", path, err)
+	}
+	loaded, err := ParseSpaceAPIData(data)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+
+	_, err = store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+		current, err := json.Marshal(spaceAPI)
+		if err != nil {
+			return err
+		}
+
+		mergeRuntimeState(loaded, spaceAPI)
+		next, err := json.Marshal(loaded)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(current, next) {
+			return errUnchanged
+		}
+
+		*spaceAPI = *loaded
+		return nil
+	}, publish)
+	if err != nil && !errors.Is(err, errUnchanged) {
+		return false, err
+	}
+	if persister := store.persister; persister != nil && persister.Path() == path {
+		persister.Loaded(sha256.Sum256(data))
+	}
+	return err == nil, nil
+}
+
+// mergeRuntimeState copies the runtime-owned parts of live into static
+func mergeRuntimeState(static, live *models.SpaceAPI) {
+	if live.State != nil {
+		if static.State == nil {
+			static.State = &models.State{}
+		}
+		static.State.Open = live.State.Open
+		static.State.Lastchange = live.State.Lastchange
+		static.State.TriggerPerson = live.State.TriggerPerson
+		static.State.Message = live.State.Message
+	}
+	static.Sensors = live.Sensors
+	static.Events = live.Events
+}
+
+// FileWatcher polls a file and calls onChange when its content changes.
+// Polling works on any file system, including bind mounts that do not
+// deliver change notifications into containers.
+type FileWatcher struct {
+	path     string
+	interval time.Duration
+	onChange func()
+	ignore   *Persister
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	// Last observed state of the file, only touched by the watch goroutine
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// NewFileWatcher creates a watcher for path. The current content is taken as
+// the baseline, so onChange is only called for later changes.
+func NewFileWatcher(path string, interval time.Duration, onChange func()) *FileWatcher {
+	fw := &FileWatcher{
+		path:     path,
+		interval: interval,
+		onChange: onChange,
+		stopCh:   make(chan struct{}),
+	}
+	fw.changed()
+	return fw
+}
+
+// IgnoreWritesOf makes the watcher skip content written by persister. The
+// server's own writes would otherwise be reloaded and revert changes made
+// in memory since then.
+func (fw *FileWatcher) IgnoreWritesOf(persister *Persister) {
+	fw.ignore = persister
+}
+
+// Start begins polling in the background
+func (fw *FileWatcher) Start() {
+	go fw.run()
+}
+
+// Stop ends polling
+func (fw *FileWatcher) Stop() {
+	fw.stopOnce.Do(func() {
+		close(fw.stopCh)
+	})
+}
+
+func (fw *FileWatcher) run() {
+	ticker := time.NewTicker(fw.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			fw.poll()
+		case <-fw.stopCh:
+			return
+		}
+	}
+}
+
+// poll calls onChange if the file was changed by someone else
+func (fw *FileWatcher) poll() {
+	if fw.changed() {
+		fw.onChange()
+	}
+}
+
+// changed records the current state of the file and reports whether its
+// content differs from the previous observation and was not written by the
+// ignored persister
+func (fw *FileWatcher) changed() bool {
+	info, err := os.Stat(fw.path)
+	if err != nil {
+		// The file is briefly missing while editors replace it
+		return false
+	}
+	if info.ModTime().Equal(fw.modTime) && info.Size() == fw.size {
+		return false
+	}
+
+	data, err := os.ReadFile(fw.path)
+	if err != nil {
+		log.Printf("Error reading %s: %v", fw.path, err)
+		return false
+	}
+
+	fw.modTime = info.ModTime()
+	fw.size = info.Size()
+	sum := sha256.Sum256(data)
+	if sum == fw.sum {
+		return false
+	}
+	fw.sum = sum
+	return fw.ignore == nil || !fw.ignore.Wrote(sum)
+}
@@ -0,0 +1,251 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type ReloadTestSuite struct {
+	suite.Suite
+	path  string
+	store *Store
+}
+
+func (suite *ReloadTestSuite) SetupTest() {
+	suite.path = filepath.Join(suite.T().TempDir(), "spaceapi.json")
+	spaceAPI := testutil.NewMockSpaceAPI()
+	suite.Require().NoError(SaveSpaceAPIData(suite.path, spaceAPI))
+	suite.store = NewStore(spaceAPI, nil)
+}
+
+func TestReloadTestSuite(t *testing.T) {
+	suite.Run(t, new(ReloadTestSuite))
+}
+
+// editFile applies fn to the document on disk
+func (suite *ReloadTestSuite) editFile(fn func(spaceAPI *models.SpaceAPI)) {
+	spaceAPI, err := LoadSpaceAPIData(suite.path)
+	suite.Require().NoError(err)
+	fn(spaceAPI)
+	suite.Require().NoError(SaveSpaceAPIData(suite.path, spaceAPI))
+}
+
+func (suite *ReloadTestSuite) TestReload_KeepsRuntimeState() {
+	// Runtime updates not yet written to disk
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Open = models.BoolPtr(false)
+		spaceAPI.State.Message = "Closed for the night"
+		spaceAPI.Sensors.PeopleNowPresent[0].Value = 0
+		spaceAPI.Events = append(spaceAPI.Events, models.Event{Name: "Alice", Type: "check-out", Timestamp: 1})
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	suite.editFile(func(spaceAPI *models.SpaceAPI) {
+		spaceAPI.Contact.Email = "new@example.com"
+		spaceAPI.State.Open = models.BoolPtr(true)
+		spaceAPI.State.Message = "Stale message from disk"
+		spaceAPI.State.Icon = &models.Icon{Open: "https://example.com/open.png", Closed: "https://example.com/closed.png"}
+		spaceAPI.Sensors.PeopleNowPresent[0].Value = 9
+		spaceAPI.Events = nil
+	})
+
+	changed, err := ReloadSpaceAPIData(suite.store, suite.path, nil)
+	suite.Require().NoError(err)
+	suite.Assert().True(changed)
+
+	snapshot := suite.store.Snapshot()
+	suite.Assert().Equal("new@example.com", snapshot.Contact.Email)
+	suite.Assert().Equal("https://example.com/open.png", snapshot.State.Icon.Open)
+	suite.Assert().False(*snapshot.State.Open)
+	suite.Assert().Equal("Closed for the night", snapshot.State.Message)
+	suite.Assert().Equal(0, snapshot.Sensors.PeopleNowPresent[0].Value)
+	suite.Assert().Len(snapshot.Events, 2)
+}
+
+func (suite *ReloadTestSuite) TestReload_Unchanged() {
+	changed, err := ReloadSpaceAPIData(suite.store, suite.path, nil)
+
+	suite.Require().NoError(err)
+	suite.Assert().False(changed)
+}
+
+func (suite *ReloadTestSuite) TestReload_InvalidJSON() {
+	before := suite.store.Snapshot()
+	suite.Require().NoError(os.WriteFile(suite.path, []byte(`{"space": `), 0o644))
+
+	changed, err := ReloadSpaceAPIData(suite.store, suite.path, nil)
+
+	suite.Assert().Error(err)
+	suite.Assert().False(changed)
+	suite.Assert().Same(before, suite.store.Snapshot())
+}
+
+func (suite *ReloadTestSuite) TestReload_InvalidDocument() {
+	before := suite.store.Snapshot()
+	suite.editFile(func(spaceAPI *models.SpaceAPI) {
+		spaceAPI.MembershipPlans = append(spaceAPI.MembershipPlans, models.MembershipPlan{
+			Name:            "Regular",
+			Value:           20,
+			Currency:        "EUR",
+			BillingInterval: "fortnightly",
+		})
+	})
+
+	_, err := ReloadSpaceAPIData(suite.store, suite.path, nil)
+
+	var validationErr *ValidationError
+	suite.Assert().ErrorAs(err, &validationErr)
+	suite.Assert().Same(before, suite.store.Snapshot())
+}
+
+func (suite *ReloadTestSuite) TestFileWatcher_DetectsChanges() {
+	var calls atomic.Int32
+	watcher := NewFileWatcher(suite.path, 10*time.Millisecond, func() {
+		calls.Add(1)
+	})
+	watcher.Start()
+	defer watcher.Stop()
+
+	// No change yet
+	time.Sleep(50 * time.Millisecond)
+	suite.Assert().Zero(calls.Load())
+
+	suite.editFile(func(spaceAPI *models.SpaceAPI) {
+		spaceAPI.Contact.Email = "changed@example.com"
+	})
+	suite.Assert().Eventually(func() bool {
+		return calls.Load() == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func (suite *ReloadTestSuite) TestFileWatcher_IgnoresIdenticalRewrite() {
+	var calls atomic.Int32
+	watcher := NewFileWatcher(suite.path, 10*time.Millisecond, func() {
+		calls.Add(1)
+	})
+	watcher.Start()
+	defer watcher.Stop()
+
+	data, err := os.ReadFile(suite.path)
+	suite.Require().NoError(err)
+	future := time.Now().Add(time.Minute)
+	suite.Require().NoError(os.WriteFile(suite.path, data, 0o644))
+	suite.Require().NoError(os.Chtimes(suite.path, future, future))
+
+	time.Sleep(100 * time.Millisecond)
+	suite.Assert().Zero(calls.Load())
+}
+
+func (suite *ReloadTestSuite) TestFileWatcher_IgnoresOwnWrites() {
+	persister := NewPersister(suite.path, time.Hour)
+	suite.store = NewStore(suite.store.Snapshot(), persister)
+	watcher := NewFileWatcher(suite.path, time.Hour, func() {
+		_, err := ReloadSpaceAPIData(suite.store, suite.path, nil)
+		suite.Require().NoError(err)
+	})
+	watcher.IgnoreWritesOf(persister)
+
+	// A state update is written to disk, then the document is patched before
+	// the watcher polls the file
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Open = models.BoolPtr(false)
+		return nil
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(persister.Flush())
+	_, err = suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		return PatchSpaceAPI(spaceAPI, MergePatchType, []byte(`{"contact": {"twitter": "@patched"}}`))
+	})
+	suite.Require().NoError(err)
+
+	watcher.poll()
+
+	suite.Assert().Equal("@patched", suite.store.Snapshot().Contact.Twitter)
+	suite.Require().NoError(persister.Flush())
+	saved, err := LoadSpaceAPIData(suite.path)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("@patched", saved.Contact.Twitter)
+
+	// Edits by others are still picked up
+	suite.editFile(func(spaceAPI *models.SpaceAPI) {
+		spaceAPI.Contact.Email = "edited@example.com"
+	})
+	watcher.poll()
+	suite.Assert().Equal("edited@example.com", suite.store.Snapshot().Contact.Email)
+}
+
+func (suite *ReloadTestSuite) TestPersister_KeepsEditMadeBeforeFlush() {
+	persister := NewPersister(suite.path, time.Hour)
+	suite.store = NewStore(suite.store.Snapshot(), persister)
+
+	// The edit lands after the update is scheduled but before it is written
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Open = models.BoolPtr(false)
+		return nil
+	})
+	suite.Require().NoError(err)
+	suite.editFile(func(spaceAPI *models.SpaceAPI) {
+		spaceAPI.Contact.Email = "edited@example.com"
+	})
+
+	suite.Assert().ErrorIs(persister.Flush(), ErrEditedOnDisk)
+	saved, err := LoadSpaceAPIData(suite.path)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("edited@example.com", saved.Contact.Email)
+
+	// Once reloaded, the merged document is written
+	_, err = ReloadSpaceAPIData(suite.store, suite.path, nil)
+	suite.Require().NoError(err)
+	suite.Require().NoError(persister.Flush())
+	saved, err = LoadSpaceAPIData(suite.path)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("edited@example.com", saved.Contact.Email)
+	suite.Assert().False(*saved.State.Open)
+}
+
+func (suite *ReloadTestSuite) TestPersister_ReloadsEditBeforeScheduledSave() {
+	persister := NewPersister(suite.path, 10*time.Millisecond)
+	suite.store = NewStore(suite.store.Snapshot(), persister)
+	persister.SetOnEdit(func() {
+		_, err := ReloadSpaceAPIData(suite.store, suite.path, nil)
+		suite.Assert().NoError(err)
+	})
+
+	suite.editFile(func(spaceAPI *models.SpaceAPI) {
+		spaceAPI.Contact.Email = "edited@example.com"
+	})
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Open = models.BoolPtr(false)
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	suite.Assert().Eventually(func() bool {
+		saved, err := LoadSpaceAPIData(suite.path)
+		return err == nil && saved.Contact.Email == "edited@example.com" && !*saved.State.Open
+	}, time.Second, 10*time.Millisecond)
+	suite.Assert().Equal("edited@example.com", suite.store.Snapshot().Contact.Email)
+}
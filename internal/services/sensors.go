@@ -0,0 +1,313 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// ErrUnknownSensorType is returned for sensor types that cannot be updated
+var ErrUnknownSensorType = errors.New("unknown sensor type")
+
+// SensorError reports a reading that does not match its sensor type
+type SensorError struct {
+	Type   string
+	Reason string
+}
+
+func (e *SensorError) Error() string {
+	return e.Type + " " + e.Reason
+}
+
+var (
+	temperatureUnits = []string{"°C", "°F", "K", "°De", "°N", "°R", "°Ré", "°Rø"}
+	radiationUnits   = []string{"cpm", "r/h", "µSv/h", "mSv/a", "µSv/a"}
+	powerUnits       = []string{"mW", "W", "VA"}
+	windSpeedUnits   = []string{"m/s", "km/h", "kn"}
+	connectionTypes  = []string{"wifi", "cable", "spacenet"}
+	currencyPattern  = regexp.MustCompile(`^[A-Z]{3}$`)
+)
+
+// sensorUpdater decodes a reading and stores it in sensors. It returns the
+// stored reading and the updated list of readings of that type.
+type sensorUpdater func(sensors *models.Sensors, sensorType string, data []byte) (interface{}, interface{}, error)
+
+// sensorUpdaters maps sensor types to their updaters. Radiation readings are
+// addressed as radiation.<kind>, mirroring their place in the document.
+var sensorUpdaters = map[string]sensorUpdater{
+	"temperature": readingUpdater(
+		func(s *models.Sensors) *[]models.TemperatureSensor { return &s.Temperature },
+		func(r *models.TemperatureSensor) error { return checkUnit("unit", r.Unit, temperatureUnits) },
+		"value",
+	),
+	"carbondioxide": readingUpdater(
+		func(s *models.Sensors) *[]models.CarbonDioxideSensor { return &s.CarbonDioxide },
+		func(r *models.CarbonDioxideSensor) error { return checkUnit("unit", r.Unit, []string{"ppm"}) },
+		"value",
+	),
+	"door_locked": readingUpdater(
+		func(s *models.Sensors) *[]models.DoorLockedSensor { return &s.DoorLocked },
+		nil,
+		"value",
+	),
+	"barometer": readingUpdater(
+		func(s *models.Sensors) *[]models.BarometerSensor { return &s.Barometer },
+		func(r *models.BarometerSensor) error { return checkUnit("unit", r.Unit, []string{"hPa", "hPA"}) },
+		"value",
+	),
+	"radiation.alpha":      radiationUpdater(func(r *models.RadiationSensors) *[]models.RadiationSensor { return &r.Alpha }),
+	"radiation.beta":       radiationUpdater(func(r *models.RadiationSensors) *[]models.RadiationSensor { return &r.Beta }),
+	"radiation.gamma":      radiationUpdater(func(r *models.RadiationSensors) *[]models.RadiationSensor { return &r.Gamma }),
+	"radiation.beta_gamma": radiationUpdater(func(r *models.RadiationSensors) *[]models.RadiationSensor { return &r.BetaGamma }),
+	"humidity": readingUpdater(
+		func(s *models.Sensors) *[]models.HumiditySensor { return &s.Humidity },
+		func(r *models.HumiditySensor) error { return checkUnit("unit", r.Unit, []string{"%"}) },
+		"value",
+	),
+	"beverage_supply": readingUpdater(
+		func(s *models.Sensors) *[]models.BeverageSupplySensor { return &s.BeverageSupply },
+		func(r *models.BeverageSupplySensor) error { return checkUnit("unit", r.Unit, []string{"btl", "crt"}) },
+		"value",
+	),
+	"power_consumption": readingUpdater(
+		func(s *models.Sensors) *[]models.PowerSensor { return &s.PowerConsumption },
+		func(r *models.PowerSensor) error { return checkUnit("unit", r.Unit, powerUnits) },
+		"value",
+	),
+	"power_generation": readingUpdater(
+		func(s *models.Sensors) *[]models.PowerSensor { return &s.PowerGeneration },
+		func(r *models.PowerSensor) error { return checkUnit("unit", r.Unit, powerUnits) },
+		"value",
+	),
+	"wind": readingUpdater(
+		func(s *models.Sensors) *[]models.WindSensor { return &s.Wind },
+		checkWind,
+		"properties",
+	),
+	"network_connections": readingUpdater(
+		func(s *models.Sensors) *[]models.NetworkConnectionsSensor { return &s.NetworkConnections },
+		func(r *models.NetworkConnectionsSensor) error {
+			if r.Type == "" {
+				return nil
+			}
+			return checkUnit("type", r.Type, connectionTypes)
+		},
+		"value",
+	),
+	"account_balance": readingUpdater(
+		func(s *models.Sensors) *[]models.AccountBalanceSensor { return &s.AccountBalance },
+		func(r *models.AccountBalanceSensor) error {
+			if !currencyPattern.MatchString(r.Unit) {
+				return errors.New("unit must be an ISO 4217 currency code")
+			}
+			return nil
+		},
+		"value",
+	),
+	"total_member_count": readingUpdater(
+		func(s *models.Sensors) *[]models.TotalMemberCountSensor { return &s.TotalMemberCount },
+		nil,
+		"value",
+	),
+	"people_now_present": readingUpdater(
+		func(s *models.Sensors) *[]models.PeopleNowPresentSensor { return &s.PeopleNowPresent },
+		nil,
+		"value",
+	),
+	"network_traffic": readingUpdater(
+		func(s *models.Sensors) *[]models.NetworkTrafficSensor { return &s.NetworkTraffic },
+		func(r *models.NetworkTrafficSensor) error {
+			if r.Properties.BitsPerSecond == nil && r.Properties.PacketsPerSecond == nil {
+				return errors.New("properties must contain bits_per_second or packets_per_second")
+			}
+			return nil
+		},
+		"properties",
+	),
+}
+
+// SensorTypes returns the sensor types accepted by UpdateSensor
+func SensorTypes() []string {
+	types := make([]string, 0, len(sensorUpdaters))
+	for sensorType := range sensorUpdaters {
+		types = append(types, sensorType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// UpdateSensor decodes a JSON reading of sensorType and stores it, replacing
+// the reading with the same location and name. It returns the stored reading
+// and all readings of that type. Readings that do not match the type are
+// reported as *SensorError.
+func UpdateSensor(spaceAPI *models.SpaceAPI, sensorType string, data []byte) (interface{}, interface{}, error) {
+	update, ok := sensorUpdaters[sensorType]
+	if !ok {
+		return nil, nil, ErrUnknownSensorType
+	}
+
+	if spaceAPI.Sensors == nil {
+		spaceAPI.Sensors = &models.Sensors{}
+	}
+	return update(spaceAPI.Sensors, sensorType, data)
+}
+
+// readingUpdater builds the updater for a sensor type stored in the list
+// returned by list. check, if not nil, validates the decoded reading.
+func readingUpdater[T any, PT interface {
+	*T
+	models.Sensor
+}](list func(*models.Sensors) *[]T, check func(*T) error, required ...string) sensorUpdater {
+	return func(sensors *models.Sensors, sensorType string, data []byte) (interface{}, interface{}, error) {
+		reading, err := decodeReading[T](sensorType, data, required)
+		if err != nil {
+			return nil, nil, err
+		}
+		if check != nil {
+			if err := check(&reading); err != nil {
+				return nil, nil, &SensorError{Type: sensorType, Reason: err.Error()}
+			}
+		}
+
+		readings := list(sensors)
+		return upsertReading[T, PT](readings, reading), *readings, nil
+	}
+}
+
+// radiationUpdater builds the updater for one kind of radiation reading
+func radiationUpdater(kind func(*models.RadiationSensors) *[]models.RadiationSensor) sensorUpdater {
+	return readingUpdater(
+		func(s *models.Sensors) *[]models.RadiationSensor {
+			if s.Radiation == nil {
+				s.Radiation = &models.RadiationSensors{}
+			}
+			return kind(s.Radiation)
+		},
+		func(r *models.RadiationSensor) error { return checkUnit("unit", r.Unit, radiationUnits) },
+		"value",
+	)
+}
+
+// upsertReading replaces the reading with the same location and name, or
+// appends it, and stamps it with the current time
+func upsertReading[T any, PT interface {
+	*T
+	models.Sensor
+}](readings *[]T, reading T) T {
+	meta := PT(&reading).Meta()
+	meta.Lastchange = time.Now().Unix()
+
+	for i := range *readings {
+		existing := PT(&(*readings)[i]).Meta()
+		if existing.Location == meta.Location && existing.Name == meta.Name {
+			// Keep the configured description and extension fields unless
+			// new ones are sent
+			if meta.Description == "" {
+				meta.Description = existing.Description
+			}
+			if meta.Extra == nil {
+				meta.Extra = existing.Extra
+			}
+			(*readings)[i] = reading
+			return reading
+		}
+	}
+
+	*readings = append(*readings, reading)
+	return reading
+}
+
+// decodeReading decodes data into a reading of type T, turning decoding
+// errors into readable *SensorError values
+func decodeReading[T any](sensorType string, data []byte, required []string) (T, error) {
+	var reading T
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return reading, &SensorError{Type: sensorType, Reason: "reading must be a JSON object"}
+	}
+	for _, name := range required {
+		if value, ok := members[name]; !ok || string(value) == "null" {
+			return reading, &SensorError{Type: sensorType, Reason: name + " is required"}
+		}
+	}
+
+	if err := json.Unmarshal(data, &reading); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return reading, &SensorError{Type: sensorType, Reason: fmt.Sprintf("%s must be %s", typeErr.Field, describeKind(typeErr.Type))}
+		}
+		return reading, &SensorError{Type: sensorType, Reason: err.Error()}
+	}
+	return reading, nil
+}
+
+// describeKind names the JSON type expected for a Go type
+func describeKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64:
+		return "a whole number"
+	case reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// checkWind verifies the units of all wind measurements
+func checkWind(r *models.WindSensor) error {
+	checks := []struct {
+		field string
+		unit  string
+		units []string
+	}{
+		{"properties.speed.unit", r.Properties.Speed.Unit, windSpeedUnits},
+		{"properties.gust.unit", r.Properties.Gust.Unit, windSpeedUnits},
+		{"properties.direction.unit", r.Properties.Direction.Unit, []string{"°"}},
+		{"properties.elevation.unit", r.Properties.Elevation.Unit, []string{"m"}},
+	}
+	for _, check := range checks {
+		if err := checkUnit(check.field, check.unit, check.units); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUnit verifies that value is one of allowed
+func checkUnit(field, value string, allowed []string) error {
+	for _, unit := range allowed {
+		if unit == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must be one of %s", field, strings.Join(allowed, ", "))
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"testing"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type SensorsTestSuite struct {
+	suite.Suite
+	spaceAPI *models.SpaceAPI
+}
+
+func (suite *SensorsTestSuite) SetupTest() {
+	suite.spaceAPI = testutil.NewMockSpaceAPI()
+}
+
+func TestSensorsTestSuite(t *testing.T) {
+	suite.Run(t, new(SensorsTestSuite))
+}
+
+func (suite *SensorsTestSuite) TestUpdateSensor_Errors() {
+	tests := []struct {
+		sensorType string
+		reading    string
+		wantErr    string
+	}{
+		{"temperature", `{"value": 21.5, "location": "Lab"}`, "temperature unit must be one of °C, °F, K, °De, °N, °R, °Ré, °Rø"},
+		{"temperature", `{"value": true, "unit": "°C"}`, "temperature value must be a number"},
+		{"temperature", `{"value": "21", "unit": "°C"}`, "temperature value must be a number"},
+		{"temperature", `{"unit": "°C"}`, "temperature value is required"},
+		{"temperature", `[21.5]`, "temperature reading must be a JSON object"},
+		{"door_locked", `{"value": 1}`, "door_locked value must be a boolean"},
+		{"power_consumption", `{"value": 350, "unit": "kW"}`, "power_consumption unit must be one of mW, W, VA"},
+		{"people_now_present", `{"value": 2.5}`, "people_now_present value must be a whole number"},
+		{"account_balance", `{"value": 10, "unit": "€"}`, "account_balance unit must be an ISO 4217 currency code"},
+		{"network_connections", `{"value": 3, "type": "carrier pigeon"}`, "network_connections type must be one of wifi, cable, spacenet"},
+		{"radiation.gamma", `{"value": 0.1, "unit": "Sv"}`, "radiation.gamma unit must be one of cpm, r/h, µSv/h, mSv/a, µSv/a"},
+		{"wind", `{"location": "Roof"}`, "wind properties is required"},
+		{"wind", `{"properties": {"speed": {"value": "fast"}}}`, "wind properties.speed.value must be a number"},
+		{"network_traffic", `{"properties": {}}`, "network_traffic properties must contain bits_per_second or packets_per_second"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := UpdateSensor(suite.spaceAPI, tt.sensorType, []byte(tt.reading))
+
+		var sensorErr *SensorError
+		suite.Require().ErrorAs(err, &sensorErr, tt.reading)
+		suite.Assert().EqualError(err, tt.wantErr)
+	}
+}
+
+func (suite *SensorsTestSuite) TestUpdateSensor_UnknownType() {
+	_, _, err := UpdateSensor(suite.spaceAPI, "flux_capacitor", []byte(`{"value": 1.21}`))
+	suite.Assert().ErrorIs(err, ErrUnknownSensorType)
+}
+
+func (suite *SensorsTestSuite) TestUpdateSensor_KeyedByLocationAndName() {
+	for _, reading := range []string{
+		`{"value": 20, "unit": "°C", "location": "Lab", "name": "Ceiling"}`,
+		`{"value": 18, "unit": "°C", "location": "Lab", "name": "Floor"}`,
+		`{"value": 22, "unit": "°C", "location": "Lab", "name": "Ceiling"}`,
+	} {
+		_, _, err := UpdateSensor(suite.spaceAPI, "temperature", []byte(reading))
+		suite.Require().NoError(err)
+	}
+
+	temperature := suite.spaceAPI.Sensors.Temperature
+	suite.Require().Len(temperature, 2)
+	suite.Assert().Equal(22.0, temperature[0].Value)
+	suite.Assert().Equal(18.0, temperature[1].Value)
+	suite.Assert().NotZero(temperature[0].Lastchange)
+}
+
+func (suite *SensorsTestSuite) TestUpdateSensor_KeepsDescription() {
+	suite.spaceAPI.Sensors.DoorLocked = []models.DoorLockedSensor{
+		{Value: false, SensorMeta: models.SensorMeta{Location: "Front", Description: "Main door"}},
+	}
+
+	updated, readings, err := UpdateSensor(suite.spaceAPI, "door_locked", []byte(`{"value": true, "location": "Front"}`))
+	suite.Require().NoError(err)
+
+	suite.Assert().Equal("Main door", updated.(models.DoorLockedSensor).Description)
+	suite.Assert().Len(readings, 1)
+	suite.Assert().True(suite.spaceAPI.Sensors.DoorLocked[0].Value)
+	suite.Assert().Equal("Main door", suite.spaceAPI.Sensors.DoorLocked[0].Description)
+}
+
+func (suite *SensorsTestSuite) TestUpdateSensor_KeepsExtensions() {
+	_, _, err := UpdateSensor(suite.spaceAPI, "humidity", []byte(`{"value": 40, "unit": "%", "location": "Cellar", "ext_probe": "dht22"}`))
+	suite.Require().NoError(err)
+	updated, _, err := UpdateSensor(suite.spaceAPI, "humidity", []byte(`{"value": 45, "unit": "%", "location": "Cellar"}`))
+	suite.Require().NoError(err)
+
+	suite.Assert().JSONEq(`"dht22"`, string(updated.(models.HumiditySensor).Extra["ext_probe"]))
+}
+
+func (suite *SensorsTestSuite) TestUpdateSensor_Structured() {
+	_, _, err := UpdateSensor(suite.spaceAPI, "wind", []byte(`{
+		"properties": {
+			"speed": {"value": 4.2, "unit": "m/s"},
+			"gust": {"value": 9.1, "unit": "m/s"},
+			"direction": {"value": 270, "unit": "°"},
+			"elevation": {"value": 42, "unit": "m"}
+		},
+		"location": "Roof"
+	}`))
+	suite.Require().NoError(err)
+
+	_, _, err = UpdateSensor(suite.spaceAPI, "radiation.beta_gamma", []byte(`{"value": 0.12, "unit": "µSv/h", "location": "Lab"}`))
+	suite.Require().NoError(err)
+
+	suite.Require().Len(suite.spaceAPI.Sensors.Wind, 1)
+	suite.Assert().Equal(270.0, suite.spaceAPI.Sensors.Wind[0].Properties.Direction.Value)
+	suite.Require().NotNil(suite.spaceAPI.Sensors.Radiation)
+	suite.Assert().Len(suite.spaceAPI.Sensors.Radiation.BetaGamma, 1)
+	suite.Assert().NoError(ValidateSpaceAPI(suite.spaceAPI))
+}
+
+func (suite *SensorsTestSuite) TestUpdateSensor_CreatesSensors() {
+	suite.spaceAPI.Sensors = nil
+
+	_, _, err := UpdateSensor(suite.spaceAPI, "humidity", []byte(`{"value": 45, "unit": "%", "location": "Lab"}`))
+	suite.Require().NoError(err)
+	suite.Assert().Len(suite.spaceAPI.Sensors.Humidity, 1)
+}
+
+func (suite *SensorsTestSuite) TestSensorTypes() {
+	suite.Assert().Contains(SensorTypes(), "temperature")
+	suite.Assert().Contains(SensorTypes(), "radiation.alpha")
+	suite.Assert().NotContains(SensorTypes(), "radiation")
+}
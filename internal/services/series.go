@@ -0,0 +1,512 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/fileutil"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// Defaults for SeriesConfig
+const (
+	DefaultSeriesRawRetention        = 48 * time.Hour
+	DefaultSeriesFiveMinuteRetention = 30 * 24 * time.Hour
+	DefaultSeriesHourlyRetention     = 2 * 365 * 24 * time.Hour
+	DefaultSeriesSaveInterval        = time.Minute
+)
+
+// SeriesConfig configures a SensorHistory
+type SeriesConfig struct {
+	// Path is where the series are kept. An empty path keeps them in memory
+	// only.
+	Path string
+	// How long raw readings, 5-minute and hourly aggregates are kept
+	RawRetention        time.Duration
+	FiveMinuteRetention time.Duration
+	HourlyRetention     time.Duration
+	// SaveInterval is how often expired data is dropped and the series
+	// written to disk
+	SaveInterval time.Duration
+}
+
+// seriesSample is a raw reading
+type seriesSample struct {
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+}
+
+// seriesBucket aggregates the readings from Time until the next bucket
+type seriesBucket struct {
+	Time  int64   `json:"time"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Count int     `json:"count"`
+}
+
+// add includes count readings adding up to sum, ranging from min to max
+func (b *seriesBucket) add(sum float64, count int, min, max float64) {
+	if b.Count == 0 || min < b.Min {
+		b.Min = min
+	}
+	if b.Count == 0 || max > b.Max {
+		b.Max = max
+	}
+	b.Sum += sum
+	b.Count += count
+}
+
+// sensorSeries holds the readings of one value of one sensor
+type sensorSeries struct {
+	Type       string         `json:"type"`
+	Location   string         `json:"location,omitempty"`
+	Name       string         `json:"name,omitempty"`
+	Property   string         `json:"property,omitempty"`
+	Unit       string         `json:"unit,omitempty"`
+	Raw        []seriesSample `json:"raw"`
+	FiveMinute []seriesBucket `json:"five_minute"`
+	Hourly     []seriesBucket `json:"hourly"`
+}
+
+// seriesKey identifies a series
+type seriesKey struct {
+	sensorType, location, name, property string
+}
+
+// seriesFile is the content of the series file
+type seriesFile struct {
+	Series []*sensorSeries `json:"series"`
+}
+
+// SeriesBucket is the aggregate of the readings in one step of a query
+type SeriesBucket struct {
+	Time  int64   `json:"time"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Count int     `json:"count"`
+}
+
+// SeriesResult is the answer to a query for one series
+type SeriesResult struct {
+	Location string         `json:"location,omitempty"`
+	Name     string         `json:"name,omitempty"`
+	Property string         `json:"property,omitempty"`
+	Unit     string         `json:"unit,omitempty"`
+	Buckets  []SeriesBucket `json:"buckets"`
+}
+
+// SeriesQuery selects the readings of a sensor type. Empty Location and
+// Property match every series. Step must be a whole number of seconds.
+type SeriesQuery struct {
+	Type     string
+	Location string
+	Property string
+	From     time.Time
+	To       time.Time
+	Step     time.Duration
+}
+
+// SensorHistory records sensor readings as time series. Raw readings are
+// kept for a short time and aggregated into 5-minute and hourly buckets,
+// which are kept longer.
+type SensorHistory struct {
+	config SeriesConfig
+	now    func() time.Time
+
+	mutex  sync.RWMutex
+	series map[seriesKey]*sensorSeries
+	dirty  bool
+
+	stopCh chan struct{}
+	done   chan struct{}
+}
+
+// NewSensorHistory creates a history and loads the series kept at
+// config.Path
+func NewSensorHistory(config SeriesConfig) (*SensorHistory, error) {
+	if config.RawRetention <= 0 {
+		config.RawRetention = DefaultSeriesRawRetention
+	}
+	if config.FiveMinuteRetention <= 0 {
+		config.FiveMinuteRetention = DefaultSeriesFiveMinuteRetention
+	}
+	if config.HourlyRetention <= 0 {
+		config.HourlyRetention = DefaultSeriesHourlyRetention
+	}
+	if config.SaveInterval <= 0 {
+		config.SaveInterval = DefaultSeriesSaveInterval
+	}
+
+	h := &SensorHistory{
+		config: config,
+		now:    time.Now,
+		series: make(map[seriesKey]*sensorSeries),
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if config.Path == "" {
+		return h, nil
+	}
+
+	data, err := os.ReadFile(config.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return h, nil
+	case err != nil:
+		return nil, fmt.Errorf("could not read sensor history: %w", err)
+	}
+	var file seriesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("could not parse sensor history %s: %w", config.Path, err)
+	}
+	for _, series := range file.Series {
+		h.series[seriesKey{series.Type, series.Location, series.Name, series.Property}] = series
+	}
+	return h, nil
+}
+
+// Start drops expired data and saves the series in the background
+func (h *SensorHistory) Start() {
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(h.config.SaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h.prune()
+				h.save()
+			case <-h.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the background work and saves the series
+func (h *SensorHistory) Stop() {
+	close(h.stopCh)
+	<-h.done
+	h.save()
+}
+
+// Watch records the sensor readings published on bus until stop is closed
+func (h *SensorHistory) Watch(bus *Bus, stop <-chan struct{}) {
+	bus.Follow(stop, func(change Change) {
+		switch change.Kind {
+		case ChangeSensor:
+			if sensor, ok := change.Data.(SensorChange); ok {
+				h.Record(sensor.Type, sensor.Reading, change.Time)
+			}
+		case ChangePeople:
+			readings, _ := change.Data.([]models.PeopleNowPresentSensor)
+			previous, _ := change.Previous.([]models.PeopleNowPresentSensor)
+			for _, reading := range readings {
+				if !containsReading(previous, reading) {
+					h.Record("people_now_present", reading, change.Time)
+				}
+			}
+		}
+	})
+}
+
+// containsReading reports whether readings holds reading unchanged
+func containsReading(readings []models.PeopleNowPresentSensor, reading models.PeopleNowPresentSensor) bool {
+	for _, r := range readings {
+		if r.Location == reading.Location && r.Name == reading.Name {
+			return r.Value == reading.Value && r.Lastchange == reading.Lastchange
+		}
+	}
+	return false
+}
+
+// Record adds the values of a sensor reading taken at t. Plain sensors have
+// one value, structured sensors such as wind one per property. Booleans are
+// recorded as 0 and 1.
+func (h *SensorHistory) Record(sensorType string, reading interface{}, t time.Time) {
+	data, err := json.Marshal(reading)
+	if err != nil {
+		log.Printf("Error recording %s reading: %v", sensorType, err)
+		return
+	}
+	var fields struct {
+		Location   string                     `json:"location"`
+		Name       string                     `json:"name"`
+		Unit       string                     `json:"unit"`
+		Value      interface{}                `json:"value"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		log.Printf("Error recording %s reading: %v", sensorType, err)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if value, ok := sampleValue(fields.Value); ok {
+		h.add(seriesKey{sensorType, fields.Location, fields.Name, ""}, fields.Unit, t, value)
+	}
+	for property, raw := range fields.Properties {
+		var measurement struct {
+			Unit  string      `json:"unit"`
+			Value interface{} `json:"value"`
+		}
+		if json.Unmarshal(raw, &measurement) != nil {
+			continue
+		}
+		if value, ok := sampleValue(measurement.Value); ok {
+			h.add(seriesKey{sensorType, fields.Location, fields.Name, property}, measurement.Unit, t, value)
+		}
+	}
+}
+
+// sampleValue converts a decoded JSON value to a sample
+func sampleValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// add records value in the series key. The mutex must be held.
+func (h *SensorHistory) add(key seriesKey, unit string, t time.Time, value float64) {
+	series, ok := h.series[key]
+	if !ok {
+		series = &sensorSeries{Type: key.sensorType, Location: key.location, Name: key.name, Property: key.property}
+		h.series[key] = series
+	}
+	if unit != "" {
+		series.Unit = unit
+	}
+
+	at := t.Unix()
+	i := sort.Search(len(series.Raw), func(i int) bool { return series.Raw[i].Time > at })
+	series.Raw = append(series.Raw, seriesSample{})
+	copy(series.Raw[i+1:], series.Raw[i:])
+	series.Raw[i] = seriesSample{Time: at, Value: value}
+
+	series.FiveMinute = addToBuckets(series.FiveMinute, at-at%300, value)
+	series.Hourly = addToBuckets(series.Hourly, at-at%3600, value)
+	h.dirty = true
+}
+
+// addToBuckets adds value to the bucket starting at start, creating it if
+// needed
+func addToBuckets(buckets []seriesBucket, start int64, value float64) []seriesBucket {
+	i := sort.Search(len(buckets), func(i int) bool { return buckets[i].Time >= start })
+	if i == len(buckets) || buckets[i].Time != start {
+		buckets = append(buckets, seriesBucket{})
+		copy(buckets[i+1:], buckets[i:])
+		buckets[i] = seriesBucket{Time: start}
+	}
+	buckets[i].add(value, 1, value, value)
+	return buckets
+}
+
+// prune drops the data older than its retention and empty series
+func (h *SensorHistory) prune() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := h.now()
+	rawCutoff := now.Add(-h.config.RawRetention).Unix()
+	fiveMinuteCutoff := now.Add(-h.config.FiveMinuteRetention).Unix()
+	hourlyCutoff := now.Add(-h.config.HourlyRetention).Unix()
+
+	for key, series := range h.series {
+		raw := sort.Search(len(series.Raw), func(i int) bool { return series.Raw[i].Time >= rawCutoff })
+		fiveMinute := sort.Search(len(series.FiveMinute), func(i int) bool { return series.FiveMinute[i].Time+300 > fiveMinuteCutoff })
+		hourly := sort.Search(len(series.Hourly), func(i int) bool { return series.Hourly[i].Time+3600 > hourlyCutoff })
+		if raw+fiveMinute+hourly == 0 {
+			continue
+		}
+		series.Raw = append([]seriesSample(nil), series.Raw[raw:]...)
+		series.FiveMinute = append([]seriesBucket(nil), series.FiveMinute[fiveMinute:]...)
+		series.Hourly = append([]seriesBucket(nil), series.Hourly[hourly:]...)
+		if len(series.Raw)+len(series.FiveMinute)+len(series.Hourly) == 0 {
+			delete(h.series, key)
+		}
+		h.dirty = true
+	}
+}
+
+// save writes the series to disk if they changed
+func (h *SensorHistory) save() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.config.Path == "" || !h.dirty {
+		return
+	}
+	file := seriesFile{Series: make([]*sensorSeries, 0, len(h.series))}
+	for _, series := range h.series {
+		file.Series = append(file.Series, series)
+	}
+	sortSeries(file.Series)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		log.Printf("Error encoding sensor history: %v", err)
+		return
+	}
+	if err := fileutil.WriteFileAtomic(h.config.Path, append(data, '\n')); err != nil {
+		log.Printf("Error saving sensor history: %v", err)
+		return
+	}
+	h.dirty = false
+}
+
+// sortSeries orders series by type, location, name and property
+func sortSeries(series []*sensorSeries) {
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Location != b.Location {
+			return a.Location < b.Location
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Property < b.Property
+	})
+}
+
+// Query aggregates the readings matching query into buckets of query.Step
+// starting at multiples of the step. Steps without readings are left out.
+// The data comes from the finest resolution still covering query.From.
+func (h *SensorHistory) Query(query SeriesQuery) []SeriesResult {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var matching []*sensorSeries
+	for key, series := range h.series {
+		if key.sensorType == query.Type &&
+			(query.Location == "" || key.location == query.Location) &&
+			(query.Property == "" || key.property == query.Property) {
+			matching = append(matching, series)
+		}
+	}
+	sortSeries(matching)
+
+	resolution := h.resolution(query)
+	step := int64(query.Step / time.Second)
+	from, to := query.From.Unix(), query.To.Unix()
+
+	results := make([]SeriesResult, 0, len(matching))
+	for _, series := range matching {
+		var buckets []seriesBucket
+		include := func(t int64, sum float64, count int, min, max float64) {
+			if t < from || t >= to {
+				return
+			}
+			start := t - mod(t, step)
+			if len(buckets) == 0 || buckets[len(buckets)-1].Time != start {
+				buckets = append(buckets, seriesBucket{Time: start})
+			}
+			buckets[len(buckets)-1].add(sum, count, min, max)
+		}
+
+		switch resolution {
+		case 0:
+			for _, sample := range series.Raw {
+				include(sample.Time, sample.Value, 1, sample.Value, sample.Value)
+			}
+		case 300:
+			for _, bucket := range series.FiveMinute {
+				include(bucket.Time, bucket.Sum, bucket.Count, bucket.Min, bucket.Max)
+			}
+		default:
+			for _, bucket := range series.Hourly {
+				include(bucket.Time, bucket.Sum, bucket.Count, bucket.Min, bucket.Max)
+			}
+		}
+
+		result := SeriesResult{
+			Location: series.Location,
+			Name:     series.Name,
+			Property: series.Property,
+			Unit:     series.Unit,
+			Buckets:  make([]SeriesBucket, len(buckets)),
+		}
+		for i, bucket := range buckets {
+			result.Buckets[i] = SeriesBucket{
+				Time:  bucket.Time,
+				Min:   bucket.Min,
+				Max:   bucket.Max,
+				Avg:   bucket.Sum / float64(bucket.Count),
+				Count: bucket.Count,
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// resolution picks the data a query is answered from: raw readings (0),
+// 5-minute (300) or hourly (3600) buckets. The coarsest resolution that
+// divides the step is used if it reaches back to the start of the query,
+// otherwise the finest one that does.
+func (h *SensorHistory) resolution(query SeriesQuery) int64 {
+	now := h.now()
+	tiers := []struct {
+		resolution int64
+		retention  time.Duration
+	}{
+		{3600, h.config.HourlyRetention},
+		{300, h.config.FiveMinuteRetention},
+		{0, h.config.RawRetention},
+	}
+	covers := func(retention time.Duration) bool {
+		return !query.From.Before(now.Add(-retention))
+	}
+
+	step := int64(query.Step / time.Second)
+	for _, tier := range tiers {
+		if (tier.resolution == 0 || step%tier.resolution == 0) && covers(tier.retention) {
+			return tier.resolution
+		}
+	}
+	for i := len(tiers) - 1; i >= 0; i-- {
+		if covers(tiers[i].retention) {
+			return tiers[i].resolution
+		}
+	}
+	return tiers[0].resolution
+}
+
+// mod returns a modulo b, which is never negative
+func mod(a, b int64) int64 {
+	return ((a % b) + b) % b
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/stretchr/testify/suite"
+)
+
+type SensorHistoryTestSuite struct {
+	suite.Suite
+	path    string
+	now     time.Time
+	history *SensorHistory
+}
+
+func (suite *SensorHistoryTestSuite) SetupTest() {
+	suite.path = filepath.Join(suite.T().TempDir(), "sensor-history.json")
+	suite.now = time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	suite.history = suite.newHistory()
+}
+
+func TestSensorHistoryTestSuite(t *testing.T) {
+	suite.Run(t, new(SensorHistoryTestSuite))
+}
+
+func (suite *SensorHistoryTestSuite) newHistory() *SensorHistory {
+	history, err := NewSensorHistory(SeriesConfig{Path: suite.path})
+	suite.Require().NoError(err)
+	history.now = func() time.Time { return suite.now }
+	return history
+}
+
+// temperature records a temperature reading in the Lab minutes after noon
+func (suite *SensorHistoryTestSuite) temperature(minutes int, value float64) {
+	reading := models.TemperatureSensor{Value: value, Unit: "°C", SensorMeta: models.SensorMeta{Location: "Lab"}}
+	suite.history.Record("temperature", reading, suite.now.Add(time.Duration(minutes)*time.Minute))
+}
+
+func (suite *SensorHistoryTestSuite) query(from, to time.Time, step time.Duration) []SeriesResult {
+	return suite.history.Query(SeriesQuery{Type: "temperature", From: from, To: to, Step: step})
+}
+
+func (suite *SensorHistoryTestSuite) TestQueryAggregatesSteps() {
+	suite.temperature(1, 20)
+	suite.temperature(2, 22)
+	suite.temperature(7, 30)
+
+	results := suite.query(suite.now, suite.now.Add(time.Hour), 5*time.Minute)
+
+	suite.Require().Len(results, 1)
+	suite.Assert().Equal("Lab", results[0].Location)
+	suite.Assert().Equal("°C", results[0].Unit)
+	suite.Assert().Equal([]SeriesBucket{
+		{Time: suite.now.Unix(), Min: 20, Max: 22, Avg: 21, Count: 2},
+		{Time: suite.now.Add(5 * time.Minute).Unix(), Min: 30, Max: 30, Avg: 30, Count: 1},
+	}, results[0].Buckets)
+
+	// Coarser steps combine the 5-minute buckets
+	results = suite.query(suite.now.Add(-time.Hour), suite.now.Add(time.Hour), time.Hour)
+	suite.Assert().Equal([]SeriesBucket{{Time: suite.now.Unix(), Min: 20, Max: 30, Avg: 24, Count: 3}}, results[0].Buckets)
+}
+
+func (suite *SensorHistoryTestSuite) TestQueryRange() {
+	suite.temperature(0, 20)
+	suite.temperature(10, 22)
+
+	results := suite.query(suite.now.Add(time.Minute), suite.now.Add(time.Hour), time.Minute)
+
+	suite.Require().Len(results[0].Buckets, 1)
+	suite.Assert().Equal(float64(22), results[0].Buckets[0].Max)
+}
+
+func (suite *SensorHistoryTestSuite) TestDownsampling() {
+	suite.temperature(1, 20)
+	suite.temperature(2, 22)
+	suite.temperature(61, 30)
+
+	// Three days later the raw readings are gone but the aggregates remain
+	suite.now = suite.now.Add(72 * time.Hour)
+	suite.history.prune()
+
+	results := suite.query(suite.now.Add(-80*time.Hour), suite.now, time.Minute)
+	suite.Assert().Equal([]SeriesBucket{
+		{Time: suite.now.Add(-72 * time.Hour).Unix(), Min: 20, Max: 22, Avg: 21, Count: 2},
+		{Time: suite.now.Add(-71 * time.Hour).Unix(), Min: 30, Max: 30, Avg: 30, Count: 1},
+	}, results[0].Buckets)
+
+	// After the 5-minute retention only hourly buckets are left
+	suite.now = suite.now.Add(60 * 24 * time.Hour)
+	suite.history.prune()
+	results = suite.query(suite.now.Add(-70*24*time.Hour), suite.now, 5*time.Minute)
+	suite.Assert().Len(results[0].Buckets, 2)
+
+	// Everything expires eventually
+	suite.now = suite.now.Add(3 * 365 * 24 * time.Hour)
+	suite.history.prune()
+	suite.Assert().Empty(suite.query(suite.now.Add(-time.Hour), suite.now, time.Hour))
+}
+
+func (suite *SensorHistoryTestSuite) TestResolution() {
+	at := func(from time.Time, step time.Duration) int64 {
+		return suite.history.resolution(SeriesQuery{From: from, Step: step})
+	}
+	suite.Assert().Equal(int64(0), at(suite.now.Add(-time.Hour), time.Minute))
+	suite.Assert().Equal(int64(300), at(suite.now.Add(-time.Hour), 10*time.Minute))
+	suite.Assert().Equal(int64(3600), at(suite.now.Add(-time.Hour), 2*time.Hour))
+	// Raw readings are no longer available
+	suite.Assert().Equal(int64(300), at(suite.now.Add(-72*time.Hour), time.Minute))
+	suite.Assert().Equal(int64(3600), at(suite.now.Add(-90*24*time.Hour), time.Minute))
+}
+
+func (suite *SensorHistoryTestSuite) TestStructuredAndBooleanSensors() {
+	suite.history.Record("wind", models.WindSensor{
+		Properties: models.WindProperties{
+			Speed:     models.Measurement{Value: 4.2, Unit: "km/h"},
+			Gust:      models.Measurement{Value: 9.1, Unit: "km/h"},
+			Direction: models.Measurement{Value: 270, Unit: "°"},
+			Elevation: models.Measurement{Value: 42, Unit: "m"},
+		},
+		SensorMeta: models.SensorMeta{Location: "Roof"},
+	}, suite.now)
+	suite.history.Record("door_locked", models.DoorLockedSensor{Value: true, SensorMeta: models.SensorMeta{Location: "Front"}}, suite.now)
+
+	wind := suite.history.Query(SeriesQuery{Type: "wind", From: suite.now, To: suite.now.Add(time.Hour), Step: time.Hour})
+	suite.Require().Len(wind, 4)
+	suite.Assert().Equal("direction", wind[0].Property)
+	suite.Assert().Equal("°", wind[0].Unit)
+
+	speed := suite.history.Query(SeriesQuery{Type: "wind", Property: "speed", From: suite.now, To: suite.now.Add(time.Hour), Step: time.Hour})
+	suite.Require().Len(speed, 1)
+	suite.Assert().Equal(4.2, speed[0].Buckets[0].Avg)
+
+	door := suite.history.Query(SeriesQuery{Type: "door_locked", From: suite.now, To: suite.now.Add(time.Hour), Step: time.Hour})
+	suite.Require().Len(door, 1)
+	suite.Assert().Equal(float64(1), door[0].Buckets[0].Max)
+}
+
+func (suite *SensorHistoryTestSuite) TestLocationFilter() {
+	suite.temperature(0, 20)
+	suite.history.Record("temperature", models.TemperatureSensor{Value: 5, Unit: "°C", SensorMeta: models.SensorMeta{Location: "Outside"}}, suite.now)
+
+	suite.Assert().Len(suite.query(suite.now, suite.now.Add(time.Hour), time.Hour), 2)
+	results := suite.history.Query(SeriesQuery{Type: "temperature", Location: "Outside", From: suite.now, To: suite.now.Add(time.Hour), Step: time.Hour})
+	suite.Require().Len(results, 1)
+	suite.Assert().Equal(float64(5), results[0].Buckets[0].Avg)
+}
+
+func (suite *SensorHistoryTestSuite) TestSurvivesRestart() {
+	suite.temperature(1, 20)
+	suite.history.Start()
+	suite.history.Stop()
+
+	suite.history = suite.newHistory()
+	results := suite.query(suite.now, suite.now.Add(time.Hour), time.Minute)
+	suite.Require().Len(results, 1)
+	suite.Assert().Equal(float64(20), results[0].Buckets[0].Avg)
+}
+
+func (suite *SensorHistoryTestSuite) TestWatch() {
+	bus := NewBus(DefaultBusHistory)
+	stop := make(chan struct{})
+	defer close(stop)
+	suite.history.Watch(bus, stop)
+
+	lab := models.PeopleNowPresentSensor{Value: 3, SensorMeta: models.SensorMeta{Location: "Lab", Lastchange: 100}}
+	hall := models.PeopleNowPresentSensor{Value: 1, SensorMeta: models.SensorMeta{Location: "Hall", Lastchange: 50}}
+	updated := lab
+	updated.Value, updated.Lastchange = 4, 200
+	// Only the changed reading is recorded
+	bus.Publish(ChangePeople, []models.PeopleNowPresentSensor{updated, hall}, []models.PeopleNowPresentSensor{lab, hall})
+	bus.Publish(ChangeSensor, SensorChange{Type: "temperature", Reading: models.TemperatureSensor{Value: 21, Unit: "°C"}}, nil)
+
+	query := func(sensorType string) []SeriesResult {
+		return suite.history.Query(SeriesQuery{Type: sensorType, From: time.Now().Add(-time.Hour), To: time.Now().Add(time.Hour), Step: time.Hour})
+	}
+	suite.Require().Eventually(func() bool {
+		return len(query("temperature")) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	people := query("people_now_present")
+	suite.Require().Len(people, 1)
+	suite.Assert().Equal("Lab", people[0].Location)
+	suite.Assert().Equal(float64(4), people[0].Buckets[0].Avg)
+}
@@ -16,23 +16,76 @@
 package services
 
 import (
+	"bytes"
 	"encoding/json"
-	"github.com/q30-space/spaceapi-endpoint/internal/models"
-	"log"
+	"errors"
+	"fmt"
 	"os"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
 )
 
-// LoadSpaceAPIData loads the SpaceAPI configuration from spaceapi.json
-func LoadSpaceAPIData() *models.SpaceAPI {
-	data, err := os.ReadFile("spaceapi.json")
+// DefaultConfigPath is the SpaceAPI document used when no path is configured
+const DefaultConfigPath = "spaceapi.json"
+
+// LoadSpaceAPIData loads the SpaceAPI configuration from path and validates
+// it against the SpaceAPI schemas
+func LoadSpaceAPIData(path string) (*models.SpaceAPI, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatalf("Fatal error: Could not load spaceapi.json: %v", err)
+		return nil, fmt.Errorf("could not load %s: %w", path, err)
+	}
+
+	spaceAPI, err := ParseSpaceAPIData(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spaceAPI, nil
+}
+
+// ParseSpaceAPIData parses and validates a SpaceAPI document
+func ParseSpaceAPIData(data []byte) (*models.SpaceAPI, error) {
+	// Check the syntax first, then the schema, so type mismatches are
+	// reported with their field path rather than as a decoding error
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, describeJSONError(data, err)
+	}
+
+	if err := ValidateSpaceAPIJSON(data); err != nil {
+		return nil, err
 	}
 
 	var spaceAPI models.SpaceAPI
 	if err := json.Unmarshal(data, &spaceAPI); err != nil {
-		log.Fatalf("Fatal error: Could not parse spaceapi.json: %v", err)
+		return nil, describeJSONError(data, err)
 	}
 
-	return &spaceAPI
+	return &spaceAPI, nil
+}
+
+// describeJSONError adds the line and column to JSON syntax and type errors
+func describeJSONError(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return fmt.Errorf("could not parse JSON: %w", err)
+	}
+
+	// The offset points just past the offending byte
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset > 0 {
+		offset--
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return fmt.Errorf("could not parse JSON at line %d, column %d: %w", line, column, err)
 }
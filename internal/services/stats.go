@@ -0,0 +1,200 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"math"
+	"time"
+)
+
+// OpeningStats summarises when the space was open. Hours are counted in the
+// local time of the space.
+type OpeningStats struct {
+	Timezone            string         `json:"timezone"`
+	From                int64          `json:"from"`
+	To                  int64          `json:"to"`
+	OpenHours           float64        `json:"open_hours"`
+	Sessions            int            `json:"sessions"`
+	AverageSessionHours float64        `json:"average_session_hours"`
+	LongestStreak       Streak         `json:"longest_streak"`
+	Heatmap             []WeekdayHours `json:"heatmap"`
+	Weeks               []PeriodHours  `json:"weeks"`
+	Months              []PeriodHours  `json:"months"`
+}
+
+// Streak is a run of consecutive days on which the space was open
+type Streak struct {
+	Days int    `json:"days"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// WeekdayHours holds the hours the space was open in each hour of a weekday
+type WeekdayHours struct {
+	Weekday string      `json:"weekday"`
+	Hours   [24]float64 `json:"hours"`
+}
+
+// PeriodHours holds the hours the space was open in a week, named by its
+// Monday, or a month
+type PeriodHours struct {
+	Period string  `json:"period"`
+	Hours  float64 `json:"hours"`
+}
+
+// ComputeOpeningStats derives opening statistics between from and to from
+// transitions, which must be ordered and may start before from. A zero from
+// starts at the first transition. A session still open is counted until to.
+func ComputeOpeningStats(transitions []StateTransition, from, to time.Time, location *time.Location) OpeningStats {
+	if from.IsZero() && len(transitions) > 0 {
+		from = time.Unix(transitions[0].Timestamp, 0)
+	}
+	if from.IsZero() || from.After(to) {
+		from = to
+	}
+	from, to = from.In(location), to.In(location)
+
+	stats := OpeningStats{
+		Timezone: location.String(),
+		From:     from.Unix(),
+		To:       to.Unix(),
+		Heatmap:  make([]WeekdayHours, 7),
+	}
+	for i := range stats.Heatmap {
+		stats.Heatmap[i].Weekday = time.Weekday((i + 1) % 7).String()
+	}
+
+	weeks := make(map[string]float64)
+	months := make(map[string]float64)
+	openDays := make(map[string]bool)
+	var sessionHours float64
+
+	for _, session := range openSessions(transitions, from, to) {
+		stats.Sessions++
+		sessionHours += session[1].Sub(session[0]).Hours()
+
+		// Split the session at every hour so each part is counted in its
+		// weekday, hour, week and month
+		for start := session[0]; start.Before(session[1]); {
+			next := time.Date(start.Year(), start.Month(), start.Day(), start.Hour()+1, 0, 0, 0, location)
+			if next.After(session[1]) {
+				next = session[1]
+			}
+			hours := next.Sub(start).Hours()
+
+			stats.OpenHours += hours
+			stats.Heatmap[mondayIndex(start.Weekday())].Hours[start.Hour()] += hours
+			weeks[weekStart(start).Format(time.DateOnly)] += hours
+			months[start.Format("2006-01")] += hours
+			openDays[start.Format(time.DateOnly)] = true
+			start = next
+		}
+	}
+
+	if stats.Sessions > 0 {
+		stats.AverageSessionHours = roundHours(sessionHours / float64(stats.Sessions))
+	}
+	stats.OpenHours = roundHours(stats.OpenHours)
+	for i := range stats.Heatmap {
+		for hour := range stats.Heatmap[i].Hours {
+			stats.Heatmap[i].Hours[hour] = roundHours(stats.Heatmap[i].Hours[hour])
+		}
+	}
+	stats.LongestStreak = longestStreak(openDays, from, to)
+
+	stats.Weeks = []PeriodHours{}
+	for week := weekStart(from); week.Before(to); week = week.AddDate(0, 0, 7) {
+		period := week.Format(time.DateOnly)
+		stats.Weeks = append(stats.Weeks, PeriodHours{Period: period, Hours: roundHours(weeks[period])})
+	}
+	stats.Months = []PeriodHours{}
+	for month := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, location); month.Before(to); month = month.AddDate(0, 1, 0) {
+		period := month.Format("2006-01")
+		stats.Months = append(stats.Months, PeriodHours{Period: period, Hours: roundHours(months[period])})
+	}
+	return stats
+}
+
+// openSessions returns the periods between from and to in which the space
+// was open, as start and end times
+func openSessions(transitions []StateTransition, from, to time.Time) [][2]time.Time {
+	var sessions [][2]time.Time
+	var openedAt time.Time
+	open := false
+	closeAt := func(t time.Time) {
+		if openedAt.Before(from) {
+			openedAt = from
+		}
+		if t.After(to) {
+			t = to
+		}
+		if openedAt.Before(t) {
+			sessions = append(sessions, [2]time.Time{openedAt, t})
+		}
+	}
+
+	for _, transition := range transitions {
+		at := time.Unix(transition.Timestamp, 0).In(from.Location())
+		switch {
+		case transition.Open && !open:
+			open, openedAt = true, at
+		case !transition.Open && open:
+			open = false
+			closeAt(at)
+		}
+	}
+	if open {
+		closeAt(to)
+	}
+	return sessions
+}
+
+// longestStreak finds the longest run of consecutive open days between from
+// and to
+func longestStreak(openDays map[string]bool, from, to time.Time) Streak {
+	var longest, current Streak
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location()); day.Before(to); day = day.AddDate(0, 0, 1) {
+		date := day.Format(time.DateOnly)
+		if !openDays[date] {
+			current = Streak{}
+			continue
+		}
+		if current.Days == 0 {
+			current.From = date
+		}
+		current.Days++
+		current.To = date
+		if current.Days > longest.Days {
+			longest = current
+		}
+	}
+	return longest
+}
+
+// mondayIndex numbers weekdays from Monday (0) to Sunday (6)
+func mondayIndex(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
+
+// weekStart returns the midnight starting the Monday of the week of t
+func weekStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()-mondayIndex(t.Weekday()), 0, 0, 0, 0, t.Location())
+}
+
+// roundHours rounds hours to two decimals
+func roundHours(hours float64) float64 {
+	return math.Round(hours*100) / 100
+}
@@ -0,0 +1,113 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OpeningStatsTestSuite struct {
+	suite.Suite
+	berlin      *time.Location
+	transitions []StateTransition
+}
+
+func (suite *OpeningStatsTestSuite) SetupTest() {
+	var err error
+	suite.berlin, err = time.LoadLocation("Europe/Berlin")
+	suite.Require().NoError(err)
+
+	suite.transitions = []StateTransition{
+		suite.transition("2025-01-06 18:00", true),
+		suite.transition("2025-01-06 22:30", false),
+		// Over midnight
+		suite.transition("2025-01-07 19:00", true),
+		suite.transition("2025-01-08 01:00", false),
+		// Still open
+		suite.transition("2025-01-10 20:00", true),
+	}
+}
+
+func TestOpeningStatsTestSuite(t *testing.T) {
+	suite.Run(t, new(OpeningStatsTestSuite))
+}
+
+// at parses a local time in Berlin
+func (suite *OpeningStatsTestSuite) at(value string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", value, suite.berlin)
+	suite.Require().NoError(err)
+	return t
+}
+
+func (suite *OpeningStatsTestSuite) transition(value string, open bool) StateTransition {
+	return StateTransition{Timestamp: suite.at(value).Unix(), Open: open}
+}
+
+func (suite *OpeningStatsTestSuite) TestStats() {
+	stats := ComputeOpeningStats(suite.transitions, suite.at("2025-01-06 00:00"), suite.at("2025-01-10 23:00"), suite.berlin)
+
+	suite.Assert().Equal("Europe/Berlin", stats.Timezone)
+	suite.Assert().Equal(13.5, stats.OpenHours)
+	suite.Assert().Equal(3, stats.Sessions)
+	suite.Assert().Equal(4.5, stats.AverageSessionHours)
+	suite.Assert().Equal(Streak{Days: 3, From: "2025-01-06", To: "2025-01-08"}, stats.LongestStreak)
+	suite.Assert().Equal([]PeriodHours{{Period: "2025-01-06", Hours: 13.5}}, stats.Weeks)
+	suite.Assert().Equal([]PeriodHours{{Period: "2025-01", Hours: 13.5}}, stats.Months)
+}
+
+func (suite *OpeningStatsTestSuite) TestHeatmapUsesLocalTime() {
+	stats := ComputeOpeningStats(suite.transitions, suite.at("2025-01-06 00:00"), suite.at("2025-01-10 23:00"), suite.berlin)
+
+	suite.Require().Len(stats.Heatmap, 7)
+	suite.Assert().Equal("Monday", stats.Heatmap[0].Weekday)
+	suite.Assert().Equal("Sunday", stats.Heatmap[6].Weekday)
+	suite.Assert().Equal(float64(1), stats.Heatmap[0].Hours[18])
+	suite.Assert().Equal(0.5, stats.Heatmap[0].Hours[22])
+	suite.Assert().Equal(float64(0), stats.Heatmap[0].Hours[17])
+	suite.Assert().Equal(float64(1), stats.Heatmap[1].Hours[23])
+	suite.Assert().Equal(float64(1), stats.Heatmap[2].Hours[0])
+	suite.Assert().Equal(float64(1), stats.Heatmap[4].Hours[22])
+}
+
+func (suite *OpeningStatsTestSuite) TestClipsToRange() {
+	stats := ComputeOpeningStats(suite.transitions, suite.at("2025-01-06 21:00"), suite.at("2025-01-07 20:00"), suite.berlin)
+
+	suite.Assert().Equal(2.5, stats.OpenHours)
+	suite.Assert().Equal(2, stats.Sessions)
+}
+
+func (suite *OpeningStatsTestSuite) TestPeriods() {
+	stats := ComputeOpeningStats(suite.transitions, time.Time{}, suite.at("2025-02-12 00:00"), suite.berlin)
+
+	suite.Assert().Equal(suite.at("2025-01-06 18:00").Unix(), stats.From)
+	suite.Assert().Len(stats.Weeks, 6)
+	suite.Assert().Equal("2025-02-10", stats.Weeks[5].Period)
+	suite.Assert().Equal([]PeriodHours{{Period: "2025-01", Hours: 10.5 + 508}, {Period: "2025-02", Hours: 264}}, stats.Months)
+}
+
+func (suite *OpeningStatsTestSuite) TestEmpty() {
+	to := suite.at("2025-01-10 23:00")
+	stats := ComputeOpeningStats(nil, time.Time{}, to, time.UTC)
+
+	suite.Assert().Equal("UTC", stats.Timezone)
+	suite.Assert().Zero(stats.OpenHours)
+	suite.Assert().Zero(stats.AverageSessionHours)
+	suite.Assert().NotNil(stats.Weeks)
+	suite.Assert().Len(stats.Heatmap, 7)
+}
@@ -0,0 +1,148 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// Store holds the live SpaceAPI document. Readers get an immutable snapshot;
+// writers modify a private copy which then replaces the current snapshot, so
+// a reader never observes a half-applied update.
+type Store struct {
+	current   *models.SpaceAPI
+	revision  Revision
+	mutex     sync.RWMutex // guards current and revision
+	writeMu   sync.Mutex   // serializes updates
+	persister *Persister
+}
+
+// Revision identifies a committed version of the document. Numbers start at
+// 1 when the store is created and grow with every update.
+type Revision struct {
+	Number   uint64
+	Modified time.Time
+}
+
+// NewStore creates a store serving spaceAPI. If persister is not nil, every
+// committed update is written back to disk through it.
+func NewStore(spaceAPI *models.SpaceAPI, persister *Persister) *Store {
+	return &Store{
+		current:   spaceAPI,
+		revision:  Revision{Number: 1, Modified: initialModified(spaceAPI, persister)},
+		persister: persister,
+	}
+}
+
+// initialModified returns when the document was last changed before the
+// store was created: the later of state.lastchange and the modification
+// time of the persister's file. Taking the time of startup instead would
+// make every restart look like a change to clients.
+func initialModified(spaceAPI *models.SpaceAPI, persister *Persister) time.Time {
+	var modified time.Time
+	if spaceAPI.State != nil && spaceAPI.State.Lastchange > 0 {
+		modified = time.Unix(spaceAPI.State.Lastchange, 0)
+	}
+	if persister != nil {
+		if info, err := os.Stat(persister.Path()); err == nil && info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	if modified.IsZero() {
+		return time.Now()
+	}
+	return modified
+}
+
+// Snapshot returns the current document. The returned value is shared
+// between readers and must not be modified.
+func (s *Store) Snapshot() *models.SpaceAPI {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.current
+}
+
+// Current returns the current document together with its revision. The
+// returned document must not be modified.
+func (s *Store) Current() (*models.SpaceAPI, Revision) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.current, s.revision
+}
+
+// Update applies fn to a copy of the current document and, if fn returns no
+// error and the result is a valid SpaceAPI document, makes the copy the
+// current document. It returns the new snapshot. Schema violations are
+// reported as *ValidationError.
+func (s *Store) Update(fn func(spaceAPI *models.SpaceAPI) error) (*models.SpaceAPI, error) {
+	return s.UpdateAndPublish(fn, nil)
+}
+
+// UpdateAndPublish is like Update, but calls publish, if not nil, with the
+// committed document before the next update can start. Changes published
+// from there reach subscribers in the order they were committed.
+//
+// publish runs with updates blocked, so it must not wait for slow work. Bus
+// followers queue changes and only make publishing wait once their queue is
+// full, which holds up further updates until they catch up or are stopped.
+func (s *Store) UpdateAndPublish(fn func(spaceAPI *models.SpaceAPI) error, publish func(spaceAPI *models.SpaceAPI)) (*models.SpaceAPI, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	next, err := cloneSpaceAPI(s.Snapshot())
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	if err := ValidateSpaceAPI(next); err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	s.current = next
+	s.revision = Revision{Number: s.revision.Number + 1, Modified: time.Now()}
+	s.mutex.Unlock()
+
+	if s.persister != nil {
+		s.persister.Schedule(next)
+	}
+	if publish != nil {
+		publish(next)
+	}
+
+	return next, nil
+}
+
+// cloneSpaceAPI returns a deep copy of the document
+func cloneSpaceAPI(spaceAPI *models.SpaceAPI) (*models.SpaceAPI, error) {
+	data, err := json.Marshal(spaceAPI)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone models.SpaceAPI
+	if err := json.Unmarshal(data, &clone); err != nil {
+		return nil, err
+	}
+	return &clone, nil
+}
@@ -0,0 +1,233 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type StoreTestSuite struct {
+	suite.Suite
+	store *Store
+}
+
+func (suite *StoreTestSuite) SetupTest() {
+	suite.store = NewStore(testutil.NewMockSpaceAPI(), nil)
+}
+
+func TestStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(StoreTestSuite))
+}
+
+func (suite *StoreTestSuite) TestUpdate_ReplacesSnapshot() {
+	before := suite.store.Snapshot()
+
+	after, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Message = "Updated"
+		return nil
+	})
+
+	suite.Require().NoError(err)
+	suite.Assert().Equal("Updated", after.State.Message)
+	suite.Assert().Equal("Updated", suite.store.Snapshot().State.Message)
+	// Earlier snapshots are never modified
+	suite.Assert().Equal("Space is open for testing", before.State.Message)
+}
+
+func (suite *StoreTestSuite) TestUpdate_ErrorDiscardsChanges() {
+	_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Message = "Discarded"
+		return errors.New("rejected")
+	})
+
+	suite.Assert().EqualError(err, "rejected")
+	suite.Assert().Equal("Space is open for testing", suite.store.Snapshot().State.Message)
+}
+
+func (suite *StoreTestSuite) TestUpdate_AdvancesRevision() {
+	_, before := suite.store.Current()
+	suite.Assert().Equal(uint64(1), before.Number)
+
+	after, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Message = "Updated"
+		return nil
+	})
+	suite.Require().NoError(err)
+
+	snapshot, revision := suite.store.Current()
+	suite.Assert().Same(after, snapshot)
+	suite.Assert().Equal(uint64(2), revision.Number)
+	suite.Assert().False(revision.Modified.Before(before.Modified))
+
+	// Rejected updates keep the revision
+	_, err = suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+		return errors.New("rejected")
+	})
+	suite.Require().Error(err)
+	_, unchanged := suite.store.Current()
+	suite.Assert().Equal(revision, unchanged)
+}
+
+func (suite *StoreTestSuite) TestNewStore_ModifiedSurvivesRestart() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.State.Lastchange = 1700000000
+
+	_, revision := NewStore(spaceAPI, nil).Current()
+	suite.Assert().Equal(time.Unix(1700000000, 0), revision.Modified)
+
+	// A later edit of the file counts as well
+	path := filepath.Join(suite.T().TempDir(), "spaceapi.json")
+	suite.Require().NoError(SaveSpaceAPIData(path, spaceAPI))
+	edited := time.Unix(1700003600, 0)
+	suite.Require().NoError(os.Chtimes(path, edited, edited))
+
+	_, revision = NewStore(spaceAPI, NewPersister(path, time.Hour)).Current()
+	suite.Assert().True(edited.Equal(revision.Modified))
+
+	// Without either, the store starts now
+	spaceAPI.State = nil
+	_, revision = NewStore(spaceAPI, nil).Current()
+	suite.Assert().WithinDuration(time.Now(), revision.Modified, time.Minute)
+}
+
+func (suite *StoreTestSuite) TestUpdate_SchedulesPersistence() {
+	path := filepath.Join(suite.T().TempDir(), "spaceapi.json")
+	persister := NewPersister(path, time.Hour)
+	store := NewStore(testutil.NewMockSpaceAPI(), persister)
+
+	_, err := store.Update(func(spaceAPI *models.SpaceAPI) error {
+		spaceAPI.State.Open = models.BoolPtr(false)
+		return nil
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(persister.Flush())
+
+	loaded, err := LoadSpaceAPIData(path)
+	suite.Require().NoError(err)
+	suite.Assert().False(*loaded.State.Open)
+}
+
+func (suite *StoreTestSuite) TestConcurrentUpdates() {
+	const workers = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			_, err := suite.store.Update(func(spaceAPI *models.SpaceAPI) error {
+				spaceAPI.Events = append(spaceAPI.Events, models.Event{
+					Name: fmt.Sprintf("Event %d", i),
+					Type: "test",
+				})
+				return nil
+			})
+			suite.Assert().NoError(err)
+		}(i)
+		go func() {
+			defer wg.Done()
+			snapshot := suite.store.Snapshot()
+			suite.Assert().NotEmpty(snapshot.Space)
+			suite.Assert().NotEmpty(snapshot.Events)
+		}()
+	}
+	wg.Wait()
+
+	// No update is lost: the mock event plus one per worker
+	suite.Assert().Len(suite.store.Snapshot().Events, workers+1)
+}
+
+func (suite *StoreTestSuite) TestUpdateAndPublish_PublishesInCommitOrder() {
+	const workers = 50
+	bus := NewBus(workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := suite.store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+				spaceAPI.Sensors.PeopleNowPresent[0].Value++
+				return nil
+			}, func(spaceAPI *models.SpaceAPI) {
+				bus.Publish(ChangePeople, spaceAPI.Sensors.PeopleNowPresent[0].Value, nil)
+			})
+			suite.Assert().NoError(err)
+		}()
+	}
+	wg.Wait()
+
+	sub := bus.Subscribe(0)
+	defer sub.Close()
+	suite.Require().Len(sub.Missed, workers)
+	for i, change := range sub.Missed {
+		suite.Assert().Equal(sub.Missed[0].Data.(int)+i, change.Data)
+	}
+}
+
+func (suite *StoreTestSuite) TestUpdateAndPublish_StuckFollower() {
+	bus := NewBus(DefaultBusHistory)
+	stop := make(chan struct{})
+	stuck := make(chan struct{})
+	defer close(stuck)
+	bus.Follow(stop, func(Change) {
+		<-stuck
+	})
+	update := func() {
+		_, err := suite.store.UpdateAndPublish(func(spaceAPI *models.SpaceAPI) error {
+			spaceAPI.Sensors.PeopleNowPresent[0].Value++
+			return nil
+		}, func(spaceAPI *models.SpaceAPI) {
+			bus.Publish(ChangePeople, spaceAPI.Sensors.PeopleNowPresent[0].Value, nil)
+		})
+		suite.Assert().NoError(err)
+	}
+
+	// The follower's queue absorbs the changes it has not handled yet
+	for i := 0; i <= followerBuffer; i++ {
+		update()
+	}
+
+	// With the queue full, updates wait until the follower is stopped, as on
+	// shutdown
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		update()
+		update()
+	}()
+	select {
+	case <-done:
+		suite.FailNow("update did not wait for the follower")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		suite.FailNow("stopping the follower did not release updates")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// The schemas follow the official SpaceAPI schemas published at
+// https://github.com/SpaceApi/schema
+//
+//go:embed schemas/*.json
+var schemaFS embed.FS
+
+// SchemaVersions lists the SpaceAPI versions documents are validated against
+var SchemaVersions = []string{"14", "15"}
+
+var (
+	schemas     map[string]*jsonschema.Schema
+	schemasErr  error
+	schemasOnce sync.Once
+)
+
+// loadSchemas compiles the embedded schemas once
+func loadSchemas() (map[string]*jsonschema.Schema, error) {
+	schemasOnce.Do(func() {
+		compiler := jsonschema.NewCompiler()
+		compiler.Draft = jsonschema.Draft7
+		compiled := make(map[string]*jsonschema.Schema)
+
+		for _, version := range SchemaVersions {
+			data, err := schemaFS.ReadFile("schemas/" + version + ".json")
+			if err != nil {
+				schemasErr = err
+				return
+			}
+			url := "https://schema.spaceapi.io/" + version + ".json"
+			if err := compiler.AddResource(url, bytes.NewReader(data)); err != nil {
+				schemasErr = fmt.Errorf("invalid v%s schema: %w", version, err)
+				return
+			}
+			schema, err := compiler.Compile(url)
+			if err != nil {
+				schemasErr = fmt.Errorf("invalid v%s schema: %w", version, err)
+				return
+			}
+			compiled[version] = schema
+		}
+		schemas = compiled
+	})
+	return schemas, schemasErr
+}
+
+// Violation is a single schema violation
+type Violation struct {
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("v%s %s: %s", v.Version, v.Path, v.Message)
+}
+
+// ValidationError is returned when a document does not match the SpaceAPI
+// schemas it claims compatibility with
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Violations)+1)
+	lines = append(lines, fmt.Sprintf("invalid SpaceAPI document (%d violations):", len(e.Violations)))
+	for _, violation := range e.Violations {
+		lines = append(lines, "  - "+violation.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ValidateSpaceAPI validates the document against the schemas of the
+// versions listed in its api_compatibility
+func ValidateSpaceAPI(spaceAPI *models.SpaceAPI) error {
+	data, err := json.Marshal(spaceAPI)
+	if err != nil {
+		return err
+	}
+	return ValidateSpaceAPIJSON(data)
+}
+
+// ValidateSpaceAPIJSON validates a raw JSON document against the schemas of
+// the versions it claims compatibility with
+func ValidateSpaceAPIJSON(data []byte) error {
+	compiled, err := loadSchemas()
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+
+	versions, err := documentVersions(doc)
+	if err != nil {
+		return err
+	}
+
+	var violations []Violation
+	for _, version := range versions {
+		err := compiled[version].Validate(doc)
+		if err == nil {
+			continue
+		}
+		var verr *jsonschema.ValidationError
+		if !errors.As(err, &verr) {
+			return err
+		}
+		violations = append(violations, collectViolations(version, verr)...)
+	}
+
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+	return nil
+}
+
+// documentVersions returns the schema versions a document must satisfy.
+// A document that declares no known version is checked against the latest.
+// Documents in the pre-v14 format, which only carry the deprecated api
+// field, are rejected as the embedded schemas do not describe them.
+func documentVersions(doc interface{}) ([]string, error) {
+	object, _ := doc.(map[string]interface{})
+
+	declared := make(map[string]bool)
+	if compatibility, ok := object["api_compatibility"].([]interface{}); ok {
+		for _, version := range compatibility {
+			if version, ok := version.(string); ok {
+				declared[version] = true
+			}
+		}
+	}
+
+	var versions []string
+	for _, version := range SchemaVersions {
+		if declared[version] {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) > 0 {
+		return versions, nil
+	}
+
+	if api, ok := object["api"]; ok {
+		return nil, &ValidationError{Violations: []Violation{{
+			Version: fmt.Sprint(api),
+			Path:    "api",
+			Message: "unsupported api version, declare api_compatibility " + strings.Join(SchemaVersions, " or ") + " instead",
+		}}}
+	}
+	return []string{SchemaVersions[len(SchemaVersions)-1]}, nil
+}
+
+// collectViolations flattens the error tree into its leaves, which carry the
+// specific reasons a value was rejected
+func collectViolations(version string, err *jsonschema.ValidationError) []Violation {
+	if len(err.Causes) == 0 {
+		return []Violation{{
+			Version: version,
+			Path:    fieldPath(err.InstanceLocation),
+			Message: err.Message,
+		}}
+	}
+
+	var violations []Violation
+	seen := make(map[string]bool)
+	for _, cause := range err.Causes {
+		for _, violation := range collectViolations(version, cause) {
+			key := violation.Path + "\x00" + violation.Message
+			if !seen[key] {
+				seen[key] = true
+				violations = append(violations, violation)
+			}
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations
+}
+
+// fieldPath converts a JSON pointer such as /sensors/temperature/0/unit to
+// the more readable sensors.temperature[0].unit
+func fieldPath(pointer string) string {
+	if pointer == "" || pointer == "/" {
+		return "(root)"
+	}
+
+	var path strings.Builder
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		if _, err := strconv.Atoi(token); err == nil {
+			path.WriteString("[" + token + "]")
+			continue
+		}
+		if path.Len() > 0 {
+			path.WriteString(".")
+		}
+		path.WriteString(token)
+	}
+	return path.String()
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+type ValidateTestSuite struct {
+	suite.Suite
+}
+
+func TestValidateTestSuite(t *testing.T) {
+	suite.Run(t, new(ValidateTestSuite))
+}
+
+func (suite *ValidateTestSuite) TestExampleDocumentIsValid() {
+	_, err := LoadSpaceAPIData(filepath.Join("..", "..", "spaceapi.json.example"))
+	suite.Assert().NoError(err)
+}
+
+func (suite *ValidateTestSuite) TestMockDocumentIsValid() {
+	suite.Assert().NoError(ValidateSpaceAPI(testutil.NewMockSpaceAPI()))
+}
+
+func (suite *ValidateTestSuite) TestValidateSpaceAPIJSON_FieldPaths() {
+	doc := `{
+		"api_compatibility": ["15"],
+		"space": "Test Space",
+		"logo": "https://example.com/logo.png",
+		"url": "https://example.com",
+		"contact": {},
+		"state": {"open": "yes"},
+		"sensors": {
+			"temperature": [{"value": 21.5, "unit": "C", "location": "Lab"}]
+		}
+	}`
+
+	err := ValidateSpaceAPIJSON([]byte(doc))
+
+	var validationErr *ValidationError
+	suite.Require().True(errors.As(err, &validationErr))
+	paths := make([]string, 0, len(validationErr.Violations))
+	for _, violation := range validationErr.Violations {
+		paths = append(paths, violation.Path)
+		suite.Assert().Equal("15", violation.Version)
+	}
+	suite.Assert().ElementsMatch([]string{"sensors.temperature[0].unit", "state.open"}, paths)
+	suite.Assert().Contains(err.Error(), "v15 state.open:")
+}
+
+func (suite *ValidateTestSuite) TestValidateSpaceAPIJSON_MissingRequired() {
+	err := ValidateSpaceAPIJSON([]byte(`{"api_compatibility": ["15"], "space": "Test Space"}`))
+
+	var validationErr *ValidationError
+	suite.Require().True(errors.As(err, &validationErr))
+	suite.Require().Len(validationErr.Violations, 1)
+	suite.Assert().Equal("(root)", validationErr.Violations[0].Path)
+	suite.Assert().Contains(validationErr.Violations[0].Message, "logo")
+}
+
+func (suite *ValidateTestSuite) TestValidateSpaceAPIJSON_Version14() {
+	doc := `{
+		"api": "0.13",
+		"api_compatibility": ["14"],
+		"space": "Test Space",
+		"logo": "https://example.com/logo.png",
+		"url": "https://example.com",
+		"location": {"lat": 1.5, "lon": 2.5},
+		"state": {"open": null},
+		"contact": {"email": "test@example.com"},
+		"issue_report_channels": ["email"]
+	}`
+
+	suite.Assert().NoError(ValidateSpaceAPIJSON([]byte(doc)))
+}
+
+func (suite *ValidateTestSuite) TestValidateSpaceAPIJSON_Version13Unsupported() {
+	doc := `{
+		"api": "0.13",
+		"space": "Test Space",
+		"logo": "https://example.com/logo.png",
+		"url": "https://example.com",
+		"location": {"lat": 1.5, "lon": 2.5},
+		"state": {"open": null},
+		"contact": {"email": "test@example.com"},
+		"issue_report_channels": ["email"]
+	}`
+
+	err := ValidateSpaceAPIJSON([]byte(doc))
+
+	var validationErr *ValidationError
+	suite.Require().True(errors.As(err, &validationErr))
+	suite.Require().Len(validationErr.Violations, 1)
+	suite.Assert().Equal("api", validationErr.Violations[0].Path)
+	suite.Assert().Equal("0.13", validationErr.Violations[0].Version)
+	suite.Assert().Contains(err.Error(), "unsupported api version")
+}
+
+func (suite *ValidateTestSuite) TestValidateSpaceAPIJSON_AllDeclaredVersions() {
+	spaceAPI := testutil.NewMockSpaceAPI()
+	spaceAPI.APICompatibility = []string{"14", "15"}
+
+	err := ValidateSpaceAPI(spaceAPI)
+
+	var validationErr *ValidationError
+	suite.Require().True(errors.As(err, &validationErr))
+	for _, violation := range validationErr.Violations {
+		suite.Assert().Equal("14", violation.Version)
+	}
+}
+
+func (suite *ValidateTestSuite) TestValidateSpaceAPIJSON_UnknownVersionUsesLatest() {
+	err := ValidateSpaceAPIJSON([]byte(`{"api_compatibility": ["99"]}`))
+
+	var validationErr *ValidationError
+	suite.Require().True(errors.As(err, &validationErr))
+	suite.Assert().Equal("15", validationErr.Violations[0].Version)
+}
+
+func (suite *ValidateTestSuite) TestLoadSpaceAPIData_SyntaxErrorPosition() {
+	path := filepath.Join(suite.T().TempDir(), "spaceapi.json")
+	suite.Require().NoError(os.WriteFile(path, []byte("{\n  \"space\": \"Test\",\n  oops\n}"), 0o644))
+
+	_, err := LoadSpaceAPIData(path)
+
+	suite.Require().Error(err)
+	suite.Assert().Contains(err.Error(), "line 3, column 3")
+}
+
+func (suite *ValidateTestSuite) TestLoadSpaceAPIData_MissingFile() {
+	_, err := LoadSpaceAPIData(filepath.Join(suite.T().TempDir(), "missing.json"))
+	suite.Assert().ErrorIs(err, os.ErrNotExist)
+}
+
+func (suite *ValidateTestSuite) TestFieldPath() {
+	suite.Assert().Equal("(root)", fieldPath(""))
+	suite.Assert().Equal("state.open", fieldPath("/state/open"))
+	suite.Assert().Equal("sensors.temperature[0].unit", fieldPath("/sensors/temperature/0/unit"))
+	suite.Assert().Equal("ext_a/b", fieldPath("/ext_a~1b"))
+}
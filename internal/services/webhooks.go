@@ -0,0 +1,473 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/fileutil"
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+)
+
+// Webhook events
+const (
+	WebhookEventOpen   = "open"
+	WebhookEventClosed = "closed"
+)
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Defaults for WebhookConfig
+const (
+	DefaultWebhookAttempts      = 10
+	DefaultWebhookRetryDelay    = 5 * time.Second
+	DefaultWebhookMaxRetryDelay = 10 * time.Minute
+	DefaultWebhookTimeout       = 10 * time.Second
+)
+
+// webhookLogSize is how many finished deliveries are kept in the delivery log
+const webhookLogSize = 100
+
+// SignatureHeader carries the HMAC-SHA256 of the request body, hex encoded
+// and prefixed with "sha256="
+const SignatureHeader = "X-SpaceAPI-Signature"
+
+// WebhookConfig configures a WebhookDispatcher
+type WebhookConfig struct {
+	// URLs receive a POST for every open/close transition
+	URLs []string
+	// Secret is the key used to sign payloads
+	Secret string
+	// QueuePath is where pending deliveries and the delivery log are kept.
+	// An empty path keeps them in memory only.
+	QueuePath string
+	// MaxAttempts is how often a delivery is tried before it is given up
+	MaxAttempts int
+	// RetryDelay is the wait before the first retry. It doubles with every
+	// further attempt, up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// Timeout limits a single attempt
+	Timeout time.Duration
+}
+
+// WebhookPayload is the JSON body sent to webhook receivers
+type WebhookPayload struct {
+	Event         string `json:"event"`
+	Space         string `json:"space"`
+	URL           string `json:"url,omitempty"`
+	Open          bool   `json:"open"`
+	Lastchange    int64  `json:"lastchange,omitempty"`
+	TriggerPerson string `json:"trigger_person,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+// Delivery is one payload sent to one receiver
+type Delivery struct {
+	ID           string          `json:"id"`
+	URL          string          `json:"url"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	Created      time.Time       `json:"created"`
+	NextAttempt  time.Time       `json:"next_attempt"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	Finished     *time.Time      `json:"finished,omitempty"`
+}
+
+// webhookState is the content of the queue file
+type webhookState struct {
+	Queue []*Delivery `json:"queue"`
+	Log   []*Delivery `json:"log"`
+}
+
+// WebhookDispatcher sends signed notifications about open/close transitions.
+// Deliveries are queued on disk and retried with exponential backoff. Each
+// receiver gets its deliveries in order, so a retried "open" never arrives
+// after a later "closed".
+type WebhookDispatcher struct {
+	config WebhookConfig
+	client *http.Client
+
+	mutex sync.Mutex
+	state webhookState
+
+	wake   chan struct{}
+	stopCh chan struct{}
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+// NewWebhookDispatcher creates a dispatcher and loads the deliveries still
+// pending from a previous run
+func NewWebhookDispatcher(config WebhookConfig) (*WebhookDispatcher, error) {
+	if config.Secret == "" {
+		return nil, errors.New("webhooks require a signing secret")
+	}
+	for _, target := range config.URLs {
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q", target)
+		}
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultWebhookAttempts
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = DefaultWebhookRetryDelay
+	}
+	if config.MaxRetryDelay <= 0 {
+		config.MaxRetryDelay = DefaultWebhookMaxRetryDelay
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWebhookTimeout
+	}
+
+	d := &WebhookDispatcher{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		wake:   make(chan struct{}, 1),
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if config.QueuePath != "" {
+		data, err := os.ReadFile(config.QueuePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("could not read webhook queue: %w", err)
+		default:
+			if err := json.Unmarshal(data, &d.state); err != nil {
+				return nil, fmt.Errorf("could not parse webhook queue %s: %w", config.QueuePath, err)
+			}
+		}
+	}
+	return d, nil
+}
+
+// Start begins delivering in the background
+func (d *WebhookDispatcher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	go d.run(ctx)
+}
+
+// Watch queues a notification for every open/close transition published on
+// bus until the dispatcher is stopped. store provides the space name and URL
+// for the payload.
+func (d *WebhookDispatcher) Watch(bus *Bus, store *Store) {
+	bus.Follow(d.stopCh, func(change Change) {
+		if change.Kind != ChangeState {
+			return
+		}
+		state, _ := change.Data.(models.State)
+		previous, _ := change.Previous.(models.State)
+		if !isTransition(previous, state) {
+			return
+		}
+		spaceAPI := store.Snapshot()
+		if err := d.Notify(WebhookPayload{
+			Space:         spaceAPI.Space,
+			URL:           spaceAPI.URL,
+			Open:          *state.Open,
+			Lastchange:    state.Lastchange,
+			TriggerPerson: state.TriggerPerson,
+			Message:       state.Message,
+		}); err != nil {
+			log.Printf("Error queueing webhooks: %v", err)
+		}
+	})
+}
+
+// Stop ends delivery. An attempt in progress is abandoned and stays queued.
+func (d *WebhookDispatcher) Stop() {
+	close(d.stopCh)
+	if d.cancel != nil {
+		d.cancel()
+		<-d.done
+	}
+}
+
+// isTransition reports whether the space went from closed or unknown to open
+// or the other way round
+func isTransition(previous, state models.State) bool {
+	if state.Open == nil {
+		return false
+	}
+	return previous.Open == nil || *previous.Open != *state.Open
+}
+
+// Notify queues payload for every configured receiver
+func (d *WebhookDispatcher) Notify(payload WebhookPayload) error {
+	payload.Event = WebhookEventClosed
+	if payload.Open {
+		payload.Event = WebhookEventOpen
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	now := time.Now()
+	for _, target := range d.config.URLs {
+		id, err := newDeliveryID()
+		if err != nil {
+			d.mutex.Unlock()
+			return err
+		}
+		d.state.Queue = append(d.state.Queue, &Delivery{
+			ID:          id,
+			URL:         target,
+			Event:       payload.Event,
+			Payload:     body,
+			Status:      DeliveryPending,
+			Created:     now,
+			NextAttempt: now,
+		})
+	}
+	d.save()
+	d.mutex.Unlock()
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Deliveries returns the pending deliveries followed by the finished ones,
+// most recent first
+func (d *WebhookDispatcher) Deliveries() []Delivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	deliveries := make([]Delivery, 0, len(d.state.Queue)+len(d.state.Log))
+	for i := len(d.state.Queue) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *d.state.Queue[i])
+	}
+	for i := len(d.state.Log) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *d.state.Log[i])
+	}
+	return deliveries
+}
+
+func (d *WebhookDispatcher) run(ctx context.Context) {
+	defer close(d.done)
+
+	for {
+		wait := d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// deliverDue attempts the due deliveries and returns how long to wait for
+// the next one
+func (d *WebhookDispatcher) deliverDue(ctx context.Context) time.Duration {
+	for _, delivery := range d.due() {
+		code, err := d.attempt(ctx, delivery)
+		if ctx.Err() != nil {
+			return 0
+		}
+		d.record(delivery.ID, code, err)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	wait := time.Hour
+	for _, delivery := range d.heads() {
+		if until := time.Until(delivery.NextAttempt); until < wait {
+			wait = until
+		}
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// due returns copies of the deliveries to attempt now
+func (d *WebhookDispatcher) due() []Delivery {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var due []Delivery
+	now := time.Now()
+	for _, delivery := range d.heads() {
+		if !delivery.NextAttempt.After(now) {
+			due = append(due, *delivery)
+		}
+	}
+	return due
+}
+
+// heads returns the oldest queued delivery of every receiver. The mutex
+// must be held.
+func (d *WebhookDispatcher) heads() []*Delivery {
+	seen := make(map[string]bool)
+	var heads []*Delivery
+	for _, delivery := range d.state.Queue {
+		if !seen[delivery.URL] {
+			seen[delivery.URL] = true
+			heads = append(heads, delivery)
+		}
+	}
+	return heads
+}
+
+// attempt sends a delivery and returns the response status code
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "spaceapi-endpoint")
+	req.Header.Set("X-SpaceAPI-Event", delivery.Event)
+	req.Header.Set("X-SpaceAPI-Delivery", delivery.ID)
+	req.Header.Set(SignatureHeader, SignPayload(d.config.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little of the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt, moving finished deliveries to
+// the log
+func (d *WebhookDispatcher) record(id string, code int, err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	index := -1
+	for i, delivery := range d.state.Queue {
+		if delivery.ID == id {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return
+	}
+	delivery := d.state.Queue[index]
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+		log.Printf("Webhook %s delivered to %s", delivery.Event, delivery.URL)
+	case delivery.Attempts >= d.config.MaxAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.LastError = err.Error()
+		log.Printf("Webhook %s to %s failed after %d attempts: %v", delivery.Event, delivery.URL, delivery.Attempts, err)
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+		log.Printf("Webhook %s to %s failed, retrying at %s: %v", delivery.Event, delivery.URL, delivery.NextAttempt.Format(time.RFC3339), err)
+		d.save()
+		return
+	}
+
+	delivery.Finished = &now
+	d.state.Queue = append(d.state.Queue[:index], d.state.Queue[index+1:]...)
+	d.state.Log = append(d.state.Log, delivery)
+	if len(d.state.Log) > webhookLogSize {
+		d.state.Log = d.state.Log[len(d.state.Log)-webhookLogSize:]
+	}
+	d.save()
+}
+
+// backoff returns the wait after the given number of failed attempts
+func (d *WebhookDispatcher) backoff(attempts int) time.Duration {
+	delay := d.config.RetryDelay
+	for i := 1; i < attempts && delay < d.config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > d.config.MaxRetryDelay {
+		delay = d.config.MaxRetryDelay
+	}
+	return delay
+}
+
+// save writes the queue and log to disk. The mutex must be held.
+func (d *WebhookDispatcher) save() {
+	if d.config.QueuePath == "" {
+		return
+	}
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding webhook queue: %v", err)
+		return
+	}
+	if err := fileutil.WriteFileAtomic(d.config.QueuePath, append(data, '\n')); err != nil {
+		log.Printf("Error saving webhook queue: %v", err)
+	}
+}
+
+// SignPayload returns the signature header value for body
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// newDeliveryID returns a random identifier for a delivery
+func newDeliveryID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2025  pliski@q30.space
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/q30-space/spaceapi-endpoint/internal/models"
+	"github.com/q30-space/spaceapi-endpoint/internal/testutil"
+	"github.com/stretchr/testify/suite"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+// receivedWebhook is a request seen by the test receiver
+type receivedWebhook struct {
+	payload   WebhookPayload
+	event     string
+	signature string
+	body      []byte
+}
+
+type WebhookTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	mutex    sync.Mutex
+	received []receivedWebhook
+	// failures is how many requests are answered with 500 before succeeding
+	failures int
+}
+
+func (suite *WebhookTestSuite) SetupTest() {
+	suite.received = nil
+	suite.failures = 0
+	suite.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		suite.mutex.Lock()
+		defer suite.mutex.Unlock()
+		if suite.failures > 0 {
+			suite.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload WebhookPayload
+		_ = json.Unmarshal(body, &payload)
+		suite.received = append(suite.received, receivedWebhook{
+			payload:   payload,
+			event:     r.Header.Get("X-SpaceAPI-Event"),
+			signature: r.Header.Get(SignatureHeader),
+			body:      body,
+		})
+	}))
+}
+
+func (suite *WebhookTestSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func TestWebhookTestSuite(t *testing.T) {
+	suite.Run(t, new(WebhookTestSuite))
+}
+
+func (suite *WebhookTestSuite) newDispatcher(queuePath string) *WebhookDispatcher {
+	dispatcher, err := NewWebhookDispatcher(WebhookConfig{
+		URLs:        []string{suite.server.URL},
+		Secret:      testWebhookSecret,
+		QueuePath:   queuePath,
+		MaxAttempts: 3,
+		RetryDelay:  10 * time.Millisecond,
+	})
+	suite.Require().NoError(err)
+	return dispatcher
+}
+
+func (suite *WebhookTestSuite) receivedCount() int {
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	return len(suite.received)
+}
+
+func (suite *WebhookTestSuite) waitForReceived(n int) []receivedWebhook {
+	suite.Require().Eventually(func() bool { return suite.receivedCount() >= n }, 2*time.Second, 5*time.Millisecond)
+	suite.mutex.Lock()
+	defer suite.mutex.Unlock()
+	return append([]receivedWebhook(nil), suite.received...)
+}
+
+func (suite *WebhookTestSuite) TestWatch_OnlyOpenTransitions() {
+	dispatcher := suite.newDispatcher("")
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	bus := NewBus(DefaultBusHistory)
+	store := NewStore(testutil.NewMockSpaceAPI(), nil)
+	dispatcher.Watch(bus, store)
+
+	open := models.State{Open: models.BoolPtr(true), Message: "Open"}
+	edited := models.State{Open: models.BoolPtr(true), Message: "Open until late"}
+	closed := models.State{Open: models.BoolPtr(false), TriggerPerson: "Alice"}
+	bus.Publish(ChangeState, open, models.State{Open: models.BoolPtr(false)})
+	bus.Publish(ChangeState, edited, open)
+	bus.Publish(ChangePeople, nil, nil)
+	bus.Publish(ChangeState, closed, edited)
+
+	received := suite.waitForReceived(2)
+	time.Sleep(50 * time.Millisecond)
+	suite.Require().Equal(2, suite.receivedCount())
+
+	suite.Assert().Equal(WebhookEventOpen, received[0].event)
+	suite.Assert().True(received[0].payload.Open)
+	suite.Assert().Equal("Test Space", received[0].payload.Space)
+	suite.Assert().Equal(WebhookEventClosed, received[1].event)
+	suite.Assert().Equal("Alice", received[1].payload.TriggerPerson)
+}
+
+func (suite *WebhookTestSuite) TestDelivery_Signed() {
+	dispatcher := suite.newDispatcher("")
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	suite.Require().NoError(dispatcher.Notify(WebhookPayload{Space: "Test Space", Open: true}))
+
+	received := suite.waitForReceived(1)
+	suite.Assert().Equal(SignPayload(testWebhookSecret, received[0].body), received[0].signature)
+	suite.Assert().NotEqual(SignPayload("other-secret", received[0].body), received[0].signature)
+}
+
+func (suite *WebhookTestSuite) TestDelivery_RetriesWithBackoff() {
+	suite.failures = 2
+	dispatcher := suite.newDispatcher("")
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	suite.Require().NoError(dispatcher.Notify(WebhookPayload{Open: true}))
+	suite.waitForReceived(1)
+
+	suite.Require().Eventually(func() bool {
+		deliveries := dispatcher.Deliveries()
+		return len(deliveries) == 1 && deliveries[0].Status == DeliveryDelivered
+	}, time.Second, 5*time.Millisecond)
+	delivery := dispatcher.Deliveries()[0]
+	suite.Assert().Equal(3, delivery.Attempts)
+	suite.Assert().Equal(http.StatusOK, delivery.ResponseCode)
+}
+
+func (suite *WebhookTestSuite) TestDelivery_GivesUp() {
+	suite.failures = 100
+	dispatcher := suite.newDispatcher("")
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	suite.Require().NoError(dispatcher.Notify(WebhookPayload{Open: true}))
+
+	suite.Require().Eventually(func() bool {
+		deliveries := dispatcher.Deliveries()
+		return len(deliveries) == 1 && deliveries[0].Status == DeliveryFailed
+	}, time.Second, 5*time.Millisecond)
+	delivery := dispatcher.Deliveries()[0]
+	suite.Assert().Equal(3, delivery.Attempts)
+	suite.Assert().Equal(http.StatusInternalServerError, delivery.ResponseCode)
+	suite.Assert().Contains(delivery.LastError, "500")
+}
+
+func (suite *WebhookTestSuite) TestDelivery_InOrderPerReceiver() {
+	suite.failures = 2
+	dispatcher := suite.newDispatcher("")
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	suite.Require().NoError(dispatcher.Notify(WebhookPayload{Open: true}))
+	suite.Require().NoError(dispatcher.Notify(WebhookPayload{Open: false}))
+
+	received := suite.waitForReceived(2)
+	suite.Assert().Equal(WebhookEventOpen, received[0].event)
+	suite.Assert().Equal(WebhookEventClosed, received[1].event)
+}
+
+func (suite *WebhookTestSuite) TestQueue_SurvivesRestart() {
+	queuePath := filepath.Join(suite.T().TempDir(), "webhooks.json")
+
+	// Queued but never started, as if the process stopped right away
+	stopped := suite.newDispatcher(queuePath)
+	suite.Require().NoError(stopped.Notify(WebhookPayload{Open: true}))
+	stopped.Stop()
+	suite.Assert().Equal(0, suite.receivedCount())
+
+	restarted := suite.newDispatcher(queuePath)
+	suite.Require().Len(restarted.Deliveries(), 1)
+	restarted.Start()
+	defer restarted.Stop()
+
+	received := suite.waitForReceived(1)
+	suite.Assert().Equal(WebhookEventOpen, received[0].event)
+
+	// The delivery log is persisted as well
+	suite.Require().Eventually(func() bool {
+		reloaded := suite.newDispatcher(queuePath)
+		deliveries := reloaded.Deliveries()
+		return len(deliveries) == 1 && deliveries[0].Status == DeliveryDelivered
+	}, time.Second, 5*time.Millisecond)
+}
+
+func (suite *WebhookTestSuite) TestNewWebhookDispatcher_InvalidConfig() {
+	_, err := NewWebhookDispatcher(WebhookConfig{URLs: []string{suite.server.URL}})
+	suite.Assert().ErrorContains(err, "secret")
+
+	_, err = NewWebhookDispatcher(WebhookConfig{URLs: []string{"ftp://example.com"}, Secret: testWebhookSecret})
+	suite.Assert().ErrorContains(err, "invalid webhook URL")
+}
+
+func (suite *WebhookTestSuite) TestBackoff() {
+	dispatcher, err := NewWebhookDispatcher(WebhookConfig{
+		Secret:        testWebhookSecret,
+		RetryDelay:    time.Second,
+		MaxRetryDelay: 5 * time.Second,
+	})
+	suite.Require().NoError(err)
+
+	suite.Assert().Equal(time.Second, dispatcher.backoff(1))
+	suite.Assert().Equal(2*time.Second, dispatcher.backoff(2))
+	suite.Assert().Equal(4*time.Second, dispatcher.backoff(3))
+	suite.Assert().Equal(5*time.Second, dispatcher.backoff(4))
+	suite.Assert().Equal(5*time.Second, dispatcher.backoff(40))
+}
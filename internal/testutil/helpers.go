@@ -56,12 +56,14 @@ func NewMockSpaceAPI() *models.SpaceAPI {
 			Twitter: "@testspace",
 		},
 		Sensors: &models.Sensors{
-			PeopleNowPresent: []models.SensorValue{
+			PeopleNowPresent: []models.PeopleNowPresentSensor{
 				{
-					Value:      3,
-					Location:   "Main Space",
-					Name:       "People Counter",
-					Lastchange: now - 300, // 5 minutes ago
+					Value: 3,
+					SensorMeta: models.SensorMeta{
+						Location:   "Main Space",
+						Name:       "People Counter",
+						Lastchange: now - 300, // 5 minutes ago
+					},
 				},
 			},
 		},
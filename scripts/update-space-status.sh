@@ -87,7 +87,7 @@ case $HTTP_CODE in
         exit 1
         ;;
     403)
-        echo "❌ Access forbidden: API key format invalid"
+        echo "❌ Access forbidden: API key lacks the required scope"
         exit 1
         ;;
     429)